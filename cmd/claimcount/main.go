@@ -0,0 +1,53 @@
+// Command claimcount checks that every coupon's stored claimed_count matches
+// its rows in claim_history, and with -fix corrects the ones that do not.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"scalable-coupon-system/internal/coupon"
+	"scalable-coupon-system/internal/shared"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "rewrite mismatched claimed_count values")
+	flag.Parse()
+
+	_ = godotenv.Load()
+
+	cfg := shared.NewConfig()
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	db, err := shared.NewDatabase(cfg)
+	if err != nil {
+		log.Error("failed to connect to database", "err", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	repo := coupon.NewRepository(db, log)
+
+	mismatches, err := repo.VerifyClaimedCounts(context.Background(), *fix)
+	for _, m := range mismatches {
+		fmt.Printf("%s\t%s\tstored=%d\tactual=%d\n", m.TenantID, m.CouponName, m.Stored, m.Actual)
+	}
+	if err != nil {
+		log.Error("failed to verify claimed counts", "err", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case len(mismatches) == 0:
+		fmt.Println("all claimed counts match")
+	case *fix:
+		fmt.Printf("fixed %d coupon(s)\n", len(mismatches))
+	default:
+		fmt.Printf("%d coupon(s) out of step, rerun with -fix to correct\n", len(mismatches))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"scalable-coupon-system/pkg/client"
+	"scalable-coupon-system/pkg/couponapi"
+)
+
+// cli is what every command runs with.
+type cli struct {
+	client *client.Client
+	out    *printer
+	stderr io.Writer
+}
+
+type command func(ctx context.Context, c *cli, args []string) error
+
+var commands = map[string]command{
+	"create":  createCommand,
+	"list":    listCommand,
+	"get":     getCommand,
+	"update":  updateCommand,
+	"release": releaseCommand,
+	"claims":  claimsCommand,
+	"export":  exportCommand,
+}
+
+// newFlags returns a flag set that prints its errors and usage to stderr.
+func (c *cli) newFlags(name string, synopsis string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: couponctl %s %s\n", name, synopsis)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parse parses args and checks that exactly nargs positional arguments
+// remain.
+func parse(flags *flag.FlagSet, args []string, nargs int) error {
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != nargs {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// timeFlag is an optional RFC 3339 timestamp.
+type timeFlag struct {
+	value *time.Time
+}
+
+func (f *timeFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return f.value.Format(time.RFC3339)
+}
+
+func (f *timeFlag) Set(s string) error {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return fmt.Errorf("want RFC 3339, e.g. 2026-01-02T15:04:05Z")
+	}
+	f.value = &t
+	return nil
+}
+
+func createCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlags("create", "-name NAME -amount N [-max-per-user N] [-starts-at TIME] [-ends-at TIME]")
+	var req couponapi.CreateCouponRequest
+	var startsAt, endsAt timeFlag
+	flags.StringVar(&req.Name, "name", "", "coupon name")
+	flags.IntVar(&req.Amount, "amount", 0, "total stock")
+	flags.IntVar(&req.MaxClaimsPerUser, "max-per-user", 0, "claims allowed per user (server default 1)")
+	flags.Var(&startsAt, "starts-at", "reject claims before this time")
+	flags.Var(&endsAt, "ends-at", "reject claims from this time")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	req.StartsAt, req.EndsAt = startsAt.value, endsAt.value
+
+	if err := c.client.CreateCoupon(ctx, req); err != nil {
+		return err
+	}
+	details, err := c.client.GetCoupon(ctx, req.Name, false)
+	if err != nil {
+		return err
+	}
+	return c.out.coupon(details)
+}
+
+func listCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlags("list", "[-prefix P] [-status S] [-limit N] [-cursor C] [-all]")
+	prefix := flags.String("prefix", "", "only names starting with this")
+	status := flags.String("status", "", "active, expired or sold_out")
+	limit := flags.Int("limit", 0, "page size")
+	cursor := flags.String("cursor", "", "continue from a previous page")
+	all := flags.Bool("all", false, "follow every page")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	req := couponapi.ListCouponsRequest{NamePrefix: *prefix, Status: *status, Limit: pageLimit(*limit, *all)}
+	var result couponapi.ListCouponsResponse
+	err := paginate(*cursor, *all, func(cursor string) (string, error) {
+		req.Cursor = cursor
+		page, err := c.client.ListCoupons(ctx, req)
+		result.Coupons = append(result.Coupons, page.Coupons...)
+		result.NextCursor = page.NextCursor
+		return page.NextCursor, err
+	})
+	if err != nil {
+		return err
+	}
+	return c.out.coupons(result)
+}
+
+func getCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlags("get", "[-claimed-by] NAME")
+	claimedBy := flags.Bool("claimed-by", false, fmt.Sprintf("include up to %d claiming users", couponapi.MaxClaimedByDetails))
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	details, err := c.client.GetCoupon(ctx, flags.Arg(0), *claimedBy)
+	if err != nil {
+		return err
+	}
+	return c.out.coupon(details)
+}
+
+func updateCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlags("update", "[-version V] [-amount N] [-max-per-user N] [-starts-at TIME] [-ends-at TIME] NAME")
+	version := flags.Int64("version", 0, "expected coupon version (default: the current one)")
+	amount := flags.Int("amount", 0, "total stock")
+	maxPerUser := flags.Int("max-per-user", 0, "claims allowed per user")
+	var startsAt, endsAt timeFlag
+	flags.Var(&startsAt, "starts-at", "reject claims before this time")
+	flags.Var(&endsAt, "ends-at", "reject claims from this time")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	name := flags.Arg(0)
+
+	// Only flags given on the command line are sent, so the rest keep their
+	// current values.
+	req := couponapi.UpdateCouponRequest{StartsAt: startsAt.value, EndsAt: endsAt.value}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "amount":
+			req.Amount = amount
+		case "max-per-user":
+			req.MaxClaimsPerUser = maxPerUser
+		}
+	})
+
+	// Without -version the update is still checked against concurrent
+	// changes, just from the version read here rather than one the operator
+	// has seen.
+	if *version == 0 {
+		current, err := c.client.GetCoupon(ctx, name, false)
+		if err != nil {
+			return err
+		}
+		*version = current.Version
+	}
+	details, err := c.client.UpdateCoupon(ctx, name, *version, req)
+	if err != nil {
+		return err
+	}
+	return c.out.coupon(details)
+}
+
+func releaseCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlags("release", "NAME USER_ID")
+	if err := parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	name, userID := flags.Arg(0), flags.Arg(1)
+	if err := c.client.ReleaseClaim(ctx, name, userID); err != nil {
+		return err
+	}
+	return c.out.message("released %s's claim on %s", userID, name)
+}
+
+func claimsCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlags("claims", "[-status S] [-limit N] [-cursor C] [-all] USER_ID")
+	status := flags.String("status", "", "claimed, redeemed, voided or expired")
+	limit := flags.Int("limit", 0, "page size")
+	cursor := flags.String("cursor", "", "continue from a previous page")
+	all := flags.Bool("all", false, "follow every page")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	req := couponapi.ListUserClaimsRequest{UserID: flags.Arg(0), Status: *status, Limit: pageLimit(*limit, *all)}
+	var result couponapi.ListUserClaimsResponse
+	err := paginate(*cursor, *all, func(cursor string) (string, error) {
+		req.Cursor = cursor
+		page, err := c.client.ListUserClaims(ctx, req)
+		result.Claims = append(result.Claims, page.Claims...)
+		result.NextCursor = page.NextCursor
+		return page.NextCursor, err
+	})
+	if err != nil {
+		return err
+	}
+	return c.out.userClaims(result)
+}
+
+// exportCommand writes every claim on a coupon, oldest first, as CSV or as
+// one JSON object per line. It ignores -o.
+func exportCommand(ctx context.Context, c *cli, args []string) error {
+	flags := c.newFlags("export", "[-format csv|ndjson] NAME")
+	format := flags.String("format", "csv", "csv or ndjson")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "ndjson" {
+		flags.Usage()
+		return errUsage
+	}
+
+	var write func(claim couponapi.ClaimResponse) error
+	var flush func() error
+	if *format == "csv" {
+		w := csv.NewWriter(c.out.w)
+		if err := w.Write([]string{"id", "user_id", "coupon_name", "status", "order_ref", "claimed_at", "updated_at"}); err != nil {
+			return err
+		}
+		write = func(claim couponapi.ClaimResponse) error {
+			orderRef := ""
+			if claim.OrderRef != nil {
+				orderRef = *claim.OrderRef
+			}
+			return w.Write([]string{
+				strconv.FormatInt(claim.ID, 10),
+				claim.UserID,
+				claim.CouponName,
+				claim.Status,
+				orderRef,
+				claim.ClaimedAt.UTC().Format(time.RFC3339Nano),
+				claim.UpdatedAt.UTC().Format(time.RFC3339Nano),
+			})
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	} else {
+		encoder := json.NewEncoder(c.out.w)
+		write = func(claim couponapi.ClaimResponse) error {
+			return encoder.Encode(claim)
+		}
+		flush = func() error { return nil }
+	}
+
+	req := couponapi.ListCouponClaimsRequest{CouponName: flags.Arg(0), Limit: couponapi.MaxPageSize}
+	err := paginate("", true, func(cursor string) (string, error) {
+		req.Cursor = cursor
+		page, err := c.client.ListCouponClaims(ctx, req)
+		if err != nil {
+			return "", err
+		}
+		for _, claim := range page.Claims {
+			if err := write(claim); err != nil {
+				return "", err
+			}
+		}
+		return page.NextCursor, nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// paginate calls fetch with cursor and, when all is set, again with each
+// returned cursor until the last page.
+func paginate(cursor string, all bool, fetch func(cursor string) (string, error)) error {
+	for {
+		next, err := fetch(cursor)
+		if err != nil || !all || next == "" {
+			return err
+		}
+		cursor = next
+	}
+}
+
+// pageLimit asks for the largest pages when following every page, unless a
+// page size was given.
+func pageLimit(limit int, all bool) int {
+	if limit == 0 && all {
+		return couponapi.MaxPageSize
+	}
+	return limit
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"scalable-coupon-system/pkg/client"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	defaultURL     = "http://localhost:8080"
+	defaultProfile = "default"
+	defaultTimeout = 10 * time.Second
+)
+
+// config says which server couponctl talks to and how it authenticates.
+type config struct {
+	URL     string
+	APIKey  string
+	Token   string
+	Tenant  string
+	Timeout time.Duration
+}
+
+// loadConfig reads the profile file and then lets COUPONCTL_* environment
+// variables override it. Profiles are env files named <profile>.env in
+// COUPONCTL_CONFIG_DIR, or couponctl/ under the user config directory, and use
+// the same keys as the environment. A missing default profile is fine; a
+// missing named one is an error.
+func loadConfig(profile string, getenv func(string) string) (config, error) {
+	if profile == "" {
+		profile = getenv("COUPONCTL_PROFILE")
+	}
+	named := profile != ""
+	if !named {
+		profile = defaultProfile
+	}
+
+	values := map[string]string{}
+	dir, err := configDir(getenv)
+	if err != nil && named {
+		return config{}, err
+	}
+	if err == nil {
+		path := filepath.Join(dir, profile+".env")
+		values, err = godotenv.Read(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && !named:
+			values = map[string]string{}
+		case err != nil:
+			return config{}, fmt.Errorf("read profile %q: %w", profile, err)
+		}
+	}
+
+	get := func(key string) string {
+		if value := getenv(key); value != "" {
+			return value
+		}
+		return values[key]
+	}
+
+	cfg := config{
+		URL:     strings.TrimRight(get("COUPONCTL_URL"), "/"),
+		APIKey:  get("COUPONCTL_API_KEY"),
+		Token:   get("COUPONCTL_TOKEN"),
+		Tenant:  get("COUPONCTL_TENANT"),
+		Timeout: defaultTimeout,
+	}
+	if cfg.URL == "" {
+		cfg.URL = defaultURL
+	}
+	if value := get("COUPONCTL_TIMEOUT"); value != "" {
+		if cfg.Timeout, err = time.ParseDuration(value); err != nil {
+			return config{}, fmt.Errorf("invalid COUPONCTL_TIMEOUT: %w", err)
+		}
+	}
+	if cfg.APIKey == "" && cfg.Token == "" {
+		return config{}, errors.New("no credentials: set COUPONCTL_API_KEY or COUPONCTL_TOKEN")
+	}
+	return cfg, nil
+}
+
+func newClient(cfg config) (*client.Client, error) {
+	return client.New(cfg.URL,
+		client.WithHTTPClient(&http.Client{Timeout: cfg.Timeout}),
+		client.WithAPIKey(cfg.APIKey),
+		client.WithToken(cfg.Token),
+		client.WithTenant(cfg.Tenant),
+	)
+}
+
+func configDir(getenv func(string) string) (string, error) {
+	if dir := getenv("COUPONCTL_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("locate config directory: %w", err)
+	}
+	return filepath.Join(dir, "couponctl"), nil
+}
@@ -0,0 +1,106 @@
+// Command couponctl operates the coupon service through its HTTP API. It
+// creates, lists, inspects, updates and releases coupons, looks up a user's
+// claims and exports a coupon's claims.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+)
+
+const usage = `usage: couponctl [-profile NAME] [-o table|json] COMMAND [FLAGS] [ARGS]
+
+Commands:
+  create   -name NAME -amount N [-max-per-user N] [-starts-at TIME] [-ends-at TIME]
+  list     [-prefix P] [-status active|expired|sold_out] [-limit N] [-all]
+  get      [-claimed-by] NAME
+  update   [-version V] [-amount N] [-max-per-user N] [-starts-at TIME] [-ends-at TIME] NAME
+  release  NAME USER_ID
+  claims   [-status S] [-limit N] [-all] USER_ID
+  export   [-format csv|ndjson] NAME
+
+Flags go before arguments. TIME is RFC 3339, e.g. 2026-01-02T15:04:05Z.
+
+Configuration is read from the profile file <config dir>/couponctl/NAME.env
+(or $COUPONCTL_CONFIG_DIR/NAME.env), and the same variables in the
+environment override it:
+  COUPONCTL_URL        API base URL (default http://localhost:8080)
+  COUPONCTL_API_KEY    admin API key, or
+  COUPONCTL_TOKEN      bearer token
+  COUPONCTL_TENANT     tenant sent in X-Tenant-ID
+  COUPONCTL_TIMEOUT    request timeout (default 10s)
+  COUPONCTL_PROFILE    profile used when -profile is not given
+`
+
+// errUsage reports bad arguments whose explanation has already been printed.
+var errUsage = errors.New("usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr, os.Getenv)
+	stop()
+	os.Exit(code)
+}
+
+// run executes one couponctl invocation and returns its exit code: 0 on
+// success, 1 when the command fails and 2 on bad usage.
+func run(
+	ctx context.Context,
+	args []string,
+	stdout io.Writer,
+	stderr io.Writer,
+	getenv func(string) string,
+) int {
+	global := flag.NewFlagSet("couponctl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	global.Usage = func() { fmt.Fprint(stderr, usage) }
+	profile := global.String("profile", "", "profile to load")
+	output := global.String("o", outputTable, "output format: table or json")
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+	if global.NArg() == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	if *output != outputTable && *output != outputJSON {
+		fmt.Fprintf(stderr, "couponctl: unknown output format %q\n", *output)
+		return 2
+	}
+
+	command, ok := commands[global.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "couponctl: unknown command %q\n\n%s", global.Arg(0), usage)
+		return 2
+	}
+
+	cfg, err := loadConfig(*profile, getenv)
+	if err != nil {
+		fmt.Fprintln(stderr, "couponctl:", err)
+		return 1
+	}
+
+	api, err := newClient(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, "couponctl:", err)
+		return 1
+	}
+	c := &cli{
+		client: api,
+		out:    &printer{w: stdout, format: *output},
+		stderr: stderr,
+	}
+	if err := command(ctx, c, global.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		fmt.Fprintln(stderr, "couponctl:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"scalable-coupon-system/pkg/couponapi"
+)
+
+func TestCouponctl(t *testing.T) {
+	var patched couponapi.UpdateCouponRequest
+	var ifMatch string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/coupons", func(w http.ResponseWriter, r *http.Request) {
+		// Two pages, so -all has to follow the cursor.
+		resp := couponapi.ListCouponsResponse{Coupons: []couponapi.CouponSummaryResponse{{Name: "PROMO_A", Amount: 5}}, NextCursor: "page2"}
+		if r.URL.Query().Get("cursor") == "page2" {
+			resp = couponapi.ListCouponsResponse{Coupons: []couponapi.CouponSummaryResponse{{Name: "PROMO_B", Amount: 3}}}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("GET /api/coupons/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("name") != "PROMO_A" {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(couponapi.ErrorResponse{Code: "coupon_not_found", Message: "coupon not found", RequestID: "req-1"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(couponapi.GetCouponDetailsResponse{Name: "PROMO_A", Amount: 5, Version: 3})
+	})
+	mux.HandleFunc("PATCH /api/coupons/{name}", func(w http.ResponseWriter, r *http.Request) {
+		ifMatch = r.Header.Get("If-Match")
+		_ = json.NewDecoder(r.Body).Decode(&patched)
+		_ = json.NewEncoder(w).Encode(couponapi.GetCouponDetailsResponse{Name: "PROMO_A", Amount: *patched.Amount, Version: 4})
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "admin-key" || r.Header.Get("X-Tenant-ID") != "brand_a" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	// The profile supplies the URL and key; the environment overrides the
+	// tenant.
+	dir := t.TempDir()
+	profile := "COUPONCTL_URL=" + server.URL + "\nCOUPONCTL_API_KEY=admin-key\nCOUPONCTL_TENANT=other\n"
+	if err := os.WriteFile(filepath.Join(dir, "staging.env"), []byte(profile), 0o600); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+	env := map[string]string{"COUPONCTL_CONFIG_DIR": dir, "COUPONCTL_TENANT": "brand_a"}
+	getenv := func(key string) string { return env[key] }
+
+	couponctl := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), args, &stdout, &stderr, getenv)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, out, errOut := couponctl("-profile", "staging", "-o", "json", "list", "-all")
+	if code != 0 {
+		t.Fatalf("Expected list to succeed, got %d: %s", code, errOut)
+	}
+	var list couponapi.ListCouponsResponse
+	if err := json.Unmarshal([]byte(out), &list); err != nil || len(list.Coupons) != 2 || list.NextCursor != "" {
+		t.Errorf("Expected both pages merged into one response, got %q (%v)", out, err)
+	}
+
+	code, _, errOut = couponctl("-profile", "staging", "update", "-amount", "10", "PROMO_A")
+	if code != 0 {
+		t.Fatalf("Expected update to succeed, got %d: %s", code, errOut)
+	}
+	if ifMatch != `"3"` || patched.Amount == nil || *patched.Amount != 10 || patched.MaxClaimsPerUser != nil {
+		t.Errorf("Expected only amount to be sent against version 3, got If-Match %s and %+v", ifMatch, patched)
+	}
+
+	code, _, errOut = couponctl("-profile", "staging", "get", "MISSING")
+	if code != 1 || !strings.Contains(errOut, "coupon_not_found") || !strings.Contains(errOut, "req-1") {
+		t.Errorf("Expected the API error code and request id, got %d: %s", code, errOut)
+	}
+
+	if code, _, _ := couponctl("-profile", "staging", "get"); code != 2 {
+		t.Errorf("Expected a missing argument to be a usage error, got %d", code)
+	}
+	if code, _, errOut := couponctl("-profile", "missing", "list"); code != 1 || !strings.Contains(errOut, "missing") {
+		t.Errorf("Expected an unknown profile to fail, got %d: %s", code, errOut)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"scalable-coupon-system/pkg/couponapi"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// printer renders API responses either as aligned tables or as the JSON the
+// server returned.
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+// print writes v as JSON, or calls table with a tab-separated writer.
+func (p *printer) print(v any, table func(w io.Writer)) error {
+	if p.format == outputJSON {
+		encoder := json.NewEncoder(p.w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+func (p *printer) coupons(resp couponapi.ListCouponsResponse) error {
+	return p.print(resp, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tAMOUNT\tREMAINING\tMAX/USER\tSTARTS\tENDS\tVERSION\tCREATED")
+		for _, c := range resp.Coupons {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\t%d\t%s\n",
+				c.Name, c.Amount, c.RemainingAmount, c.MaxClaimsPerUser,
+				formatOptionalTime(c.StartsAt), formatOptionalTime(c.EndsAt),
+				c.Version, formatTime(c.CreatedAt))
+		}
+		if resp.NextCursor != "" {
+			fmt.Fprintf(w, "\nmore results: -cursor %s, or -all\n", resp.NextCursor)
+		}
+	})
+}
+
+func (p *printer) coupon(resp couponapi.GetCouponDetailsResponse) error {
+	return p.print(resp, func(w io.Writer) {
+		fmt.Fprintf(w, "Name:\t%s\n", resp.Name)
+		fmt.Fprintf(w, "Amount:\t%d\n", resp.Amount)
+		fmt.Fprintf(w, "Remaining:\t%d\n", resp.RemainingAmount)
+		fmt.Fprintf(w, "Claimed:\t%d\n", resp.ClaimedCount)
+		fmt.Fprintf(w, "Reserved:\t%d\n", resp.ReservedCount)
+		fmt.Fprintf(w, "Max per user:\t%d\n", resp.MaxClaimsPerUser)
+		fmt.Fprintf(w, "Starts:\t%s\n", formatOptionalTime(resp.StartsAt))
+		fmt.Fprintf(w, "Ends:\t%s\n", formatOptionalTime(resp.EndsAt))
+		fmt.Fprintf(w, "Version:\t%d\n", resp.Version)
+		fmt.Fprintf(w, "Created:\t%s\n", formatTime(resp.CreatedAt))
+		if resp.ClaimedBy != nil {
+			fmt.Fprintf(w, "Claimed by:\t%s\n", strings.Join(resp.ClaimedBy, ", "))
+		}
+	})
+}
+
+func (p *printer) userClaims(resp couponapi.ListUserClaimsResponse) error {
+	return p.print(resp, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tCOUPON\tSTATUS\tORDER REF\tCLAIMED\tUPDATED")
+		for _, c := range resp.Claims {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+				c.ID, c.CouponName, c.Status, formatOptional(c.OrderRef),
+				formatTime(c.ClaimedAt), formatTime(c.UpdatedAt))
+		}
+		if resp.NextCursor != "" {
+			fmt.Fprintf(w, "\nmore results: -cursor %s, or -all\n", resp.NextCursor)
+		}
+	})
+}
+
+// message prints a confirmation; JSON output gets it as {"message": ...}.
+func (p *printer) message(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	return p.print(map[string]string{"message": msg}, func(w io.Writer) {
+		fmt.Fprintln(w, msg)
+	})
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return formatTime(*t)
+}
+
+func formatOptional(s *string) string {
+	if s == nil || *s == "" {
+		return "-"
+	}
+	return *s
+}
@@ -3,12 +3,18 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"scalable-coupon-system/internal/auth"
 	"scalable-coupon-system/internal/coupon"
+	"scalable-coupon-system/internal/health"
+	"scalable-coupon-system/internal/metrics"
+	"scalable-coupon-system/internal/migrate"
 	"scalable-coupon-system/internal/shared"
+	"scalable-coupon-system/migration"
 	"syscall"
 	"time"
 
@@ -26,15 +32,109 @@ func main() {
 	}
 	defer closeLog()
 
-	db, err := shared.NewDatabase(cfg)
+	registry := metrics.NewRegistry()
+	couponMetrics := coupon.NewMetrics(registry)
+
+	var repo coupon.Repository
+	var checker *health.Checker
+	switch cfg.Storage {
+	case shared.StoragePostgres:
+		db, err := shared.NewDatabase(cfg)
+		if err != nil {
+			log.Error("failed to connect to database", "err", err)
+			return
+		}
+		defer db.Close()
+
+		migrator, err := migrate.New(db, log, migration.FS)
+		if err != nil {
+			log.Error("invalid embedded migrations", "err", err)
+			return
+		}
+
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			if err := runMigrate(context.Background(), migrator, os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				db.Close()
+				closeLog()
+				os.Exit(1)
+			}
+			return
+		}
+
+		if cfg.AutoMigrate {
+			count, err := migrator.Up(context.Background())
+			if err != nil {
+				log.Error("failed to apply migrations", "err", err)
+				return
+			}
+			log.Info("migrations applied", "count", count, "version", migrator.Latest())
+		}
+
+		strategy, err := coupon.NewClaimStrategy(cfg.ClaimStrategy, log)
+		if err != nil {
+			log.Error("invalid claim strategy", "err", err)
+			return
+		}
+		log.Info("claim strategy selected", "strategy", strategy.Name())
+
+		repo = coupon.NewRepositoryWithStrategy(db, log, strategy).WithMetrics(couponMetrics)
+		metrics.RegisterPoolStats(registry, db)
+		checker = health.NewChecker(db, log, migrator.Versions(coupon.SchemaVersion))
+	case shared.StorageMemory:
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			log.Error("migrate needs STORAGE=postgres")
+			return
+		}
+		log.Warn("using in-memory storage, data is lost on restart")
+		repo = coupon.NewMemoryRepository(log)
+		checker = health.NewChecker(nil, log, nil)
+	default:
+		log.Error("invalid STORAGE, want postgres or memory", "storage", cfg.Storage)
+		return
+	}
+
+	jwtKeys, err := auth.ParseKeys(cfg.JWTKeys)
 	if err != nil {
-		log.Error("failed to connect to database", "err", err)
+		log.Error("invalid JWT_KEYS", "err", err)
+		return
+	}
+	if len(jwtKeys) == 0 && len(cfg.AdminAPIKeys) == 0 {
+		log.Error("no credentials configured, set JWT_KEYS or ADMIN_API_KEYS")
 		return
 	}
-	defer db.Close()
 
-	couponHandler := coupon.NewHandler(db, log)
-	router := NewRouter(couponHandler)
+	couponHandler := coupon.NewHandler(coupon.NewService(repo, log), log, coupon.Options{
+		IdempotencyTTL:   cfg.IdempotencyTTL,
+		IdempotencyLease: cfg.IdempotencyLease,
+		ReservationTTL:   cfg.ReservationTTL,
+		Authenticator:    auth.NewAuthenticator(jwtKeys, cfg.AdminAPIKeys),
+		Metrics:          couponMetrics,
+	})
+	router := NewRouter(couponHandler, registry, checker)
+
+	sweepCtx, stopSweeper := context.WithCancel(context.Background())
+	defer stopSweeper()
+	go coupon.NewSweeper(repo, log, cfg.SweepInterval).WithEventRetention(cfg.EventRetention).Run(sweepCtx)
+
+	var sinks []coupon.Sink
+	if cfg.OutboxFile != "" {
+		fileSink, err := coupon.NewFileSink(cfg.OutboxFile)
+		if err != nil {
+			log.Error("invalid OUTBOX_FILE", "err", err)
+			return
+		}
+		defer fileSink.Close()
+		sinks = append(sinks, fileSink)
+	}
+	if cfg.OutboxWebhookURL != "" {
+		sinks = append(sinks, coupon.NewHTTPSink(cfg.OutboxWebhookURL, nil))
+	}
+	if len(sinks) > 0 {
+		go coupon.NewDispatcher(repo, sinks, log, cfg.DispatchInterval).Run(sweepCtx)
+	} else {
+		log.Info("no event sinks configured, events stay in the outbox")
+	}
 
 	srv := &http.Server{
 		Addr:    cfg.AppPort,
@@ -49,14 +149,25 @@ func main() {
 		}
 	}()
 
-	waitForShutdown(srv, log)
+	waitForShutdown(srv, checker, cfg.DrainPeriod, log)
 }
 
-func waitForShutdown(srv *http.Server, log *slog.Logger) {
+// waitForShutdown fails readiness first and keeps serving for drainPeriod, so
+// load balancers stop sending new requests before the listener closes.
+func waitForShutdown(
+	srv *http.Server,
+	checker *health.Checker,
+	drainPeriod time.Duration,
+	log *slog.Logger,
+) {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	<-stop
+	checker.Drain()
+	log.Info("draining before shutdown", "drain_period", drainPeriod)
+	time.Sleep(drainPeriod)
+
 	log.Info("shutting down server...")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"scalable-coupon-system/internal/migrate"
+)
+
+var errMigrateUsage = errors.New("usage: server migrate up | down [steps] | status")
+
+// runMigrate handles `server migrate ...`. down reverts one migration unless
+// told how many.
+func runMigrate(
+	ctx context.Context,
+	migrator *migrate.Migrator,
+	args []string,
+	out io.Writer,
+) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		count, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied %d migration(s), schema at version %d\n", count, migrator.Latest())
+
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return errMigrateUsage
+			}
+			steps = n
+		}
+		count, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "reverted %d migration(s)\n", count)
+
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%03d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return tw.Flush()
+
+	default:
+		return errMigrateUsage
+	}
+	return nil
+}
@@ -3,11 +3,22 @@ package main
 import (
 	"net/http"
 	"scalable-coupon-system/internal/coupon"
+	"scalable-coupon-system/internal/health"
+	"scalable-coupon-system/internal/metrics"
 )
 
-func NewRouter(handler *coupon.Handler) http.Handler {
+func NewRouter(
+	handler *coupon.Handler,
+	registry *metrics.Registry,
+	checker *health.Checker,
+) http.Handler {
+	httpMetrics := metrics.NewHTTPMetrics(registry)
+
 	root := http.NewServeMux()
 	root.Handle("/", handler.Routes())
+	root.Handle("GET /metrics", registry.Handler())
+	root.HandleFunc("GET /healthz", checker.Healthz)
+	root.HandleFunc("GET /readyz", checker.Readyz)
 
-	return root
+	return httpMetrics.Middleware(metrics.RecordRoute(root))
 }
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"scalable-coupon-system/pkg/couponapi"
+)
+
+const (
+	AuthorizationHeader = couponapi.AuthorizationHeader
+	APIKeyHeader        = couponapi.APIKeyHeader
+	bearerPrefix        = "Bearer "
+)
+
+var (
+	ErrMissingCredentials = couponapi.ErrMissingCredentials
+	ErrInvalidAPIKey      = couponapi.ErrInvalidAPIKey
+	ErrMissingSubject     = errors.New("token has no subject")
+	ErrInvalidRole        = errors.New("token has an unknown role")
+)
+
+// Principal is the authenticated caller. Admin API keys yield an admin
+// principal with no UserID and no TenantID.
+type Principal struct {
+	UserID   string
+	Role     Role
+	TenantID string
+}
+
+func (p *Principal) IsAdmin() bool {
+	return p != nil && p.Role == RoleAdmin
+}
+
+// Authenticator resolves a request's credentials to a Principal. A request
+// may carry either an admin API key or a bearer JWT.
+type Authenticator struct {
+	keys         Keys
+	adminAPIKeys [][sha256.Size]byte
+	now          func() time.Time
+}
+
+func NewAuthenticator(keys Keys, adminAPIKeys []string) *Authenticator {
+	a := &Authenticator{
+		keys: keys,
+		now:  time.Now,
+	}
+	for _, key := range adminAPIKeys {
+		if key = strings.TrimSpace(key); key != "" {
+			a.adminAPIKeys = append(a.adminAPIKeys, sha256.Sum256([]byte(key)))
+		}
+	}
+	return a
+}
+
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if apiKey := r.Header.Get(APIKeyHeader); apiKey != "" {
+		return a.authenticateAPIKey(apiKey)
+	}
+
+	value := r.Header.Get(AuthorizationHeader)
+	if value == "" {
+		return nil, ErrMissingCredentials
+	}
+	if len(value) < len(bearerPrefix) || !strings.EqualFold(value[:len(bearerPrefix)], bearerPrefix) {
+		return nil, fmt.Errorf("%w: expected bearer token", ErrInvalidToken)
+	}
+
+	claims, err := a.keys.Verify(strings.TrimSpace(value[len(bearerPrefix):]), a.now())
+	if err != nil {
+		return nil, err
+	}
+
+	role := claims.Role
+	if role == "" {
+		role = RoleUser
+	}
+	switch role {
+	case RoleUser:
+		if claims.Subject == "" {
+			return nil, ErrMissingSubject
+		}
+	case RoleAdmin:
+	default:
+		return nil, ErrInvalidRole
+	}
+
+	return &Principal{
+		UserID:   claims.Subject,
+		Role:     role,
+		TenantID: claims.TenantID,
+	}, nil
+}
+
+// authenticateAPIKey compares digests in constant time, checking every
+// configured key so the match position is not revealed either.
+func (a *Authenticator) authenticateAPIKey(apiKey string) (*Principal, error) {
+	digest := sha256.Sum256([]byte(apiKey))
+	match := 0
+	for _, key := range a.adminAPIKeys {
+		match |= subtle.ConstantTimeCompare(digest[:], key[:])
+	}
+	if match != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	return &Principal{Role: RoleAdmin}, nil
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the authenticated caller, or nil for
+// unauthenticated contexts.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
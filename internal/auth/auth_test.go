@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("test-signing-key-0123456789abcdef")
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	keys := Keys{"k1": testKey}
+
+	sign := func(claims Claims, kid string, key []byte) string {
+		token, err := Sign(claims, kid, key)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return token
+	}
+	valid := Claims{Subject: "user_1", ExpiresAt: now.Add(time.Hour).Unix()}
+
+	claims, err := keys.Verify(sign(valid, "k1", testKey), now)
+	if err != nil {
+		t.Fatalf("Expected valid token to verify, got %v", err)
+	}
+	if claims.Subject != "user_1" {
+		t.Errorf("Expected subject user_1, got %q", claims.Subject)
+	}
+
+	// "none" token carrying the valid claims and no signature.
+	noneToken := encodeSegment([]byte(`{"alg":"none","kid":"k1"}`)) + "." +
+		strings.Split(sign(valid, "k1", testKey), ".")[1] + "."
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"wrong key", sign(valid, "k1", []byte("another-signing-key-0123456789abcdef")), ErrInvalidToken},
+		{"unknown kid", sign(valid, "k2", testKey), ErrInvalidToken},
+		{"alg none", noneToken, ErrInvalidToken},
+		{"malformed", "not-a-token", ErrInvalidToken},
+		{"missing exp", sign(Claims{Subject: "user_1"}, "k1", testKey), ErrInvalidToken},
+		{"expired", sign(Claims{Subject: "user_1", ExpiresAt: now.Add(-time.Hour).Unix()}, "k1", testKey), ErrTokenExpired},
+		{"not yet valid", sign(Claims{Subject: "user_1", NotBefore: now.Add(time.Hour).Unix(), ExpiresAt: now.Add(2 * time.Hour).Unix()}, "k1", testKey), ErrTokenNotYetValid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := keys.Verify(tt.token, now); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("old=" + string(testKey) + ", new=" + string(testKey) + "-rotated")
+	if err != nil {
+		t.Fatalf("Failed to parse keys: %v", err)
+	}
+	if len(keys) != 2 || string(keys["old"]) != string(testKey) {
+		t.Errorf("Unexpected keys: %v", keys)
+	}
+
+	if _, err := ParseKeys("short=secret"); !errors.Is(err, ErrInvalidKeys) {
+		t.Errorf("Expected short key to be rejected, got %v", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := NewAuthenticator(Keys{"": testKey}, []string{"admin-key"})
+
+	authenticate := func(header string, value string) (*Principal, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		return a.Authenticate(req)
+	}
+
+	if _, err := authenticate("", ""); !errors.Is(err, ErrMissingCredentials) {
+		t.Errorf("Expected missing credentials, got %v", err)
+	}
+
+	p, err := authenticate(APIKeyHeader, "admin-key")
+	if err != nil || !p.IsAdmin() {
+		t.Errorf("Expected admin api key to authenticate as admin, got %+v, %v", p, err)
+	}
+	if _, err := authenticate(APIKeyHeader, "wrong-key"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected invalid api key, got %v", err)
+	}
+
+	token, err := Sign(Claims{Subject: "user_1", TenantID: "brand_a", ExpiresAt: time.Now().Add(time.Hour).Unix()}, "", testKey)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	p, err = authenticate(AuthorizationHeader, "Bearer "+token)
+	if err != nil {
+		t.Fatalf("Expected bearer token to authenticate, got %v", err)
+	}
+	if p.UserID != "user_1" || p.Role != RoleUser || p.TenantID != "brand_a" {
+		t.Errorf("Unexpected principal: %+v", p)
+	}
+
+	token, err = Sign(Claims{Role: "owner", ExpiresAt: time.Now().Add(time.Hour).Unix()}, "", testKey)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	if _, err := authenticate(AuthorizationHeader, "Bearer "+token); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("Expected unknown role to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"scalable-coupon-system/pkg/couponapi"
+)
+
+const (
+	algHS256 = "HS256"
+
+	// MinKeyLength is the shortest HMAC secret accepted; HS256 keys shorter
+	// than the hash output weaken the signature.
+	MinKeyLength = 32
+
+	// clockSkew is how far exp and nbf may be off before a token is rejected.
+	clockSkew = 30 * time.Second
+)
+
+var (
+	ErrInvalidToken     = couponapi.ErrInvalidToken
+	ErrTokenExpired     = couponapi.ErrTokenExpired
+	ErrTokenNotYetValid = couponapi.ErrTokenNotYetValid
+	ErrInvalidKeys      = errors.New("invalid signing keys")
+)
+
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+// Claims are the JWT claims the API understands. ExpiresAt is required;
+// TenantID, when set, pins the token to one tenant.
+type Claims struct {
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role,omitempty"`
+	TenantID  string `json:"tenant_id,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Keys maps a key ID to its HMAC secret. Several keys may be live at once so a
+// secret can be rotated without invalidating tokens signed with the old one.
+type Keys map[string][]byte
+
+// ParseKeys reads a comma-separated list of "kid=secret" entries. An entry
+// without "=" is the secret for tokens that carry no kid.
+func ParseKeys(spec string) (Keys, error) {
+	keys := Keys{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, secret := "", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			kid, secret = entry[:i], entry[i+1:]
+		}
+		if len(secret) < MinKeyLength {
+			return nil, fmt.Errorf("%w: key %q shorter than %d bytes", ErrInvalidKeys, kid, MinKeyLength)
+		}
+		if _, ok := keys[kid]; ok {
+			return nil, fmt.Errorf("%w: duplicate key %q", ErrInvalidKeys, kid)
+		}
+		keys[kid] = []byte(secret)
+	}
+	return keys, nil
+}
+
+// Sign issues an HS256 token for claims, tagged with kid.
+func Sign(claims Claims, kid string, key []byte) (string, error) {
+	rawHeader, err := json.Marshal(header{Alg: algHS256, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encodeSegment(rawHeader) + "." + encodeSegment(rawClaims)
+	return signingInput + "." + encodeSegment(signature(signingInput, key)), nil
+}
+
+// Verify checks the token's signature against the key named by its kid and
+// validates its time claims against now.
+func (k Keys) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+	// Only HS256 is accepted, which rules out "none" and algorithm confusion.
+	if h.Alg != algHS256 {
+		return nil, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, h.Alg)
+	}
+	key, ok := k[h.Kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, h.Kid)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal(sig, signature(parts[0]+"."+parts[1], key)) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if now.Add(-clockSkew).Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Unix() < claims.NotBefore {
+		return nil, ErrTokenNotYetValid
+	}
+
+	return &claims, nil
+}
+
+func signature(signingInput string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func encodeSegment(raw []byte) string {
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package coupon
+
+import "scalable-coupon-system/pkg/couponapi"
+
+// The request and response bodies, and the validation rules of requests, are
+// defined in couponapi, which clients import too, so the two cannot drift.
+// They are aliased here for the handler and service.
+type (
+	CreateCouponRequest      = couponapi.CreateCouponRequest
+	UpdateCouponRequest      = couponapi.UpdateCouponRequest
+	ListCouponsRequest       = couponapi.ListCouponsRequest
+	ListCouponClaimsRequest  = couponapi.ListCouponClaimsRequest
+	ListUserClaimsRequest    = couponapi.ListUserClaimsRequest
+	ClaimCouponRequest       = couponapi.ClaimCouponRequest
+	RedeemCouponRequest      = couponapi.RedeemCouponRequest
+	VoidCouponRequest        = couponapi.VoidCouponRequest
+	CreateReservationRequest = couponapi.CreateReservationRequest
+	JoinWaitlistRequest      = couponapi.JoinWaitlistRequest
+
+	GetCouponDetailsResponse = couponapi.GetCouponDetailsResponse
+	CouponSummaryResponse    = couponapi.CouponSummaryResponse
+	ListCouponsResponse      = couponapi.ListCouponsResponse
+	ClaimResponse            = couponapi.ClaimResponse
+	UserClaimResponse        = couponapi.UserClaimResponse
+	ListUserClaimsResponse   = couponapi.ListUserClaimsResponse
+	ListCouponClaimsResponse = couponapi.ListCouponClaimsResponse
+	ReservationResponse      = couponapi.ReservationResponse
+	WaitlistEntryResponse    = couponapi.WaitlistEntryResponse
+	ErrorResponse            = couponapi.ErrorResponse
+
+	ValidationError = couponapi.ValidationError
+	FieldError      = couponapi.FieldError
+)
+
+const (
+	MaxCouponNameLength = couponapi.MaxCouponNameLength
+	MaxCouponAmount     = couponapi.MaxCouponAmount
+	MaxUserIDLength     = couponapi.MaxUserIDLength
+	MaxOrderRefLength   = couponapi.MaxOrderRefLength
+)
@@ -0,0 +1,39 @@
+package coupon_test
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	"scalable-coupon-system/internal/coupon"
+	"scalable-coupon-system/internal/coupon/coupontest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	coupontest.Run(t, func(t *testing.T) coupon.Repository {
+		return coupon.NewMemoryRepository(logger)
+	})
+}
+
+func TestPostgresConformance(t *testing.T) {
+	db := coupon.SetupTestDB(t)
+	defer db.Close()
+	defer coupon.CleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	for _, name := range coupon.ClaimStrategies {
+		t.Run(name, func(t *testing.T) {
+			strategy, err := coupon.NewClaimStrategy(name, logger)
+			if err != nil {
+				t.Fatalf("Failed to build strategy: %v", err)
+			}
+			coupontest.Run(t, func(t *testing.T) coupon.Repository {
+				coupon.CleanupTestDB(t, db)
+				return coupon.NewRepositoryWithStrategy(db, logger, strategy)
+			})
+		})
+	}
+}
@@ -0,0 +1,1019 @@
+// Package coupontest is the conformance suite for coupon.Repository
+// implementations. Every backend must pass Run; the service and handler rely
+// on nothing beyond what it checks.
+package coupontest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"scalable-coupon-system/internal/coupon"
+)
+
+// Factory returns an empty repository. It is called once per check, so each
+// check starts from a clean store.
+type Factory func(t *testing.T) coupon.Repository
+
+type check struct {
+	name string
+	run  func(t *testing.T, repo coupon.Repository)
+}
+
+// Run checks every behavior the coupon API depends on against repositories
+// built by newRepository. Checks run one after another, so backends sharing
+// a database can reset it in the factory.
+func Run(t *testing.T, newRepository Factory) {
+	checks := []check{
+		{"DuplicateCreate", testDuplicateCreate},
+		{"NotFound", testNotFound},
+		{"StockExhaustion", testStockExhaustion},
+		{"FlashSale", testFlashSale},
+		{"SameUserRace", testSameUserRace},
+		{"MixedConcurrency", testMixedConcurrency},
+		{"ValidityWindow", testValidityWindow},
+		{"TenantIsolation", testTenantIsolation},
+		{"UpdateCoupon", testUpdateCoupon},
+		{"ClaimLifecycle", testClaimLifecycle},
+		{"Reservations", testReservations},
+		{"Waitlist", testWaitlist},
+		{"ListCoupons", testListCoupons},
+		{"ListClaims", testListClaims},
+		{"Idempotency", testIdempotency},
+		{"Outbox", testOutbox},
+	}
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newRepository(t))
+		})
+	}
+}
+
+const concurrentRequests = 50
+
+func insertCoupon(t *testing.T, ctx context.Context, repo coupon.Repository, c coupon.Coupons) {
+	t.Helper()
+	if c.MaxClaimsPerUser == 0 {
+		c.MaxClaimsPerUser = coupon.DefaultMaxClaimsPerUser
+	}
+	if err := repo.InsertCoupon(ctx, c); err != nil {
+		t.Fatalf("Failed to insert coupon %s: %v", c.Name, err)
+	}
+}
+
+func claim(ctx context.Context, repo coupon.Repository, couponName string, userID string) error {
+	return repo.ClaimCoupon(ctx, coupon.ClaimCouponRequest{UserId: userID, CouponName: couponName})
+}
+
+func details(t *testing.T, ctx context.Context, repo coupon.Repository, couponName string) *coupon.Details {
+	t.Helper()
+	d, err := repo.GetCouponDetails(ctx, couponName, coupon.MaxClaimedByDetails)
+	if err != nil {
+		t.Fatalf("Failed to get coupon %s: %v", couponName, err)
+	}
+	return d
+}
+
+func expectError(t *testing.T, what string, err error, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s: expected %v, got %v", what, want, err)
+	}
+}
+
+func expectStock(t *testing.T, d *coupon.Details, claimed int, reserved int, remaining int) {
+	t.Helper()
+	if d.ClaimedCount != claimed || d.ReservedCount != reserved || d.RemainingAmount != remaining {
+		t.Errorf("Expected %s to have %d claimed, %d reserved and %d remaining, got %d, %d and %d",
+			d.Name, claimed, reserved, remaining, d.ClaimedCount, d.ReservedCount, d.RemainingAmount)
+	}
+}
+
+// concurrently runs fn(i) for i in [0, n) at once and returns how many calls
+// succeeded, with the failures counted by error.
+func concurrently(n int, fn func(i int) error) (int, map[string]int) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	successes := 0
+	failures := map[string]int{}
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := fn(i)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures[err.Error()]++
+			} else {
+				successes++
+			}
+		}()
+	}
+	wg.Wait()
+
+	return successes, failures
+}
+
+func testDuplicateCreate(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+
+	created, failures := concurrently(concurrentRequests/5, func(int) error {
+		return repo.InsertCoupon(ctx, coupon.Coupons{Name: "PROMO_DUP", Amount: 5, MaxClaimsPerUser: 1})
+	})
+	if created != 1 || failures[coupon.ErrCouponAlreadyExists.Error()] != concurrentRequests/5-1 {
+		t.Errorf("Expected exactly one create to win and the rest to report a duplicate, got %d and %v", created, failures)
+	}
+
+	err := repo.InsertCoupon(ctx, coupon.Coupons{Name: "PROMO_DUP", Amount: 9, MaxClaimsPerUser: 1})
+	expectError(t, "second create", err, coupon.ErrCouponAlreadyExists)
+
+	exists, err := repo.CheckCouponExist(ctx, "PROMO_DUP")
+	if err != nil || !exists {
+		t.Errorf("Expected the coupon to exist, got %t, %v", exists, err)
+	}
+	if d := details(t, ctx, repo, "PROMO_DUP"); d.Amount != 5 || d.Version != 1 {
+		t.Errorf("Expected the first create to be kept at version 1, got amount %d version %d", d.Amount, d.Version)
+	}
+}
+
+func testNotFound(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	const missing = "PROMO_MISSING"
+
+	exists, err := repo.CheckCouponExist(ctx, missing)
+	if err != nil || exists {
+		t.Errorf("Expected a missing coupon not to exist, got %t, %v", exists, err)
+	}
+
+	_, err = repo.GetCouponDetails(ctx, missing, 0)
+	expectError(t, "GetCouponDetails", err, coupon.ErrCouponNotFound)
+	expectError(t, "ClaimCoupon", claim(ctx, repo, missing, "user_1"), coupon.ErrCouponNotFound)
+	amount := 3
+	err = repo.UpdateCoupon(ctx, missing, 1, coupon.UpdateCouponRequest{Amount: &amount})
+	expectError(t, "UpdateCoupon", err, coupon.ErrCouponNotFound)
+	expectError(t, "ReleaseClaim", repo.ReleaseClaim(ctx, missing, "user_1"), coupon.ErrCouponNotFound)
+	_, err = repo.CreateReservation(ctx, missing, "user_1", time.Minute)
+	expectError(t, "CreateReservation", err, coupon.ErrCouponNotFound)
+	_, err = repo.JoinWaitlist(ctx, missing, "user_1")
+	expectError(t, "JoinWaitlist", err, coupon.ErrCouponNotFound)
+	expectError(t, "LeaveWaitlist", repo.LeaveWaitlist(ctx, missing, "user_1"), coupon.ErrCouponNotFound)
+
+	_, err = repo.RedeemClaim(ctx, coupon.RedeemCouponRequest{UserId: "user_1", CouponName: missing, OrderRef: "order_1"})
+	expectError(t, "RedeemClaim", err, coupon.ErrClaimNotFound)
+	_, err = repo.VoidClaim(ctx, coupon.VoidCouponRequest{UserId: "user_1", CouponName: missing, OrderRef: "order_1"})
+	expectError(t, "VoidClaim", err, coupon.ErrClaimNotFound)
+	_, err = repo.GetWaitlistEntry(ctx, missing, "user_1")
+	expectError(t, "GetWaitlistEntry", err, coupon.ErrWaitlistEntryNotFound)
+
+	for _, id := range []string{"not-a-uuid", "7b1d2f0e-2c1a-4a53-9a53-5d0c5b7f3e11"} {
+		_, err = repo.GetReservation(ctx, id)
+		expectError(t, "GetReservation "+id, err, coupon.ErrReservationNotFound)
+		_, err = repo.ConfirmReservation(ctx, id)
+		expectError(t, "ConfirmReservation "+id, err, coupon.ErrReservationNotFound)
+		_, err = repo.CancelReservation(ctx, id)
+		expectError(t, "CancelReservation "+id, err, coupon.ErrReservationNotFound)
+	}
+
+	claims, err := repo.ListCouponClaims(ctx, missing, 0, 10)
+	if err != nil || len(claims) != 0 {
+		t.Errorf("Expected no claims on a missing coupon, got %v, %v", claims, err)
+	}
+}
+
+func testStockExhaustion(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_STOCK", Amount: 3})
+
+	for i := 0; i < 3; i++ {
+		if err := claim(ctx, repo, "PROMO_STOCK", fmt.Sprintf("user_%d", i)); err != nil {
+			t.Fatalf("Failed to claim unit %d: %v", i, err)
+		}
+	}
+	expectError(t, "claim past stock", claim(ctx, repo, "PROMO_STOCK", "user_late"), coupon.ErrCouponOutOfStock)
+	_, err := repo.CreateReservation(ctx, "PROMO_STOCK", "user_late", time.Minute)
+	expectError(t, "reservation past stock", err, coupon.ErrCouponOutOfStock)
+
+	d := details(t, ctx, repo, "PROMO_STOCK")
+	expectStock(t, d, 3, 0, 0)
+	if len(d.ClaimedBy) != 3 || d.ClaimedBy[0] != "user_0" || d.ClaimedBy[2] != "user_2" {
+		t.Errorf("Expected claimed_by in claim order, got %v", d.ClaimedBy)
+	}
+
+	limited, err := repo.GetCouponDetails(ctx, "PROMO_STOCK", 2)
+	if err != nil || len(limited.ClaimedBy) != 2 {
+		t.Errorf("Expected claimed_by capped at 2, got %+v, %v", limited, err)
+	}
+	hidden, err := repo.GetCouponDetails(ctx, "PROMO_STOCK", 0)
+	if err != nil || len(hidden.ClaimedBy) != 0 {
+		t.Errorf("Expected no claimed_by with a zero limit, got %+v, %v", hidden, err)
+	}
+}
+
+func testFlashSale(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	stockAmount := 5
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_FLASH", Amount: stockAmount})
+
+	successes, failures := concurrently(concurrentRequests, func(i int) error {
+		return claim(ctx, repo, "PROMO_FLASH", fmt.Sprintf("user_%d", i))
+	})
+
+	if successes != stockAmount || failures[coupon.ErrCouponOutOfStock.Error()] != concurrentRequests-stockAmount {
+		t.Errorf("Expected %d claims and %d out of stock, got %d and %v",
+			stockAmount, concurrentRequests-stockAmount, successes, failures)
+	}
+	expectStock(t, details(t, ctx, repo, "PROMO_FLASH"), stockAmount, 0, 0)
+}
+
+func testSameUserRace(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_DIP", Amount: concurrentRequests})
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_DIP_TWICE", Amount: concurrentRequests, MaxClaimsPerUser: 2})
+
+	for _, tc := range []struct {
+		couponName string
+		limit      int
+	}{
+		{"PROMO_DIP", 1},
+		{"PROMO_DIP_TWICE", 2},
+	} {
+		// Claims and reservations race for the same per-user limit.
+		successes, failures := concurrently(concurrentRequests/5, func(i int) error {
+			if i%2 == 0 {
+				_, err := repo.CreateReservation(ctx, tc.couponName, "user_12345", time.Minute)
+				return err
+			}
+			return claim(ctx, repo, tc.couponName, "user_12345")
+		})
+
+		if successes != tc.limit || failures[coupon.ErrClaimLimitReached.Error()] != concurrentRequests/5-tc.limit {
+			t.Errorf("%s: expected %d successes and the rest at the claim limit, got %d and %v",
+				tc.couponName, tc.limit, successes, failures)
+		}
+		d := details(t, ctx, repo, tc.couponName)
+		if d.ClaimedCount+d.ReservedCount != tc.limit || d.RemainingAmount != concurrentRequests-tc.limit {
+			t.Errorf("%s: expected the user to hold %d units, got %+v", tc.couponName, tc.limit, d)
+		}
+	}
+}
+
+func testMixedConcurrency(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	stockAmount := 10
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "SHARED", Amount: stockAmount})
+
+	var readErrors sync.Map
+	successes, failures := concurrently(concurrentRequests, func(i int) error {
+		own := fmt.Sprintf("MIX_%02d", i)
+		user := fmt.Sprintf("user_%d", i)
+
+		if err := repo.InsertCoupon(ctx, coupon.Coupons{Name: own, Amount: 1, MaxClaimsPerUser: 1}); err != nil {
+			return fmt.Errorf("create: %w", err)
+		}
+		if err := claim(ctx, repo, own, user); err != nil {
+			return fmt.Errorf("claim own: %w", err)
+		}
+
+		sharedErr := claim(ctx, repo, "SHARED", user)
+
+		d, err := repo.GetCouponDetails(ctx, "SHARED", 0)
+		switch {
+		case err != nil:
+			readErrors.Store(i, err)
+		case d.RemainingAmount < 0 || d.ClaimedCount+d.ReservedCount+d.RemainingAmount != stockAmount:
+			readErrors.Store(i, fmt.Errorf("inconsistent stock %+v", d))
+		}
+		if _, err := repo.ListCoupons(ctx, coupon.CouponFilter{NamePrefix: "MIX_", Limit: concurrentRequests}); err != nil {
+			readErrors.Store(i, err)
+		}
+
+		return sharedErr
+	})
+
+	readErrors.Range(func(i, err any) bool {
+		t.Errorf("Read %d during writes failed: %v", i, err)
+		return true
+	})
+	if successes != stockAmount || failures[coupon.ErrCouponOutOfStock.Error()] != concurrentRequests-stockAmount {
+		t.Errorf("Expected %d shared claims and the rest out of stock, got %d and %v", stockAmount, successes, failures)
+	}
+	expectStock(t, details(t, ctx, repo, "SHARED"), stockAmount, 0, 0)
+
+	own, err := repo.ListCoupons(ctx, coupon.CouponFilter{NamePrefix: "MIX_", Limit: concurrentRequests + 1})
+	if err != nil || len(own) != concurrentRequests {
+		t.Fatalf("Expected all %d concurrently created coupons, got %d, %v", concurrentRequests, len(own), err)
+	}
+	for _, c := range own {
+		if c.RemainingAmount != 0 {
+			t.Errorf("Expected %s to be claimed by its creator, got %d remaining", c.Name, c.RemainingAmount)
+		}
+	}
+
+	mismatches, err := repo.VerifyClaimedCounts(ctx, false)
+	if err != nil || len(mismatches) != 0 {
+		t.Errorf("Expected claimed counts to match the claims, got %v, %v", mismatches, err)
+	}
+}
+
+func testValidityWindow(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	now := time.Now()
+	future, past, longAgo := now.Add(time.Hour), now.Add(-time.Hour), now.Add(-2*time.Hour)
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_SOON", Amount: 5, StartsAt: &future})
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_OVER", Amount: 5, StartsAt: &longAgo, EndsAt: &past})
+
+	expectError(t, "claim before start", claim(ctx, repo, "PROMO_SOON", "user_1"), coupon.ErrCouponNotYetActive)
+	expectError(t, "claim after end", claim(ctx, repo, "PROMO_OVER", "user_1"), coupon.ErrCouponExpired)
+	_, err := repo.CreateReservation(ctx, "PROMO_OVER", "user_1", time.Minute)
+	expectError(t, "reservation after end", err, coupon.ErrCouponExpired)
+	_, err = repo.JoinWaitlist(ctx, "PROMO_SOON", "user_1")
+	expectError(t, "waitlist before start", err, coupon.ErrCouponNotYetActive)
+
+	d := details(t, ctx, repo, "PROMO_SOON")
+	err = repo.UpdateCoupon(ctx, "PROMO_SOON", d.Version, coupon.UpdateCouponRequest{EndsAt: &past})
+	expectError(t, "end before start", err, coupon.ErrInvalidValidityWindow)
+
+	// Opening the window makes the coupon claimable.
+	err = repo.UpdateCoupon(ctx, "PROMO_SOON", d.Version, coupon.UpdateCouponRequest{StartsAt: &longAgo})
+	if err != nil {
+		t.Fatalf("Failed to open validity window: %v", err)
+	}
+	if err := claim(ctx, repo, "PROMO_SOON", "user_1"); err != nil {
+		t.Errorf("Expected a claim inside the window to succeed, got %v", err)
+	}
+}
+
+func testTenantIsolation(t *testing.T, repo coupon.Repository) {
+	brandA := coupon.WithTenant(context.Background(), "brand_a")
+	brandB := coupon.WithTenant(context.Background(), "brand_b")
+	insertCoupon(t, brandA, repo, coupon.Coupons{Name: "PROMO_SHARED_NAME", Amount: 1})
+	insertCoupon(t, brandB, repo, coupon.Coupons{Name: "PROMO_SHARED_NAME", Amount: 1})
+	insertCoupon(t, brandA, repo, coupon.Coupons{Name: "PROMO_A_ONLY", Amount: 1})
+
+	if err := claim(brandA, repo, "PROMO_SHARED_NAME", "user_1"); err != nil {
+		t.Fatalf("Failed to claim in brand_a: %v", err)
+	}
+	if err := claim(brandB, repo, "PROMO_SHARED_NAME", "user_1"); err != nil {
+		t.Errorf("Expected brand_b's stock to be separate, got %v", err)
+	}
+
+	_, err := repo.GetCouponDetails(brandB, "PROMO_A_ONLY", 0)
+	expectError(t, "other tenant's coupon", err, coupon.ErrCouponNotFound)
+	expectError(t, "claim other tenant's coupon", claim(brandB, repo, "PROMO_A_ONLY", "user_1"), coupon.ErrCouponNotFound)
+
+	reservation, err := repo.CreateReservation(brandA, "PROMO_A_ONLY", "user_2", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	_, err = repo.GetReservation(brandB, reservation.ID)
+	expectError(t, "other tenant's reservation", err, coupon.ErrReservationNotFound)
+
+	coupons, err := repo.ListCoupons(brandB, coupon.CouponFilter{Limit: 10})
+	if err != nil || len(coupons) != 1 {
+		t.Errorf("Expected brand_b to list only its own coupon, got %v, %v", coupons, err)
+	}
+	claims, err := repo.ListUserClaims(brandB, coupon.UserClaimFilter{UserID: "user_1", Sort: coupon.ClaimSortClaimedAt, Limit: 10})
+	if err != nil || len(claims) != 1 {
+		t.Errorf("Expected brand_b to list only its own claim, got %v, %v", claims, err)
+	}
+}
+
+func testUpdateCoupon(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_UPDATE", Amount: 3})
+	if err := claim(ctx, repo, "PROMO_UPDATE", "user_1"); err != nil {
+		t.Fatalf("Failed to claim: %v", err)
+	}
+	if _, err := repo.CreateReservation(ctx, "PROMO_UPDATE", "user_2", time.Minute); err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+
+	d := details(t, ctx, repo, "PROMO_UPDATE")
+	one, two, five := 1, 2, 5
+	err := repo.UpdateCoupon(ctx, "PROMO_UPDATE", d.Version, coupon.UpdateCouponRequest{Amount: &one})
+	expectError(t, "amount below claimed and reserved", err, coupon.ErrAmountBelowClaimed)
+
+	if err := repo.UpdateCoupon(ctx, "PROMO_UPDATE", d.Version, coupon.UpdateCouponRequest{Amount: &five, MaxClaimsPerUser: &two}); err != nil {
+		t.Fatalf("Failed to update coupon: %v", err)
+	}
+	err = repo.UpdateCoupon(ctx, "PROMO_UPDATE", d.Version, coupon.UpdateCouponRequest{Amount: &two})
+	expectError(t, "stale version", err, coupon.ErrVersionMismatch)
+
+	updated := details(t, ctx, repo, "PROMO_UPDATE")
+	if updated.Version != d.Version+1 || updated.Amount != 5 || updated.MaxClaimsPerUser != 2 {
+		t.Errorf("Expected amount 5, limit 2 at version %d, got %+v", d.Version+1, updated)
+	}
+	expectStock(t, updated, 1, 1, 3)
+
+	if err := claim(ctx, repo, "PROMO_UPDATE", "user_1"); err != nil {
+		t.Errorf("Expected the raised limit to allow a second claim, got %v", err)
+	}
+}
+
+func testClaimLifecycle(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_LIFE", Amount: 5, MaxClaimsPerUser: 2})
+	for i := 0; i < 2; i++ {
+		if err := claim(ctx, repo, "PROMO_LIFE", "user_1"); err != nil {
+			t.Fatalf("Failed to claim: %v", err)
+		}
+	}
+
+	redeemed, err := repo.RedeemClaim(ctx, coupon.RedeemCouponRequest{UserId: "user_1", CouponName: "PROMO_LIFE", OrderRef: "order_1"})
+	if err != nil || redeemed.Status != coupon.ClaimStatusRedeemed || redeemed.OrderRef == nil || *redeemed.OrderRef != "order_1" {
+		t.Fatalf("Expected the claim to be redeemed for order_1, got %+v, %v", redeemed, err)
+	}
+	again, err := repo.RedeemClaim(ctx, coupon.RedeemCouponRequest{UserId: "user_1", CouponName: "PROMO_LIFE", OrderRef: "order_1"})
+	if err != nil || again.ID != redeemed.ID {
+		t.Errorf("Expected redeeming the same order to return the same claim, got %+v, %v", again, err)
+	}
+
+	// The oldest claim was redeemed, so releasing gives back the newer one.
+	if err := repo.ReleaseClaim(ctx, "PROMO_LIFE", "user_1"); err != nil {
+		t.Fatalf("Failed to release claim: %v", err)
+	}
+	expectError(t, "release a redeemed claim", repo.ReleaseClaim(ctx, "PROMO_LIFE", "user_1"), coupon.ErrClaimNotReleasable)
+	expectError(t, "release without a claim", repo.ReleaseClaim(ctx, "PROMO_LIFE", "user_2"), coupon.ErrClaimNotFound)
+	_, err = repo.RedeemClaim(ctx, coupon.RedeemCouponRequest{UserId: "user_1", CouponName: "PROMO_LIFE", OrderRef: "order_2"})
+	expectError(t, "redeem without a free claim", err, coupon.ErrClaimNotRedeemable)
+	expectStock(t, details(t, ctx, repo, "PROMO_LIFE"), 1, 0, 4)
+
+	_, err = repo.VoidClaim(ctx, coupon.VoidCouponRequest{UserId: "user_1", CouponName: "PROMO_LIFE", OrderRef: "order_2"})
+	expectError(t, "void an unknown order", err, coupon.ErrClaimNotFound)
+	voided, err := repo.VoidClaim(ctx, coupon.VoidCouponRequest{UserId: "user_1", CouponName: "PROMO_LIFE", OrderRef: "order_1"})
+	if err != nil || voided.Status != coupon.ClaimStatusVoided || voided.ID != redeemed.ID {
+		t.Errorf("Expected the redeemed claim to be voided, got %+v, %v", voided, err)
+	}
+	if _, err := repo.VoidClaim(ctx, coupon.VoidCouponRequest{UserId: "user_1", CouponName: "PROMO_LIFE", OrderRef: "order_1"}); err != nil {
+		t.Errorf("Expected voiding twice to be a no-op, got %v", err)
+	}
+	_, err = repo.RedeemClaim(ctx, coupon.RedeemCouponRequest{UserId: "user_1", CouponName: "PROMO_LIFE", OrderRef: "order_1"})
+	expectError(t, "redeem a voided order", err, coupon.ErrClaimNotRedeemable)
+
+	// A claim left when the coupon ends expires instead of being redeemed.
+	if err := claim(ctx, repo, "PROMO_LIFE", "user_2"); err != nil {
+		t.Fatalf("Failed to claim: %v", err)
+	}
+	endsAt := time.Now().Add(100 * time.Millisecond)
+	version := details(t, ctx, repo, "PROMO_LIFE").Version
+	if err := repo.UpdateCoupon(ctx, "PROMO_LIFE", version, coupon.UpdateCouponRequest{EndsAt: &endsAt}); err != nil {
+		t.Fatalf("Failed to set end of coupon: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	_, err = repo.RedeemClaim(ctx, coupon.RedeemCouponRequest{UserId: "user_2", CouponName: "PROMO_LIFE", OrderRef: "order_3"})
+	expectError(t, "redeem after end", err, coupon.ErrCouponExpired)
+
+	claims, err := repo.ListCouponClaims(ctx, "PROMO_LIFE", 0, 10)
+	if err != nil || len(claims) != 2 || claims[0].Status != coupon.ClaimStatusVoided || claims[1].Status != coupon.ClaimStatusExpired {
+		t.Errorf("Expected a voided and an expired claim, got %+v, %v", claims, err)
+	}
+}
+
+func testReservations(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_HOLD", Amount: 3})
+
+	confirmed, err := repo.CreateReservation(ctx, "PROMO_HOLD", "user_1", time.Minute)
+	if err != nil || confirmed.Status != coupon.ReservationStatusActive {
+		t.Fatalf("Expected an active reservation, got %+v, %v", confirmed, err)
+	}
+	cancelled, err := repo.CreateReservation(ctx, "PROMO_HOLD", "user_2", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	_, err = repo.CreateReservation(ctx, "PROMO_HOLD", "user_1", time.Minute)
+	expectError(t, "second hold for the same user", err, coupon.ErrClaimLimitReached)
+	expectStock(t, details(t, ctx, repo, "PROMO_HOLD"), 0, 2, 1)
+
+	got, err := repo.GetReservation(ctx, confirmed.ID)
+	if err != nil || got.ID != confirmed.ID || got.UserID != "user_1" || got.CouponName != "PROMO_HOLD" {
+		t.Errorf("Expected to read the reservation back, got %+v, %v", got, err)
+	}
+
+	result, err := repo.ConfirmReservation(ctx, confirmed.ID)
+	if err != nil || result.Status != coupon.ReservationStatusConfirmed || result.ClaimID == nil {
+		t.Fatalf("Expected the reservation to be confirmed into a claim, got %+v, %v", result, err)
+	}
+	if again, err := repo.ConfirmReservation(ctx, confirmed.ID); err != nil || *again.ClaimID != *result.ClaimID {
+		t.Errorf("Expected confirming twice to return the same claim, got %+v, %v", again, err)
+	}
+	_, err = repo.CancelReservation(ctx, confirmed.ID)
+	expectError(t, "cancel a confirmed reservation", err, coupon.ErrReservationNotActive)
+
+	if result, err := repo.CancelReservation(ctx, cancelled.ID); err != nil || result.Status != coupon.ReservationStatusCancelled {
+		t.Fatalf("Expected the reservation to be cancelled, got %+v, %v", result, err)
+	}
+	if _, err := repo.CancelReservation(ctx, cancelled.ID); err != nil {
+		t.Errorf("Expected cancelling twice to be a no-op, got %v", err)
+	}
+	_, err = repo.ConfirmReservation(ctx, cancelled.ID)
+	expectError(t, "confirm a cancelled reservation", err, coupon.ErrReservationNotActive)
+	expectStock(t, details(t, ctx, repo, "PROMO_HOLD"), 1, 0, 2)
+
+	// Expired holds go back to stock, whether confirmed late or swept.
+	late, err := repo.CreateReservation(ctx, "PROMO_HOLD", "user_3", 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	swept, err := repo.CreateReservation(ctx, "PROMO_HOLD", "user_4", 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	_, err = repo.ConfirmReservation(ctx, late.ID)
+	expectError(t, "confirm after ttl", err, coupon.ErrReservationExpired)
+	expired, err := repo.ExpireReservations(ctx, 100)
+	if err != nil || expired != 1 {
+		t.Errorf("Expected the sweep to expire 1 reservation, got %d, %v", expired, err)
+	}
+	if got, err := repo.GetReservation(ctx, swept.ID); err != nil || got.Status != coupon.ReservationStatusExpired {
+		t.Errorf("Expected the swept reservation to be expired, got %+v, %v", got, err)
+	}
+	expectStock(t, details(t, ctx, repo, "PROMO_HOLD"), 1, 0, 2)
+
+	// Releasing the claim a reservation was confirmed into leaves the
+	// reservation confirmed, without a claim.
+	if err := repo.ReleaseClaim(ctx, "PROMO_HOLD", "user_1"); err != nil {
+		t.Fatalf("Failed to release claim: %v", err)
+	}
+	if got, err := repo.GetReservation(ctx, confirmed.ID); err != nil || got.Status != coupon.ReservationStatusConfirmed || got.ClaimID != nil {
+		t.Errorf("Expected the reservation to lose its released claim, got %+v, %v", got, err)
+	}
+}
+
+func testWaitlist(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_QUEUE", Amount: 1})
+
+	_, err := repo.JoinWaitlist(ctx, "PROMO_QUEUE", "user_2")
+	expectError(t, "join while in stock", err, coupon.ErrCouponInStock)
+
+	if err := claim(ctx, repo, "PROMO_QUEUE", "user_1"); err != nil {
+		t.Fatalf("Failed to claim: %v", err)
+	}
+	_, err = repo.JoinWaitlist(ctx, "PROMO_QUEUE", "user_1")
+	expectError(t, "join at the claim limit", err, coupon.ErrClaimLimitReached)
+
+	for i, user := range []string{"user_2", "user_3", "user_4"} {
+		entry, err := repo.JoinWaitlist(ctx, "PROMO_QUEUE", user)
+		if err != nil || entry.Position != i+1 || entry.Status != coupon.WaitlistStatusWaiting {
+			t.Fatalf("Expected %s at position %d, got %+v, %v", user, i+1, entry, err)
+		}
+	}
+	_, err = repo.JoinWaitlist(ctx, "PROMO_QUEUE", "user_2")
+	expectError(t, "join twice", err, coupon.ErrAlreadyOnWaitlist)
+
+	if err := repo.LeaveWaitlist(ctx, "PROMO_QUEUE", "user_2"); err != nil {
+		t.Fatalf("Failed to leave waitlist: %v", err)
+	}
+	expectError(t, "leave twice", repo.LeaveWaitlist(ctx, "PROMO_QUEUE", "user_2"), coupon.ErrWaitlistEntryNotFound)
+	if entry, err := repo.GetWaitlistEntry(ctx, "PROMO_QUEUE", "user_3"); err != nil || entry.Position != 1 {
+		t.Errorf("Expected user_3 to move up to position 1, got %+v, %v", entry, err)
+	}
+
+	// A released unit goes to the head of the queue, not to a new claimant.
+	if err := repo.ReleaseClaim(ctx, "PROMO_QUEUE", "user_1"); err != nil {
+		t.Fatalf("Failed to release claim: %v", err)
+	}
+	entry, err := repo.GetWaitlistEntry(ctx, "PROMO_QUEUE", "user_3")
+	if err != nil || entry.Status != coupon.WaitlistStatusPromoted || entry.ClaimID == nil || entry.Position != 0 {
+		t.Errorf("Expected user_3 to be promoted to a claim, got %+v, %v", entry, err)
+	}
+	expectError(t, "claim after promotion", claim(ctx, repo, "PROMO_QUEUE", "user_5"), coupon.ErrCouponOutOfStock)
+
+	// Raising the amount promotes the rest of the queue.
+	two := 2
+	version := details(t, ctx, repo, "PROMO_QUEUE").Version
+	if err := repo.UpdateCoupon(ctx, "PROMO_QUEUE", version, coupon.UpdateCouponRequest{Amount: &two}); err != nil {
+		t.Fatalf("Failed to update coupon: %v", err)
+	}
+	if entry, err := repo.GetWaitlistEntry(ctx, "PROMO_QUEUE", "user_4"); err != nil || entry.Status != coupon.WaitlistStatusPromoted {
+		t.Errorf("Expected user_4 to be promoted, got %+v, %v", entry, err)
+	}
+	if entry, err := repo.GetWaitlistEntry(ctx, "PROMO_QUEUE", "user_2"); err != nil || entry.Status != coupon.WaitlistStatusLeft {
+		t.Errorf("Expected user_2 to have left, got %+v, %v", entry, err)
+	}
+	d := details(t, ctx, repo, "PROMO_QUEUE")
+	expectStock(t, d, 2, 0, 0)
+	if len(d.ClaimedBy) != 2 || d.ClaimedBy[0] != "user_3" || d.ClaimedBy[1] != "user_4" {
+		t.Errorf("Expected the promoted users to hold the claims, got %v", d.ClaimedBy)
+	}
+
+	if err := repo.ReleaseClaim(ctx, "PROMO_QUEUE", "user_4"); err != nil {
+		t.Fatalf("Failed to release claim: %v", err)
+	}
+	if entry, err := repo.GetWaitlistEntry(ctx, "PROMO_QUEUE", "user_4"); err != nil || entry.Status != coupon.WaitlistStatusPromoted || entry.ClaimID != nil {
+		t.Errorf("Expected the promoted entry to lose its released claim, got %+v, %v", entry, err)
+	}
+}
+
+func testListCoupons(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	past := time.Now().Add(-time.Hour)
+	longAgo := past.Add(-time.Hour)
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "LIST_ACTIVE_1", Amount: 2})
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "LIST_ACTIVE_2", Amount: 2})
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "LIST_SOLD_OUT", Amount: 1})
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "LIST_EXPIRED", Amount: 1, StartsAt: &longAgo, EndsAt: &past})
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "OTHER", Amount: 1})
+	if err := claim(ctx, repo, "LIST_SOLD_OUT", "user_1"); err != nil {
+		t.Fatalf("Failed to claim: %v", err)
+	}
+
+	names := func(filter coupon.CouponFilter) []string {
+		t.Helper()
+		coupons, err := repo.ListCoupons(ctx, filter)
+		if err != nil {
+			t.Fatalf("Failed to list coupons with %+v: %v", filter, err)
+		}
+		var names []string
+		for _, c := range coupons {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+	equal := func(what string, got []string, want ...string) {
+		t.Helper()
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: expected %v, got %v", what, want, got)
+		}
+	}
+
+	// Newest first, and each page starts after the last coupon of the previous.
+	page, err := repo.ListCoupons(ctx, coupon.CouponFilter{NamePrefix: "LIST_", Limit: 2})
+	if err != nil || len(page) != 2 || page[0].Name != "LIST_EXPIRED" || page[1].Name != "LIST_SOLD_OUT" {
+		t.Fatalf("Expected the two newest coupons on the first page, got %+v, %v", page, err)
+	}
+	last := page[1]
+	equal("second page", names(coupon.CouponFilter{
+		NamePrefix: "LIST_",
+		After:      &coupon.CouponCursor{CreatedAt: last.CreatedAt, Name: last.Name},
+		Limit:      10,
+	}), "LIST_ACTIVE_2", "LIST_ACTIVE_1")
+
+	equal("active", names(coupon.CouponFilter{NamePrefix: "LIST_", Status: coupon.CouponStatusActive, Limit: 10}), "LIST_ACTIVE_2", "LIST_ACTIVE_1")
+	equal("sold out", names(coupon.CouponFilter{NamePrefix: "LIST_", Status: coupon.CouponStatusSoldOut, Limit: 10}), "LIST_SOLD_OUT")
+	equal("expired", names(coupon.CouponFilter{Status: coupon.CouponStatusExpired, Limit: 10}), "LIST_EXPIRED")
+
+	created := details(t, ctx, repo, "LIST_SOLD_OUT").CreatedAt
+	equal("created window", names(coupon.CouponFilter{NamePrefix: "LIST_", CreatedAfter: &created, CreatedBefore: &page[0].CreatedAt, Limit: 10}), "LIST_SOLD_OUT")
+
+	_, err = repo.ListCoupons(ctx, coupon.CouponFilter{Status: "bogus", Limit: 10})
+	expectError(t, "unknown status", err, coupon.ErrInvalidStatusFilter)
+}
+
+func testListClaims(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	for _, name := range []string{"B_COUPON", "A_COUPON", "C_COUPON"} {
+		insertCoupon(t, ctx, repo, coupon.Coupons{Name: name, Amount: 5})
+		if err := claim(ctx, repo, name, "user_1"); err != nil {
+			t.Fatalf("Failed to claim %s: %v", name, err)
+		}
+		if err := claim(ctx, repo, name, "user_2"); err != nil {
+			t.Fatalf("Failed to claim %s: %v", name, err)
+		}
+	}
+	if _, err := repo.RedeemClaim(ctx, coupon.RedeemCouponRequest{UserId: "user_1", CouponName: "A_COUPON", OrderRef: "order_1"}); err != nil {
+		t.Fatalf("Failed to redeem: %v", err)
+	}
+
+	claims, err := repo.ListCouponClaims(ctx, "A_COUPON", 0, 1)
+	if err != nil || len(claims) != 1 || claims[0].UserID != "user_1" {
+		t.Fatalf("Expected the first claim on A_COUPON, got %+v, %v", claims, err)
+	}
+	rest, err := repo.ListCouponClaims(ctx, "A_COUPON", claims[0].ID, 10)
+	if err != nil || len(rest) != 1 || rest[0].UserID != "user_2" {
+		t.Errorf("Expected the claims after the first, got %+v, %v", rest, err)
+	}
+
+	coupons := func(filter coupon.UserClaimFilter) []string {
+		t.Helper()
+		claims, err := repo.ListUserClaims(ctx, filter)
+		if err != nil {
+			t.Fatalf("Failed to list user claims with %+v: %v", filter, err)
+		}
+		var names []string
+		for _, uc := range claims {
+			if uc.Claim.UserID != filter.UserID || uc.Coupon.Name != uc.Claim.CouponName {
+				t.Errorf("Expected claims of %s joined with their coupon, got %+v", filter.UserID, uc)
+			}
+			names = append(names, uc.Claim.CouponName)
+		}
+		return names
+	}
+
+	byName := coupon.UserClaimFilter{UserID: "user_1", Sort: coupon.ClaimSortCouponName, Limit: 2}
+	if got := coupons(byName); fmt.Sprint(got) != "[A_COUPON B_COUPON]" {
+		t.Errorf("Expected the first page by coupon name, got %v", got)
+	}
+	byName.After = &coupon.ClaimCursor{Sort: coupon.ClaimSortCouponName, Value: "B_COUPON", ID: 0}
+	if got := coupons(byName); fmt.Sprint(got) != "[B_COUPON C_COUPON]" {
+		t.Errorf("Expected the page after (B_COUPON, 0), got %v", got)
+	}
+
+	newest := coupons(coupon.UserClaimFilter{UserID: "user_1", Sort: coupon.ClaimSortClaimedAt, Desc: true, Limit: 10})
+	if fmt.Sprint(newest) != "[C_COUPON A_COUPON B_COUPON]" {
+		t.Errorf("Expected newest claims first, got %v", newest)
+	}
+	redeemed := coupons(coupon.UserClaimFilter{UserID: "user_1", Status: coupon.ClaimStatusRedeemed, Sort: coupon.ClaimSortUpdatedAt, Limit: 10})
+	if fmt.Sprint(redeemed) != "[A_COUPON]" {
+		t.Errorf("Expected only the redeemed claim, got %v", redeemed)
+	}
+
+	_, err = repo.ListUserClaims(ctx, coupon.UserClaimFilter{UserID: "user_1", Sort: "bogus", Limit: 10})
+	expectError(t, "unknown sort", err, coupon.ErrInvalidSort)
+}
+
+func testIdempotency(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	const scope = "claim"
+	const principal = "user:user_1"
+
+	begin := func(ctx context.Context, key string, hash string, ttl time.Duration, lease time.Duration) (*coupon.IdempotencyRecord, string, error) {
+		return repo.BeginIdempotentRequest(ctx, scope, principal, key, hash, ttl, lease)
+	}
+	response := coupon.IdempotencyRecord{
+		RequestHash: "hash_1",
+		StatusCode:  201,
+		ContentType: "application/json",
+		Body:        []byte(`{"ok":true}`),
+	}
+
+	record, token, err := begin(ctx, "key_1", "hash_1", time.Hour, time.Minute)
+	if err != nil || record != nil || token == "" {
+		t.Fatalf("Expected a new key to be claimed with a lease token, got %+v, %q, %v", record, token, err)
+	}
+	_, _, err = begin(ctx, "key_1", "hash_1", time.Hour, time.Minute)
+	expectError(t, "key in progress", err, coupon.ErrIdempotencyKeyInProgress)
+
+	// Each tenant and each principal has its own keys.
+	other := coupon.WithTenant(ctx, "brand_b")
+	if record, _, err := begin(other, "key_1", "hash_2", time.Hour, time.Minute); err != nil || record != nil {
+		t.Errorf("Expected another tenant to claim the same key, got %+v, %v", record, err)
+	}
+	if record, _, err := repo.BeginIdempotentRequest(ctx, scope, "user:user_2", "key_1", "hash_2", time.Hour, time.Minute); err != nil || record != nil {
+		t.Errorf("Expected another user to claim the same key, got %+v, %v", record, err)
+	}
+
+	if err := repo.CompleteIdempotentRequest(ctx, scope, principal, "key_1", token, response); err != nil {
+		t.Fatalf("Failed to complete request: %v", err)
+	}
+	record, _, err = begin(ctx, "key_1", "hash_1", time.Hour, time.Minute)
+	if err != nil || record == nil || record.StatusCode != 201 || record.ContentType != "application/json" || string(record.Body) != `{"ok":true}` {
+		t.Errorf("Expected the recorded response to replay, got %+v, %v", record, err)
+	}
+	_, _, err = begin(ctx, "key_1", "hash_other", time.Hour, time.Minute)
+	expectError(t, "key reused", err, coupon.ErrIdempotencyKeyReused)
+
+	// An abandoned key can be claimed again; a completed one cannot be abandoned.
+	_, token2, err := begin(ctx, "key_2", "hash_1", time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to claim key: %v", err)
+	}
+	if err := repo.AbandonIdempotentRequest(ctx, scope, principal, "key_2", token2); err != nil {
+		t.Fatalf("Failed to abandon key: %v", err)
+	}
+	if record, _, err := begin(ctx, "key_2", "hash_2", time.Hour, time.Minute); err != nil || record != nil {
+		t.Errorf("Expected an abandoned key to be claimable, got %+v, %v", record, err)
+	}
+	if err := repo.AbandonIdempotentRequest(ctx, scope, principal, "key_1", token); err != nil {
+		t.Fatalf("Failed to abandon key: %v", err)
+	}
+	if record, _, err := begin(ctx, "key_1", "hash_1", time.Hour, time.Minute); err != nil || record == nil {
+		t.Errorf("Expected a completed key to survive abandon, got %+v, %v", record, err)
+	}
+
+	// A key left unfinished past its lease, as by a crash, can be taken over
+	// once; the new holder gets a fresh lease and token.
+	_, stale, err := begin(ctx, "key_5", "hash_1", time.Hour, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to claim key: %v", err)
+	}
+	_, _, err = begin(ctx, "key_5", "hash_1", time.Hour, time.Minute)
+	expectError(t, "key in progress within its lease", err, coupon.ErrIdempotencyKeyInProgress)
+	time.Sleep(150 * time.Millisecond)
+	_, _, err = begin(ctx, "key_5", "hash_other", time.Hour, time.Minute)
+	expectError(t, "stale key reused", err, coupon.ErrIdempotencyKeyReused)
+	record, current, err := begin(ctx, "key_5", "hash_1", time.Hour, time.Minute)
+	if err != nil || record != nil || current == "" || current == stale {
+		t.Errorf("Expected a key past its lease to be taken over with a new token, got %+v, %q, %v", record, current, err)
+	}
+	_, _, err = begin(ctx, "key_5", "hash_1", time.Hour, time.Minute)
+	expectError(t, "taken over key in progress", err, coupon.ErrIdempotencyKeyInProgress)
+
+	// The request that lost the key can neither record its response nor free
+	// the key; only the new holder can.
+	if err := repo.CompleteIdempotentRequest(ctx, scope, principal, "key_5", stale, response); err != nil {
+		t.Fatalf("Failed to complete request: %v", err)
+	}
+	if err := repo.AbandonIdempotentRequest(ctx, scope, principal, "key_5", stale); err != nil {
+		t.Fatalf("Failed to abandon key: %v", err)
+	}
+	_, _, err = begin(ctx, "key_5", "hash_1", time.Hour, time.Minute)
+	expectError(t, "key after a stale completion", err, coupon.ErrIdempotencyKeyInProgress)
+	if err := repo.CompleteIdempotentRequest(ctx, scope, principal, "key_5", current, response); err != nil {
+		t.Fatalf("Failed to complete request: %v", err)
+	}
+	if record, _, err := begin(ctx, "key_5", "hash_1", time.Hour, time.Minute); err != nil || record == nil || record.StatusCode != 201 {
+		t.Errorf("Expected the new holder's response to replay, got %+v, %v", record, err)
+	}
+
+	// Expired keys are claimed afresh and purged.
+	if _, _, err := begin(ctx, "key_3", "hash_1", 50*time.Millisecond, time.Minute); err != nil {
+		t.Fatalf("Failed to claim key: %v", err)
+	}
+	if _, _, err := begin(ctx, "key_4", "hash_1", 50*time.Millisecond, time.Minute); err != nil {
+		t.Fatalf("Failed to claim key: %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if record, _, err := begin(ctx, "key_3", "hash_2", time.Hour, time.Minute); err != nil || record != nil {
+		t.Errorf("Expected an expired key to be claimed afresh, got %+v, %v", record, err)
+	}
+	purged, err := repo.PurgeExpiredIdempotencyKeys(ctx)
+	if err != nil || purged != 1 {
+		t.Errorf("Expected 1 expired key to be purged, got %d, %v", purged, err)
+	}
+}
+
+func testOutbox(t *testing.T, repo coupon.Repository) {
+	ctx := context.Background()
+	stockAmount := 2
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_EVENTS", Amount: stockAmount})
+	insertCoupon(t, coupon.WithTenant(ctx, "brand_b"), repo, coupon.Coupons{Name: "PROMO_EVENTS", Amount: 1})
+
+	// Rejected writes record nothing.
+	expectError(t, "duplicate create", repo.InsertCoupon(ctx, coupon.Coupons{Name: "PROMO_EVENTS", Amount: 1, MaxClaimsPerUser: 1}), coupon.ErrCouponAlreadyExists)
+	successes, _ := concurrently(concurrentRequests/5, func(i int) error {
+		return claim(ctx, repo, "PROMO_EVENTS", fmt.Sprintf("user_%d", i%(stockAmount+1)))
+	})
+	if successes != stockAmount {
+		t.Fatalf("Expected %d claims, got %d", stockAmount, successes)
+	}
+
+	events, err := repo.LeasePendingEvents(ctx, 10, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to lease events: %v", err)
+	}
+	var types []coupon.EventType
+	for i, event := range events {
+		types = append(types, event.Type)
+		if event.Attempts != 1 || event.CouponName != "PROMO_EVENTS" || (i > 0 && event.ID <= events[i-1].ID) {
+			t.Errorf("Expected a first attempt at a PROMO_EVENTS event in id order, got %+v", event)
+		}
+	}
+	want := []coupon.EventType{coupon.EventCouponCreated, coupon.EventCouponCreated, coupon.EventCouponClaimed, coupon.EventCouponClaimed, coupon.EventCouponSoldOut}
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Fatalf("Expected events %v, got %v", want, types)
+	}
+	if events[0].TenantID != coupon.DefaultTenant || events[1].TenantID != "brand_b" {
+		t.Errorf("Expected each event to carry its tenant, got %s and %s", events[0].TenantID, events[1].TenantID)
+	}
+
+	var created coupon.CouponCreatedData
+	if err := json.Unmarshal(events[0].Data, &created); err != nil || created.Amount != stockAmount || created.MaxClaimsPerUser != coupon.DefaultMaxClaimsPerUser {
+		t.Errorf("Expected the created event to describe the coupon, got %+v, %v", created, err)
+	}
+	for i, remaining := range []int{1, 0} {
+		var claimed coupon.CouponClaimedData
+		if err := json.Unmarshal(events[2+i].Data, &claimed); err != nil || claimed.RemainingAmount != remaining || claimed.ClaimID == 0 || claimed.UserID == "" {
+			t.Errorf("Expected claimed event %d to leave %d remaining, got %+v, %v", i, remaining, claimed, err)
+		}
+	}
+	var soldOut coupon.CouponSoldOutData
+	if err := json.Unmarshal(events[4].Data, &soldOut); err != nil || soldOut.Amount != stockAmount {
+		t.Errorf("Expected the sold_out event to carry the amount, got %+v, %v", soldOut, err)
+	}
+
+	// Leased events stay hidden until they are marked or the lease ends.
+	if err := repo.MarkEventDelivered(ctx, events[0].ID); err != nil {
+		t.Fatalf("Failed to mark event delivered: %v", err)
+	}
+	for range 2 {
+		if err := repo.MarkEventSinkDelivered(ctx, events[1].ID, "file"); err != nil {
+			t.Fatalf("Failed to record sink delivery: %v", err)
+		}
+	}
+	if err := repo.MarkEventRetry(ctx, events[1].ID, "sink down", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Failed to schedule retry: %v", err)
+	}
+	if err := repo.MarkEventFailed(ctx, events[2].ID, "gave up"); err != nil {
+		t.Fatalf("Failed to mark event failed: %v", err)
+	}
+	expectError(t, "mark a missing event", repo.MarkEventDelivered(ctx, events[4].ID+1000), coupon.ErrEventNotFound)
+	expectError(t, "record a sink of a missing event", repo.MarkEventSinkDelivered(ctx, events[4].ID+1000, "file"), coupon.ErrEventNotFound)
+
+	retried, err := repo.LeasePendingEvents(ctx, 10, time.Minute)
+	if err != nil || len(retried) != 1 || retried[0].ID != events[1].ID || retried[0].Attempts != 2 {
+		t.Errorf("Expected only the retried event, on its second attempt, got %+v, %v", retried, err)
+	} else if fmt.Sprint(retried[0].DeliveredTo) != "[file]" {
+		t.Errorf("Expected the retried event to remember its sink once, got %v", retried[0].DeliveredTo)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	expiredLease, err := repo.LeasePendingEvents(ctx, 10, time.Minute)
+	if err != nil || len(expiredLease) != 2 || expiredLease[0].ID != events[3].ID || expiredLease[1].ID != events[4].ID {
+		t.Errorf("Expected the unmarked events back after their lease, got %+v, %v", expiredLease, err)
+	}
+	if rest, err := repo.LeasePendingEvents(ctx, 10, time.Minute); err != nil || len(rest) != 0 {
+		t.Errorf("Expected nothing left to lease, got %+v, %v", rest, err)
+	}
+
+	// Only delivered events past their retention are purged; failed ones stay.
+	if purged, err := repo.PurgeDeliveredEvents(ctx, time.Hour); err != nil || purged != 0 {
+		t.Errorf("Expected recent deliveries to be kept, got %d purged, %v", purged, err)
+	}
+	if purged, err := repo.PurgeDeliveredEvents(ctx, 0); err != nil || purged != 1 {
+		t.Errorf("Expected the delivered event to be purged, got %d purged, %v", purged, err)
+	}
+	expectError(t, "mark a purged event", repo.MarkEventDelivered(ctx, events[0].ID), coupon.ErrEventNotFound)
+	if err := repo.MarkEventFailed(ctx, events[2].ID, "still failed"); err != nil {
+		t.Errorf("Expected the failed event to be kept, got %v", err)
+	}
+
+	// Claims made by confirming a reservation or promoting the waitlist are
+	// recorded too. A held unit is not sold until the hold is confirmed.
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_FLOW", Amount: 2})
+	reservation, err := repo.CreateReservation(ctx, "PROMO_FLOW", "user_held", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	if err := claim(ctx, repo, "PROMO_FLOW", "user_direct"); err != nil {
+		t.Fatalf("Failed to claim: %v", err)
+	}
+	if _, err := repo.JoinWaitlist(ctx, "PROMO_FLOW", "user_waiting"); err != nil {
+		t.Fatalf("Failed to join waitlist: %v", err)
+	}
+	if _, err := repo.ConfirmReservation(ctx, reservation.ID); err != nil {
+		t.Fatalf("Failed to confirm reservation: %v", err)
+	}
+	if err := repo.ReleaseClaim(ctx, "PROMO_FLOW", "user_direct"); err != nil {
+		t.Fatalf("Failed to release claim: %v", err)
+	}
+
+	flow, err := repo.LeasePendingEvents(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to lease events: %v", err)
+	}
+	var got []string
+	for _, event := range flow {
+		entry := string(event.Type)
+		if event.Type == coupon.EventCouponClaimed {
+			var claimed coupon.CouponClaimedData
+			if err := json.Unmarshal(event.Data, &claimed); err != nil {
+				t.Fatalf("Failed to decode %s: %v", event.Type, err)
+			}
+			entry += " " + claimed.UserID
+		}
+		got = append(got, entry)
+	}
+	wantFlow := []string{
+		"coupon.created",
+		"coupon.claimed user_direct",
+		"coupon.claimed user_held",
+		"coupon.sold_out",
+		"coupon.claimed user_waiting",
+		"coupon.sold_out",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(wantFlow) {
+		t.Errorf("Expected events %v, got %v", wantFlow, got)
+	}
+
+	// Lowering the amount to the claimed count sells the coupon out once;
+	// later updates that leave it sold out record nothing more.
+	insertCoupon(t, ctx, repo, coupon.Coupons{Name: "PROMO_SHRINK", Amount: 3})
+	for _, userID := range []string{"user_1", "user_2"} {
+		if err := claim(ctx, repo, "PROMO_SHRINK", userID); err != nil {
+			t.Fatalf("Failed to claim: %v", err)
+		}
+	}
+	amount := 2
+	if err := repo.UpdateCoupon(ctx, "PROMO_SHRINK", 1, coupon.UpdateCouponRequest{Amount: &amount}); err != nil {
+		t.Fatalf("Failed to lower amount: %v", err)
+	}
+	maxClaims := 2
+	if err := repo.UpdateCoupon(ctx, "PROMO_SHRINK", 2, coupon.UpdateCouponRequest{MaxClaimsPerUser: &maxClaims}); err != nil {
+		t.Fatalf("Failed to update coupon: %v", err)
+	}
+	shrink, err := repo.LeasePendingEvents(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to lease events: %v", err)
+	}
+	types = nil
+	for _, event := range shrink {
+		types = append(types, event.Type)
+	}
+	want = []coupon.EventType{coupon.EventCouponCreated, coupon.EventCouponClaimed, coupon.EventCouponClaimed, coupon.EventCouponSoldOut}
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Fatalf("Expected events %v, got %v", want, types)
+	}
+	if err := json.Unmarshal(shrink[3].Data, &soldOut); err != nil || soldOut.Amount != amount {
+		t.Errorf("Expected the sold_out event to carry the new amount, got %+v, %v", soldOut, err)
+	}
+}
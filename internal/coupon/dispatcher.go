@@ -0,0 +1,143 @@
+package coupon
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+const (
+	DefaultDispatchInterval = time.Second
+	dispatchBatchSize       = 100
+	// dispatchLease must cover delivering a whole batch to every sink, or
+	// another dispatcher may deliver the same events concurrently.
+	dispatchLease       = 5 * time.Minute
+	maxDeliveryAttempts = 10
+	maxRetryBackoff     = 10 * time.Minute
+)
+
+// Sink receives outbox events. Deliver returns nil only once the event is
+// durably accepted. Events can arrive more than once, and retries and
+// concurrent writers mean ids are not strictly increasing on arrival, so sinks
+// and their consumers should dedupe on Event.ID rather than track the highest.
+// Name identifies the sink in the outbox's delivery records, so it must be
+// unique among the configured sinks and stable across restarts.
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, event Event) error
+}
+
+// Dispatcher delivers outbox events to every sink, at least once. An event is
+// delivered when all sinks have accepted it; if a sink fails, the event is
+// retried with exponential backoff on the sinks that have not accepted it yet,
+// and marked failed after maxDeliveryAttempts.
+type Dispatcher struct {
+	repo     Repository
+	sinks    []Sink
+	log      *slog.Logger
+	interval time.Duration
+}
+
+func NewDispatcher(repo Repository,
+	sinks []Sink,
+	log *slog.Logger,
+	interval time.Duration,
+) *Dispatcher {
+	if interval <= 0 {
+		interval = DefaultDispatchInterval
+	}
+	return &Dispatcher{
+		repo:     repo,
+		sinks:    sinks,
+		log:      log,
+		interval: interval,
+	}
+}
+
+// Run dispatches every interval until ctx is cancelled. A full batch is
+// followed by the next one straight away.
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.log.Info("dispatcher started", "interval", d.interval, "sinks", len(d.sinks))
+	defer d.log.Info("dispatcher stopped")
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for d.Dispatch(ctx) == dispatchBatchSize {
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Dispatch leases one batch of due events, delivers them and records the
+// outcome of each. It returns the number of events leased. Failures to lease
+// or record are logged; the lease expiring brings the events back.
+func (d *Dispatcher) Dispatch(ctx context.Context) int {
+	events, err := d.repo.LeasePendingEvents(ctx, dispatchBatchSize, dispatchLease)
+	if err != nil {
+		d.log.Error("failed to lease events", "error", err)
+		return 0
+	}
+
+	for _, event := range events {
+		if err := d.deliver(ctx, event); err != nil {
+			d.recordFailure(ctx, event, err)
+			continue
+		}
+		if err := d.repo.MarkEventDelivered(ctx, event.ID); err != nil {
+			d.log.Error("failed to mark event delivered", "event_id", event.ID, "error", err)
+		}
+	}
+	return len(events)
+}
+
+// deliver sends the event to each sink that has not accepted it yet. A sink
+// whose acceptance could not be recorded gets the event again on a retry.
+func (d *Dispatcher) deliver(ctx context.Context, event Event) error {
+	for _, sink := range d.sinks {
+		if slices.Contains(event.DeliveredTo, sink.Name()) {
+			continue
+		}
+		if err := sink.Deliver(ctx, event); err != nil {
+			d.log.Warn("event delivery failed", "event_id", event.ID, "event_type", event.Type, "sink", sink.Name(), "attempt", event.Attempts, "error", err)
+			return err
+		}
+		if err := d.repo.MarkEventSinkDelivered(ctx, event.ID, sink.Name()); err != nil {
+			d.log.Error("failed to record sink delivery", "event_id", event.ID, "sink", sink.Name(), "error", err)
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) recordFailure(ctx context.Context, event Event, deliveryErr error) {
+	if event.Attempts >= maxDeliveryAttempts {
+		d.log.Error("event delivery gave up", "event_id", event.ID, "event_type", event.Type, "attempts", event.Attempts, "error", deliveryErr)
+		if err := d.repo.MarkEventFailed(ctx, event.ID, deliveryErr.Error()); err != nil {
+			d.log.Error("failed to mark event failed", "event_id", event.ID, "error", err)
+		}
+		return
+	}
+
+	retryAt := time.Now().Add(retryBackoff(event.Attempts))
+	if err := d.repo.MarkEventRetry(ctx, event.ID, deliveryErr.Error(), retryAt); err != nil {
+		d.log.Error("failed to schedule event retry", "event_id", event.ID, "error", err)
+	}
+}
+
+// retryBackoff doubles from one second per attempt, up to maxRetryBackoff.
+func retryBackoff(attempts int) time.Duration {
+	backoff := time.Second
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRetryBackoff)
+}
@@ -0,0 +1,140 @@
+package coupon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"scalable-coupon-system/internal/auth"
+	"scalable-coupon-system/pkg/couponapi"
+)
+
+// errorMappings gives every sentinel error its status and code. Errors not
+// listed here are answered with a generic 500 so internal details never leak.
+// Codes are part of the API contract and live in couponapi, which also maps
+// them back to the sentinels for clients.
+var errorMappings = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrCouponAlreadyExists, http.StatusBadRequest, couponapi.CodeCouponAlreadyExists},
+	{ErrCouponNotFound, http.StatusNotFound, couponapi.CodeCouponNotFound},
+	{ErrClaimLimitReached, http.StatusConflict, couponapi.CodeClaimLimitReached},
+	{ErrCouponOutOfStock, http.StatusConflict, couponapi.CodeCouponOutOfStock},
+	{ErrCouponNotYetActive, http.StatusForbidden, couponapi.CodeCouponNotYetActive},
+	{ErrCouponExpired, http.StatusGone, couponapi.CodeCouponExpired},
+	{ErrClaimNotFound, http.StatusNotFound, couponapi.CodeClaimNotFound},
+	{ErrClaimNotRedeemable, http.StatusConflict, couponapi.CodeClaimNotRedeemable},
+	{ErrClaimNotVoidable, http.StatusConflict, couponapi.CodeClaimNotVoidable},
+	{ErrClaimNotReleasable, http.StatusConflict, couponapi.CodeClaimNotReleasable},
+	{ErrVersionMismatch, http.StatusPreconditionFailed, couponapi.CodeVersionMismatch},
+	{ErrAmountBelowClaimed, http.StatusConflict, couponapi.CodeAmountBelowClaimed},
+	{ErrInvalidCursor, http.StatusBadRequest, couponapi.CodeInvalidCursor},
+	{ErrInvalidStatusFilter, http.StatusBadRequest, couponapi.CodeInvalidStatusFilter},
+	{ErrInvalidSort, http.StatusBadRequest, couponapi.CodeInvalidSort},
+	{ErrClaimContention, http.StatusServiceUnavailable, couponapi.CodeClaimContention},
+	{ErrReservationNotFound, http.StatusNotFound, couponapi.CodeReservationNotFound},
+	{ErrReservationNotActive, http.StatusConflict, couponapi.CodeReservationNotActive},
+	{ErrReservationExpired, http.StatusGone, couponapi.CodeReservationExpired},
+	{ErrInvalidReservationTTL, http.StatusBadRequest, couponapi.CodeInvalidReservationTTL},
+	{ErrCouponInStock, http.StatusConflict, couponapi.CodeCouponInStock},
+	{ErrAlreadyOnWaitlist, http.StatusConflict, couponapi.CodeAlreadyOnWaitlist},
+	{ErrWaitlistEntryNotFound, http.StatusNotFound, couponapi.CodeWaitlistEntryNotFound},
+	{ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, couponapi.CodeIdempotencyKeyReused},
+	{ErrIdempotencyKeyInProgress, http.StatusConflict, couponapi.CodeIdempotencyKeyInProgress},
+	{ErrInvalidValidityWindow, http.StatusBadRequest, couponapi.CodeInvalidValidityWindow},
+	{ErrInvalidTenant, http.StatusBadRequest, couponapi.CodeInvalidTenant},
+	{ErrValidationFailed, http.StatusBadRequest, couponapi.CodeValidationFailed},
+	{ErrAdminRequired, http.StatusForbidden, couponapi.CodeAdminRequired},
+	{ErrUserMismatch, http.StatusForbidden, couponapi.CodeUserMismatch},
+	{ErrUserIDRequired, http.StatusBadRequest, couponapi.CodeUserIDRequired},
+	{ErrTenantMismatch, http.StatusForbidden, couponapi.CodeTenantMismatch},
+
+	{auth.ErrMissingCredentials, http.StatusUnauthorized, couponapi.CodeMissingCredentials},
+	{auth.ErrInvalidAPIKey, http.StatusUnauthorized, couponapi.CodeInvalidAPIKey},
+	{auth.ErrTokenExpired, http.StatusUnauthorized, couponapi.CodeTokenExpired},
+	{auth.ErrTokenNotYetValid, http.StatusUnauthorized, couponapi.CodeTokenNotYetValid},
+	{auth.ErrInvalidToken, http.StatusUnauthorized, couponapi.CodeInvalidToken},
+	{auth.ErrMissingSubject, http.StatusUnauthorized, couponapi.CodeInvalidToken},
+	{auth.ErrInvalidRole, http.StatusUnauthorized, couponapi.CodeInvalidToken},
+}
+
+// apiError is a handler-level error that carries its own status and code,
+// for failures such as malformed input that have no sentinel.
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string { return e.message }
+
+func errInvalidRequest(format string, args ...any) error {
+	return &apiError{status: http.StatusBadRequest, code: couponapi.CodeInvalidRequest, message: fmt.Sprintf(format, args...)}
+}
+
+// detailedError attaches structured details to an error without changing
+// which sentinel it matches.
+type detailedError struct {
+	err     error
+	details map[string]any
+}
+
+func (e *detailedError) Error() string { return e.err.Error() }
+func (e *detailedError) Unwrap() error { return e.err }
+
+func withDetails(err error, details map[string]any) error {
+	return &detailedError{err: err, details: details}
+}
+
+// writeError answers with the JSON error body. Sentinel errors report the
+// sentinel's own message rather than err.Error(), which may carry wrapped
+// driver errors.
+func (h *Handler) writeError(
+	w http.ResponseWriter,
+	r *http.Request,
+	err error,
+) {
+	resp := ErrorResponse{
+		Code:      couponapi.CodeInternal,
+		Message:   http.StatusText(http.StatusInternalServerError),
+		RequestID: RequestIDFromContext(r.Context()),
+	}
+	status := http.StatusInternalServerError
+
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		status, resp.Code, resp.Message = apiErr.status, apiErr.code, apiErr.message
+	} else {
+		for _, m := range errorMappings {
+			if errors.Is(err, m.err) {
+				status, resp.Code, resp.Message = m.status, m.code, m.err.Error()
+				break
+			}
+		}
+	}
+
+	var detailed *detailedError
+	if errors.As(err, &detailed) {
+		resp.Details = detailed.details
+	}
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		resp.Details = map[string]any{"fields": invalid.Fields}
+	}
+
+	if status == http.StatusInternalServerError {
+		resp.Details = nil
+		h.log.Error("internal error", "method", r.Method, "path", r.URL.Path, "request_id", resp.RequestID, "error", err)
+	}
+	if errors.Is(err, ErrClaimContention) {
+		w.Header().Set("Retry-After", "1")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
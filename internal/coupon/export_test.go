@@ -0,0 +1,8 @@
+package coupon
+
+// Exported for the conformance tests in package coupon_test, which cannot
+// live in package coupon because coupontest imports it.
+var (
+	SetupTestDB   = setupTestDB
+	CleanupTestDB = cleanupTestDB
+)
@@ -2,27 +2,66 @@ package coupon
 
 import (
 	"encoding/json"
-	"errors"
-	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"scalable-coupon-system/internal/auth"
+	"scalable-coupon-system/pkg/couponapi"
 )
 
 type Handler struct {
-	service *Service
-	log     *slog.Logger
+	service          Service
+	log              *slog.Logger
+	idempotencyTTL   time.Duration
+	idempotencyLease time.Duration
+	reservationTTL   time.Duration
+	authenticator    *auth.Authenticator
+	metrics          *Metrics
 }
 
-func NewHandler(db *pgxpool.Pool,
+// Options are the per-deployment settings of the coupon API. Zero values
+// select the defaults, except Authenticator: without one every protected
+// route answers 401.
+type Options struct {
+	IdempotencyTTL time.Duration
+	// IdempotencyLease is how long a request holds its Idempotency-Key before
+	// a retry may take the key over. It should outlast any request.
+	IdempotencyLease time.Duration
+	ReservationTTL   time.Duration
+	Authenticator    *auth.Authenticator
+	Metrics          *Metrics
+}
+
+// NewHandler serves the coupon API from svc. The storage behind it, and its
+// claim strategy, are chosen when the service's repository is built.
+func NewHandler(svc Service,
 	log *slog.Logger,
+	opts Options,
 ) *Handler {
-	repo := NewRepository(db, log)
-	svc := NewService(repo, log)
+	if opts.IdempotencyTTL <= 0 {
+		opts.IdempotencyTTL = DefaultIdempotencyTTL
+	}
+	if opts.IdempotencyLease <= 0 {
+		opts.IdempotencyLease = DefaultIdempotencyLease
+	}
+	if opts.ReservationTTL <= 0 {
+		opts.ReservationTTL = DefaultReservationTTL
+	}
+	if opts.Authenticator == nil {
+		opts.Authenticator = auth.NewAuthenticator(nil, nil)
+	}
+
 	return &Handler{
-		service: svc,
-		log:     log,
+		service:          svc,
+		log:              log,
+		idempotencyTTL:   opts.IdempotencyTTL,
+		idempotencyLease: opts.IdempotencyLease,
+		reservationTTL:   opts.ReservationTTL,
+		authenticator:    opts.Authenticator,
+		metrics:          opts.Metrics,
 	}
 }
 
@@ -38,25 +77,58 @@ func (h *Handler) CreateCoupon(
 	decoder.DisallowUnknownFields()
 	if err := decoder.Decode(&req); err != nil {
 		h.log.Warn("failed to decode request", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, r, errInvalidRequest("invalid request body: %s", err))
 		return
 	}
 
 	err := h.service.CreateCoupon(r.Context(), req)
 	if err != nil {
-		if errors.Is(err, ErrCouponAlreadyExists) {
-			http.Error(w,
-				fmt.Sprintf("%s: %s", err.Error(), req.Name),
-				http.StatusBadRequest)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, r, withDetails(err, map[string]any{"coupon_name": req.Name}))
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
+func (h *Handler) ListCoupons(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("list coupons request received")
+	defer h.log.Info("list coupons request completed")
+
+	query := r.URL.Query()
+	req := ListCouponsRequest{
+		NamePrefix: query.Get("name_prefix"),
+		Status:     query.Get("status"),
+		Cursor:     query.Get("cursor"),
+	}
+
+	var err error
+	if req.Limit, err = parseLimit(query.Get("limit")); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if req.CreatedAfter, err = parseTimeParam(query.Get("created_after")); err != nil {
+		h.writeError(w, r, errInvalidRequest("invalid created_after: %s", err))
+		return
+	}
+	if req.CreatedBefore, err = parseTimeParam(query.Get("created_before")); err != nil {
+		h.writeError(w, r, errInvalidRequest("invalid created_before: %s", err))
+		return
+	}
+
+	resp, err := h.service.ListCoupons(r.Context(), req)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
 func (h *Handler) ClaimCoupon(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -69,26 +141,31 @@ func (h *Handler) ClaimCoupon(
 	decoder.DisallowUnknownFields()
 	if err := decoder.Decode(&req); err != nil {
 		h.log.Warn("failed to decode request", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, r, errInvalidRequest("invalid request body: %s", err))
+		return
+	}
+
+	userID, ok := h.resolveUserID(w, r, req.UserId)
+	if !ok {
 		return
 	}
+	req.UserId = userID
 
+	h.withIdempotency(w, r, idempotencyScopeClaim, req, func(w http.ResponseWriter) {
+		h.claimCoupon(w, r, req)
+	})
+}
+
+func (h *Handler) claimCoupon(
+	w http.ResponseWriter,
+	r *http.Request,
+	req ClaimCouponRequest,
+) {
 	err := h.service.ClaimCoupon(r.Context(), req)
+	h.metrics.observeClaim(err)
 	if err != nil {
-		switch {
-		case errors.Is(err, ErrCouponAlreadyClaimed):
-			http.Error(w, err.Error(), http.StatusConflict)
-			return
-		case errors.Is(err, ErrCouponOutOfStock):
-			http.Error(w, err.Error(), http.StatusConflict)
-			return
-		case errors.Is(err, ErrCouponNotFound):
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		h.writeError(w, r, err)
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
@@ -104,23 +181,518 @@ func (h *Handler) GetCouponDetails(
 	name := r.PathValue("name")
 	if name == "" {
 		h.log.Warn("coupon name missing in request")
-		http.Error(w, "coupon name required", http.StatusBadRequest)
+		h.writeError(w, r, errInvalidRequest("coupon name required"))
 		return
 	}
 
-	resp, err := h.service.GetCouponDetails(r.Context(), name)
-	if err != nil {
-		if errors.Is(err, ErrCouponNotFound) {
-			http.Error(w,
-				fmt.Sprintf("%s: %s", err.Error(), name),
-				http.StatusBadRequest)
+	includeClaimedBy := false
+	if value := r.URL.Query().Get("include_claimed_by"); value != "" {
+		var err error
+		if includeClaimedBy, err = strconv.ParseBool(value); err != nil {
+			h.writeError(w, r, errInvalidRequest("invalid include_claimed_by: %q", value))
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	if includeClaimedBy && !isAdmin(r) {
+		h.log.Warn("include_claimed_by requires admin", "coupon_name", name)
+		h.writeError(w, r, ErrAdminRequired)
+		return
+	}
+
+	resp, err := h.service.GetCouponDetails(r.Context(), name, includeClaimedBy)
+	if err != nil {
+		h.writeError(w, r, withDetails(err, map[string]any{"coupon_name": name}))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(resp.Version))
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) UpdateCoupon(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("update coupon request received")
+	defer h.log.Info("update coupon request completed")
+
+	name := r.PathValue("name")
+	if name == "" {
+		h.log.Warn("coupon name missing in request")
+		h.writeError(w, r, errInvalidRequest("coupon name required"))
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		h.log.Warn("if-match header missing in request", "coupon_name", name)
+		h.writeError(w, r, &apiError{
+			status:  http.StatusPreconditionRequired,
+			code:    couponapi.CodePreconditionRequired,
+			message: "If-Match header required",
+		})
+		return
+	}
+	version, err := parseETag(ifMatch)
+	if err != nil {
+		h.log.Warn("invalid if-match header", "coupon_name", name, "error", err)
+		h.writeError(w, r, err)
+		return
+	}
+
+	var req UpdateCouponRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		h.log.Warn("failed to decode request", "error", err)
+		h.writeError(w, r, errInvalidRequest("invalid request body: %s", err))
+		return
+	}
+
+	resp, err := h.service.UpdateCoupon(r.Context(), name, version, req)
+	if err != nil {
+		h.writeError(w, r, withDetails(err, map[string]any{"coupon_name": name}))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(resp.Version))
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(resp)
 }
+
+func (h *Handler) RedeemCoupon(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("redeem coupon request received")
+	defer h.log.Info("redeem coupon request completed")
+
+	var req RedeemCouponRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		h.log.Warn("failed to decode request", "error", err)
+		h.writeError(w, r, errInvalidRequest("invalid request body: %s", err))
+		return
+	}
+
+	userID, ok := h.resolveUserID(w, r, req.UserId)
+	if !ok {
+		return
+	}
+	req.UserId = userID
+
+	resp, err := h.service.RedeemCoupon(r.Context(), req)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) VoidCoupon(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("void coupon request received")
+	defer h.log.Info("void coupon request completed")
+
+	var req VoidCouponRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		h.log.Warn("failed to decode request", "error", err)
+		h.writeError(w, r, errInvalidRequest("invalid request body: %s", err))
+		return
+	}
+
+	userID, ok := h.resolveUserID(w, r, req.UserId)
+	if !ok {
+		return
+	}
+	req.UserId = userID
+
+	resp, err := h.service.VoidCoupon(r.Context(), req)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// ReleaseClaim is a support tool: each call releases one more of the user's
+// claims, so repeating it is not a no-op.
+func (h *Handler) ReleaseClaim(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("release claim request received")
+	defer h.log.Info("release claim request completed")
+
+	name := r.PathValue("name")
+	userID := r.PathValue("user_id")
+	if name == "" || userID == "" {
+		h.log.Warn("coupon name or user id missing in request")
+		h.writeError(w, r, errInvalidRequest("coupon name and user id required"))
+		return
+	}
+
+	err := h.service.ReleaseClaim(r.Context(), name, userID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListCouponClaims(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("list coupon claims request received")
+	defer h.log.Info("list coupon claims request completed")
+
+	name := r.PathValue("name")
+	if name == "" {
+		h.log.Warn("coupon name missing in request")
+		h.writeError(w, r, errInvalidRequest("coupon name required"))
+		return
+	}
+
+	query := r.URL.Query()
+	req := ListCouponClaimsRequest{
+		CouponName: name,
+		Cursor:     query.Get("cursor"),
+	}
+
+	var err error
+	if req.Limit, err = parseLimit(query.Get("limit")); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	resp, err := h.service.ListCouponClaims(r.Context(), req)
+	if err != nil {
+		h.writeError(w, r, withDetails(err, map[string]any{"coupon_name": name}))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) ListUserClaims(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("list user claims request received")
+	defer h.log.Info("list user claims request completed")
+
+	userID := r.PathValue("user_id")
+	if userID == "" {
+		h.log.Warn("user id missing in request")
+		h.writeError(w, r, errInvalidRequest("user id required"))
+		return
+	}
+	if !h.authorizeUser(w, r, userID) {
+		return
+	}
+
+	query := r.URL.Query()
+	req := ListUserClaimsRequest{
+		UserID: userID,
+		Status: query.Get("status"),
+		Sort:   query.Get("sort"),
+		Order:  query.Get("order"),
+		Cursor: query.Get("cursor"),
+	}
+
+	var err error
+	if req.Limit, err = parseLimit(query.Get("limit")); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	resp, err := h.service.ListUserClaims(r.Context(), req)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) CreateReservation(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("create reservation request received")
+	defer h.log.Info("create reservation request completed")
+
+	name := r.PathValue("name")
+	if name == "" {
+		h.log.Warn("coupon name missing in request")
+		h.writeError(w, r, errInvalidRequest("coupon name required"))
+		return
+	}
+
+	var req CreateReservationRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		h.log.Warn("failed to decode request", "error", err)
+		h.writeError(w, r, errInvalidRequest("invalid request body: %s", err))
+		return
+	}
+
+	userID, ok := h.resolveUserID(w, r, req.UserId)
+	if !ok {
+		return
+	}
+	req.UserId = userID
+
+	ttl := h.reservationTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+
+	resp, err := h.service.CreateReservation(r.Context(), name, req, ttl)
+	if err != nil {
+		h.writeError(w, r, withDetails(err, map[string]any{
+			"coupon_name":     name,
+			"max_ttl_seconds": int(MaxReservationTTL.Seconds()),
+		}))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) GetReservation(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("get reservation request received")
+	defer h.log.Info("get reservation request completed")
+
+	resp, err := h.service.GetReservation(r.Context(), r.PathValue("id"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if !h.authorizeUser(w, r, resp.UserID) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) ConfirmReservation(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("confirm reservation request received")
+	defer h.log.Info("confirm reservation request completed")
+
+	if !h.authorizeReservation(w, r, r.PathValue("id")) {
+		return
+	}
+
+	resp, err := h.service.ConfirmReservation(r.Context(), r.PathValue("id"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) CancelReservation(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("cancel reservation request received")
+	defer h.log.Info("cancel reservation request completed")
+
+	if !h.authorizeReservation(w, r, r.PathValue("id")) {
+		return
+	}
+
+	resp, err := h.service.CancelReservation(r.Context(), r.PathValue("id"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// authorizeReservation checks the caller owns the reservation before it is
+// acted on. Ownership never changes, so the check need not share the action's
+// transaction.
+func (h *Handler) authorizeReservation(
+	w http.ResponseWriter,
+	r *http.Request,
+	id string,
+) bool {
+	reservation, err := h.service.GetReservation(r.Context(), id)
+	if err != nil {
+		h.writeError(w, r, err)
+		return false
+	}
+	return h.authorizeUser(w, r, reservation.UserID)
+}
+
+func (h *Handler) JoinWaitlist(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("join waitlist request received")
+	defer h.log.Info("join waitlist request completed")
+
+	name := r.PathValue("name")
+	if name == "" {
+		h.log.Warn("coupon name missing in request")
+		h.writeError(w, r, errInvalidRequest("coupon name required"))
+		return
+	}
+
+	var req JoinWaitlistRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		h.log.Warn("failed to decode request", "error", err)
+		h.writeError(w, r, errInvalidRequest("invalid request body: %s", err))
+		return
+	}
+
+	userID, ok := h.resolveUserID(w, r, req.UserId)
+	if !ok {
+		return
+	}
+	req.UserId = userID
+
+	resp, err := h.service.JoinWaitlist(r.Context(), name, req)
+	if err != nil {
+		h.writeError(w, r, withDetails(err, map[string]any{"coupon_name": name}))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) GetWaitlistEntry(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("get waitlist entry request received")
+	defer h.log.Info("get waitlist entry request completed")
+
+	name := r.PathValue("name")
+	userID := r.PathValue("user_id")
+	if name == "" || userID == "" {
+		h.log.Warn("coupon name or user id missing in request")
+		h.writeError(w, r, errInvalidRequest("coupon name and user id required"))
+		return
+	}
+	if !h.authorizeUser(w, r, userID) {
+		return
+	}
+
+	resp, err := h.service.GetWaitlistEntry(r.Context(), name, userID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) LeaveWaitlist(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	h.log.Info("leave waitlist request received")
+	defer h.log.Info("leave waitlist request completed")
+
+	name := r.PathValue("name")
+	userID := r.PathValue("user_id")
+	if name == "" || userID == "" {
+		h.log.Warn("coupon name or user id missing in request")
+		h.writeError(w, r, errInvalidRequest("coupon name and user id required"))
+		return
+	}
+	if !h.authorizeUser(w, r, userID) {
+		return
+	}
+
+	err := h.service.LeaveWaitlist(r.Context(), name, userID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func formatETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseETag accepts the version either as a quoted ETag ("3", W/"3") or bare.
+func parseETag(value string) (int64, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+	value = strings.Trim(value, `"`)
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errInvalidRequest("invalid If-Match header: %q", value)
+	}
+	return version, nil
+}
+
+func parseLimit(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, errInvalidRequest("invalid limit: %q", value)
+	}
+	return limit, nil
+}
+
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,334 @@
+package coupon
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"scalable-coupon-system/internal/auth"
+	"scalable-coupon-system/pkg/couponapi"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	IdempotencyKeyHeader      = couponapi.IdempotencyKeyHeader
+	IdempotentReplayedHeader  = couponapi.IdempotentReplayedHeader
+	DefaultIdempotencyTTL     = 24 * time.Hour
+	DefaultIdempotencyLease   = time.Minute
+	maxIdempotencyKeyLength   = 255
+	idempotencyScopeClaim     = "claim"
+	idempotencyMaxInsertTries = 2
+)
+
+// IdempotencyRecord is the stored outcome of the first request made with a
+// key. StatusCode is zero while that request is still being processed.
+type IdempotencyRecord struct {
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// BeginIdempotentRequest claims the key for a new request and holds it for
+// lease. It returns the lease token when the caller should process the
+// request, or the recorded outcome to replay. An expired key is discarded and
+// claimed afresh, and an unfinished key whose lease has passed, because the
+// request holding it crashed, is taken over under a new token. Keys are
+// scoped to the tenant and the principal that sent them.
+func (r *PostgresRepository) BeginIdempotentRequest(
+	ctx context.Context,
+	scope string,
+	principal string,
+	key string,
+	requestHash string,
+	ttl time.Duration,
+	lease time.Duration,
+) (*IdempotencyRecord, string, error) {
+	r.log.Info("checking idempotency key", "scope", scope, "principal", principal, "key", key)
+	defer r.log.Info("finished checking idempotency key", "scope", scope, "principal", principal, "key", key)
+
+	for attempt := 0; attempt < idempotencyMaxInsertTries; attempt++ {
+		var leaseToken string
+		err := r.db.QueryRow(ctx, `
+			INSERT INTO idempotency_keys (tenant_id, scope, principal, key, request_hash, expires_at, locked_until)
+			VALUES ($1, $2, $3, $4, $5, now() + $6::interval, now() + $7::interval)
+			ON CONFLICT (tenant_id, scope, principal, key) DO NOTHING
+			RETURNING lease_token::text
+		`, TenantFromContext(ctx), scope, principal, key, requestHash, ttl, lease).Scan(&leaseToken)
+		if err == nil {
+			return nil, leaseToken, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.log.Error("failed to insert idempotency key", "scope", scope, "key", key, "error", err)
+			return nil, "", err
+		}
+
+		var record IdempotencyRecord
+		var statusCode *int
+		var contentType *string
+		var expired, unlocked bool
+		err = r.db.QueryRow(ctx, `
+			SELECT request_hash, status_code, content_type, response_body, expires_at <= now(), locked_until <= now()
+			FROM idempotency_keys
+			WHERE tenant_id = $1 AND scope = $2 AND principal = $3 AND key = $4
+		`, TenantFromContext(ctx), scope, principal, key).Scan(&record.RequestHash, &statusCode, &contentType, &record.Body, &expired, &unlocked)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				// Removed between the insert and this read; try to claim it again.
+				continue
+			}
+			r.log.Error("failed to read idempotency key", "scope", scope, "key", key, "error", err)
+			return nil, "", err
+		}
+
+		if expired {
+			_, err = r.db.Exec(ctx, `
+				DELETE FROM idempotency_keys
+				WHERE tenant_id = $1 AND scope = $2 AND principal = $3 AND key = $4 AND expires_at <= now()
+			`, TenantFromContext(ctx), scope, principal, key)
+			if err != nil {
+				r.log.Error("failed to delete expired idempotency key", "scope", scope, "key", key, "error", err)
+				return nil, "", err
+			}
+			continue
+		}
+
+		if record.RequestHash != requestHash {
+			r.log.Warn("idempotency key reused with different request", "scope", scope, "key", key)
+			return nil, "", ErrIdempotencyKeyReused
+		}
+		if statusCode == nil && unlocked {
+			err := r.db.QueryRow(ctx, `
+				UPDATE idempotency_keys
+				SET locked_until = now() + $5::interval, lease_token = gen_random_uuid()
+				WHERE tenant_id = $1 AND scope = $2 AND principal = $3 AND key = $4
+					AND status_code IS NULL AND locked_until <= now()
+				RETURNING lease_token::text
+			`, TenantFromContext(ctx), scope, principal, key, lease).Scan(&leaseToken)
+			if err == nil {
+				r.log.Warn("taking over abandoned idempotency key", "scope", scope, "key", key)
+				return nil, leaseToken, nil
+			}
+			if !errors.Is(err, pgx.ErrNoRows) {
+				r.log.Error("failed to take over idempotency key", "scope", scope, "key", key, "error", err)
+				return nil, "", err
+			}
+			// Taken over or completed by someone else; read it again.
+			continue
+		}
+		if statusCode == nil {
+			r.log.Warn("idempotency key still in progress", "scope", scope, "key", key)
+			return nil, "", ErrIdempotencyKeyInProgress
+		}
+
+		record.StatusCode = *statusCode
+		if contentType != nil {
+			record.ContentType = *contentType
+		}
+		r.log.Info("replaying idempotent request", "scope", scope, "key", key, "status_code", record.StatusCode)
+		return &record, "", nil
+	}
+
+	return nil, "", ErrIdempotencyKeyInProgress
+}
+
+// CompleteIdempotentRequest records the response of the request holding
+// leaseToken. A request whose key was taken over after its lease ran out no
+// longer holds it, and its response is dropped rather than overwriting the
+// new holder's.
+func (r *PostgresRepository) CompleteIdempotentRequest(
+	ctx context.Context,
+	scope string,
+	principal string,
+	key string,
+	leaseToken string,
+	record IdempotencyRecord,
+) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE idempotency_keys
+		SET status_code = $6, content_type = $7, response_body = $8
+		WHERE tenant_id = $1 AND scope = $2 AND principal = $3 AND key = $4
+			AND lease_token = $5::uuid AND status_code IS NULL
+	`, TenantFromContext(ctx), scope, principal, key, leaseToken, record.StatusCode, record.ContentType, record.Body)
+	if err != nil {
+		r.log.Error("failed to record idempotent response", "scope", scope, "key", key, "error", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		r.log.Warn("idempotency lease lost, response not recorded", "scope", scope, "key", key)
+	}
+	return nil
+}
+
+// AbandonIdempotentRequest frees a key whose request failed with a server
+// error or panicked, so the client's retry is processed instead of replaying
+// the failure. Only the request holding leaseToken can free it.
+func (r *PostgresRepository) AbandonIdempotentRequest(
+	ctx context.Context,
+	scope string,
+	principal string,
+	key string,
+	leaseToken string,
+) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE tenant_id = $1 AND scope = $2 AND principal = $3 AND key = $4
+			AND lease_token = $5::uuid AND status_code IS NULL
+	`, TenantFromContext(ctx), scope, principal, key, leaseToken)
+	if err != nil {
+		r.log.Error("failed to abandon idempotency key", "scope", scope, "key", key, "error", err)
+		return err
+	}
+	return nil
+}
+
+// PurgeExpiredIdempotencyKeys deletes keys whose TTL has passed and returns
+// how many were removed.
+func (r *PostgresRepository) PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE expires_at <= now()
+	`)
+	if err != nil {
+		r.log.Error("failed to purge expired idempotency keys", "error", err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// withIdempotency runs handle at most once per Idempotency-Key within the
+// key's TTL. Repeats with the same request replay the recorded status and
+// body; repeats with a different request are rejected. Each caller has its
+// own keys, so two users picking the same key do not collide.
+func (h *Handler) withIdempotency(
+	w http.ResponseWriter,
+	r *http.Request,
+	scope string,
+	req any,
+	handle func(w http.ResponseWriter),
+) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		handle(w)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		h.writeError(w, r, errInvalidRequest("Idempotency-Key longer than %d characters", maxIdempotencyKeyLength))
+		return
+	}
+
+	requestHash, err := hashRequest(req)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	principal := idempotencyPrincipal(auth.PrincipalFromContext(ctx))
+	record, leaseToken, err := h.service.BeginIdempotentRequest(ctx, scope, principal, key, requestHash, h.idempotencyTTL, h.idempotencyLease)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if record != nil {
+		if record.ContentType != "" {
+			w.Header().Set("Content-Type", record.ContentType)
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		w.WriteHeader(record.StatusCode)
+		_, _ = w.Write(record.Body)
+		return
+	}
+
+	abandon := func() {
+		if err := h.service.AbandonIdempotentRequest(context.WithoutCancel(ctx), scope, principal, key, leaseToken); err != nil {
+			h.log.Error("failed to abandon idempotency key", "key", key, "error", err)
+		}
+	}
+	// A panic frees the key before it propagates; the lease covers a crash.
+	defer func() {
+		if p := recover(); p != nil {
+			abandon()
+			panic(p)
+		}
+	}()
+
+	capture := &responseCapture{header: http.Header{}, status: http.StatusOK}
+	handle(capture)
+
+	// Server errors are not recorded, so a retry gets a fresh attempt.
+	if capture.status >= http.StatusInternalServerError {
+		abandon()
+	} else {
+		err := h.service.CompleteIdempotentRequest(context.WithoutCancel(ctx), scope, principal, key, leaseToken, IdempotencyRecord{
+			RequestHash: requestHash,
+			StatusCode:  capture.status,
+			ContentType: capture.header.Get("Content-Type"),
+			Body:        capture.body.Bytes(),
+		})
+		if err != nil {
+			h.log.Error("failed to record idempotent response", "key", key, "error", err)
+		}
+	}
+
+	capture.writeTo(w)
+}
+
+// idempotencyPrincipal names the caller that owns a key. API keys carry no
+// user, so every admin API key shares one namespace.
+func idempotencyPrincipal(principal *auth.Principal) string {
+	if principal == nil {
+		return ""
+	}
+	return string(principal.Role) + ":" + principal.UserID
+}
+
+// hashRequest fingerprints the decoded request, so bodies that differ only in
+// formatting or field order are treated as the same request.
+func hashRequest(req any) (string, error) {
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// responseCapture buffers a handler's response so it can be recorded before
+// it is sent.
+type responseCapture struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (c *responseCapture) Header() http.Header { return c.header }
+
+func (c *responseCapture) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.status = status
+	c.wroteHeader = true
+}
+
+func (c *responseCapture) Write(p []byte) (int, error) {
+	c.WriteHeader(http.StatusOK)
+	return c.body.Write(p)
+}
+
+func (c *responseCapture) writeTo(w http.ResponseWriter) {
+	for name, values := range c.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(c.status)
+	_, _ = w.Write(c.body.Bytes())
+}
@@ -0,0 +1,1247 @@
+package coupon
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryRepository is a Repository that keeps everything in process memory.
+// A single mutex serialises every call, which gives the same guarantees as
+// the coupon row lock in Postgres: no overselling and no claims past the
+// per-user limit. Data does not survive a restart, so it is meant for tests
+// and local development.
+type MemoryRepository struct {
+	log *slog.Logger
+
+	mu             sync.Mutex
+	coupons        map[memoryKey]*memoryCoupon
+	reservations   map[string]*memoryReservation
+	idempotency    map[memoryIdempotencyKey]*memoryIdempotencyRecord
+	events         []*memoryEvent
+	nextEventID    int64
+	nextClaimID    int64
+	nextWaitlistID int64
+}
+
+var _ Repository = (*MemoryRepository)(nil)
+
+type memoryKey struct {
+	tenant string
+	name   string
+}
+
+type memoryCoupon struct {
+	tenant    string
+	coupon    Coupons
+	version   int64
+	reserved  int
+	createdAt time.Time
+	// claims are in id order, like claim_history.
+	claims []*ClaimHistory
+	// held counts each user's claims plus active reservations, the total the
+	// per-user limit applies to.
+	held     map[string]int
+	waitlist []*WaitlistEntry
+}
+
+type memoryReservation struct {
+	coupon memoryKey
+	Reservation
+}
+
+type memoryIdempotencyKey struct {
+	tenant    string
+	scope     string
+	principal string
+	key       string
+}
+
+type memoryIdempotencyRecord struct {
+	record      IdempotencyRecord
+	completed   bool
+	expiresAt   time.Time
+	lockedUntil time.Time
+	leaseToken  string
+}
+
+// memoryEvent is an outbox row; events are kept in id order.
+type memoryEvent struct {
+	Event
+	status        EventStatus
+	lastError     string
+	nextAttemptAt time.Time
+	deliveredAt   time.Time
+}
+
+func NewMemoryRepository(log *slog.Logger) *MemoryRepository {
+	return &MemoryRepository{
+		log:          log,
+		coupons:      map[memoryKey]*memoryCoupon{},
+		reservations: map[string]*memoryReservation{},
+		idempotency:  map[memoryIdempotencyKey]*memoryIdempotencyRecord{},
+	}
+}
+
+func (m *MemoryRepository) CheckCouponExist(
+	ctx context.Context,
+	couponName string,
+) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.coupons[memoryKey{TenantFromContext(ctx), couponName}]
+	return ok, nil
+}
+
+func (m *MemoryRepository) InsertCoupon(
+	ctx context.Context,
+	coupon Coupons,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memoryKey{TenantFromContext(ctx), coupon.Name}
+	if _, ok := m.coupons[key]; ok {
+		m.log.Warn("coupon already exists", "coupon_name", coupon.Name)
+		return ErrCouponAlreadyExists
+	}
+
+	coupon.StartsAt = copyTime(coupon.StartsAt)
+	coupon.EndsAt = copyTime(coupon.EndsAt)
+	created, err := json.Marshal(CouponCreatedData{
+		Amount:           coupon.Amount,
+		MaxClaimsPerUser: coupon.MaxClaimsPerUser,
+		StartsAt:         coupon.StartsAt,
+		EndsAt:           coupon.EndsAt,
+	})
+	if err != nil {
+		m.log.Error("failed to encode event", "event_type", EventCouponCreated, "coupon_name", coupon.Name, "error", err)
+		return err
+	}
+
+	now := time.Now()
+	m.appendEvent(key, EventCouponCreated, created, now)
+	m.coupons[key] = &memoryCoupon{
+		tenant:    key.tenant,
+		coupon:    coupon,
+		version:   1,
+		createdAt: now,
+		held:      map[string]int{},
+	}
+
+	m.log.Info("coupon inserted successfully", "coupon_name", coupon.Name)
+	return nil
+}
+
+func (m *MemoryRepository) UpdateCoupon(
+	ctx context.Context,
+	couponName string,
+	expectedVersion int64,
+	req UpdateCouponRequest,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.coupon(ctx, couponName)
+	if err != nil {
+		return err
+	}
+	if c.version != expectedVersion {
+		m.log.Warn("coupon version mismatch", "coupon_name", couponName, "expected_version", expectedVersion, "version", c.version)
+		return ErrVersionMismatch
+	}
+
+	updated := c.coupon
+	if req.Amount != nil {
+		updated.Amount = *req.Amount
+	}
+	if req.MaxClaimsPerUser != nil {
+		updated.MaxClaimsPerUser = *req.MaxClaimsPerUser
+	}
+	if req.StartsAt != nil {
+		updated.StartsAt = copyTime(req.StartsAt)
+	}
+	if req.EndsAt != nil {
+		updated.EndsAt = copyTime(req.EndsAt)
+	}
+
+	if updated.StartsAt != nil && updated.EndsAt != nil &&
+		!updated.EndsAt.After(*updated.StartsAt) {
+		return ErrInvalidValidityWindow
+	}
+	if used := len(c.claims) + c.reserved; updated.Amount < used {
+		m.log.Warn("amount below claimed", "coupon_name", couponName, "amount", updated.Amount, "used", used)
+		return ErrAmountBelowClaimed
+	}
+
+	now := time.Now()
+	soldOut := c.coupon.Amount == len(c.claims)
+	c.coupon = updated
+	c.version++
+	if !soldOut && updated.Amount == len(c.claims) {
+		m.appendSoldOut(c, couponName, now)
+	}
+	m.promoteWaitlist(c, now)
+
+	m.log.Info("coupon updated successfully", "coupon_name", couponName, "version", c.version)
+	return nil
+}
+
+func (m *MemoryRepository) GetCouponDetails(
+	ctx context.Context,
+	couponName string,
+	claimedByLimit int,
+) (*Details, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.coupon(ctx, couponName)
+	if err != nil {
+		return nil, err
+	}
+
+	details := c.details()
+	details.ClaimedBy = []string{}
+	for _, claim := range c.claims[:min(claimedByLimit, len(c.claims))] {
+		details.ClaimedBy = append(details.ClaimedBy, claim.UserID)
+	}
+	return &details, nil
+}
+
+// ListCoupons orders and pages exactly like the Postgres query: newest first,
+// keyset on (created_at, name).
+func (m *MemoryRepository) ListCoupons(
+	ctx context.Context,
+	filter CouponFilter,
+) ([]Details, error) {
+	switch filter.Status {
+	case "", CouponStatusActive, CouponStatusExpired, CouponStatusSoldOut:
+	default:
+		return nil, ErrInvalidStatusFilter
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	tenant := TenantFromContext(ctx)
+	coupons := []Details{}
+	for key, c := range m.coupons {
+		if key.tenant != tenant || !strings.HasPrefix(key.name, filter.NamePrefix) {
+			continue
+		}
+		if filter.CreatedAfter != nil && c.createdAt.Before(*filter.CreatedAfter) {
+			continue
+		}
+		if filter.CreatedBefore != nil && !c.createdAt.Before(*filter.CreatedBefore) {
+			continue
+		}
+		if filter.After != nil && compareCouponPosition(c.createdAt, key.name, filter.After) >= 0 {
+			continue
+		}
+		if !c.hasStatus(filter.Status, now) {
+			continue
+		}
+		coupons = append(coupons, c.details())
+	}
+
+	slices.SortFunc(coupons, func(a, b Details) int {
+		return -compareCouponPosition(a.CreatedAt, a.Name, &CouponCursor{CreatedAt: b.CreatedAt, Name: b.Name})
+	})
+	if len(coupons) > filter.Limit {
+		coupons = coupons[:filter.Limit]
+	}
+	return coupons, nil
+}
+
+func (m *MemoryRepository) ClaimCoupon(
+	ctx context.Context,
+	req ClaimCouponRequest,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.coupon(ctx, req.CouponName)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := c.state(now).rejection(m.log, req.CouponName); err != nil {
+		return err
+	}
+	if err := m.checkUserClaimLimit(c, req.CouponName, req.UserId); err != nil {
+		return err
+	}
+
+	claim := m.insertClaim(c, req.CouponName, req.UserId, now)
+	m.log.Info("coupon claimed successfully", "coupon_name", req.CouponName, "user_id", req.UserId, "claim_id", claim.ID)
+	return nil
+}
+
+func (m *MemoryRepository) ListCouponClaims(
+	ctx context.Context,
+	couponName string,
+	afterID int64,
+	limit int,
+) ([]ClaimHistory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	claims := []ClaimHistory{}
+	c, ok := m.coupons[memoryKey{TenantFromContext(ctx), couponName}]
+	if !ok {
+		return claims, nil
+	}
+	for _, claim := range c.claims {
+		if len(claims) == limit {
+			break
+		}
+		if claim.ID > afterID {
+			claims = append(claims, *claim)
+		}
+	}
+	return claims, nil
+}
+
+func (m *MemoryRepository) RedeemClaim(
+	ctx context.Context,
+	req RedeemCouponRequest,
+) (*ClaimHistory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.coupons[memoryKey{TenantFromContext(ctx), req.CouponName}]
+	var claims []*ClaimHistory
+	if ok {
+		claims = c.userClaims(req.UserId)
+	}
+	if len(claims) == 0 {
+		m.log.Warn("claim not found", "coupon_name", req.CouponName, "user_id", req.UserId)
+		return nil, ErrClaimNotFound
+	}
+
+	var target *ClaimHistory
+	for _, claim := range claims {
+		if claim.OrderRef != nil && *claim.OrderRef == req.OrderRef {
+			if claim.Status == ClaimStatusRedeemed {
+				result := *claim
+				return &result, nil
+			}
+			m.log.Warn("claim for order is no longer redeemable", "claim_id", claim.ID, "status", claim.Status)
+			return nil, ErrClaimNotRedeemable
+		}
+		if target == nil && claim.Status == ClaimStatusClaimed {
+			target = claim
+		}
+	}
+	if target == nil {
+		m.log.Warn("no redeemable claim", "coupon_name", req.CouponName, "user_id", req.UserId)
+		return nil, ErrClaimNotRedeemable
+	}
+
+	now := time.Now()
+	if c.state(now).expired {
+		setMemoryClaimStatus(target, ClaimStatusExpired, nil, now)
+		m.log.Warn("claim expired before redemption", "claim_id", target.ID, "coupon_name", req.CouponName)
+		return nil, ErrCouponExpired
+	}
+
+	orderRef := req.OrderRef
+	setMemoryClaimStatus(target, ClaimStatusRedeemed, &orderRef, now)
+	m.log.Info("claim redeemed successfully", "claim_id", target.ID, "order_ref", req.OrderRef)
+	result := *target
+	return &result, nil
+}
+
+func (m *MemoryRepository) VoidClaim(
+	ctx context.Context,
+	req VoidCouponRequest,
+) (*ClaimHistory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var target *ClaimHistory
+	if c, ok := m.coupons[memoryKey{TenantFromContext(ctx), req.CouponName}]; ok {
+		for _, claim := range c.userClaims(req.UserId) {
+			if claim.OrderRef != nil && *claim.OrderRef == req.OrderRef {
+				target = claim
+				break
+			}
+		}
+	}
+	if target == nil {
+		m.log.Warn("claim not found for order", "coupon_name", req.CouponName, "user_id", req.UserId, "order_ref", req.OrderRef)
+		return nil, ErrClaimNotFound
+	}
+
+	switch target.Status {
+	case ClaimStatusVoided:
+	case ClaimStatusRedeemed:
+		setMemoryClaimStatus(target, ClaimStatusVoided, target.OrderRef, time.Now())
+		m.log.Info("claim voided successfully", "claim_id", target.ID, "order_ref", req.OrderRef)
+	default:
+		m.log.Warn("claim cannot be voided", "claim_id", target.ID, "status", target.Status)
+		return nil, ErrClaimNotVoidable
+	}
+
+	result := *target
+	return &result, nil
+}
+
+func (m *MemoryRepository) ReleaseClaim(
+	ctx context.Context,
+	couponName string,
+	userID string,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.coupon(ctx, couponName)
+	if err != nil {
+		return err
+	}
+
+	claims := c.userClaims(userID)
+	if len(claims) == 0 {
+		m.log.Warn("claim not found", "coupon_name", couponName, "user_id", userID)
+		return ErrClaimNotFound
+	}
+
+	var target *ClaimHistory
+	for i := len(claims) - 1; i >= 0; i-- {
+		if claims[i].Status == ClaimStatusClaimed {
+			target = claims[i]
+			break
+		}
+	}
+	if target == nil {
+		m.log.Warn("no releasable claim", "coupon_name", couponName, "user_id", userID)
+		return ErrClaimNotReleasable
+	}
+
+	c.claims = slices.DeleteFunc(c.claims, func(claim *ClaimHistory) bool { return claim == target })
+	c.release(userID)
+	m.forgetClaim(c, target.ID)
+	m.promoteWaitlist(c, time.Now())
+
+	m.log.Info("claim released successfully", "claim_id", target.ID, "coupon_name", couponName, "user_id", userID)
+	return nil
+}
+
+// forgetClaim clears the references to a deleted claim, as ON DELETE SET NULL
+// does in Postgres. m.mu must be held.
+func (m *MemoryRepository) forgetClaim(c *memoryCoupon, claimID int64) {
+	for _, reservation := range m.reservations {
+		if reservation.ClaimID != nil && *reservation.ClaimID == claimID {
+			reservation.ClaimID = nil
+		}
+	}
+	for _, entry := range c.waitlist {
+		if entry.ClaimID != nil && *entry.ClaimID == claimID {
+			entry.ClaimID = nil
+		}
+	}
+}
+
+func (m *MemoryRepository) ListUserClaims(
+	ctx context.Context,
+	filter UserClaimFilter,
+) ([]UserClaim, error) {
+	if _, ok := claimSortColumns[filter.Sort]; !ok {
+		return nil, ErrInvalidSort
+	}
+
+	var after *ClaimHistory
+	if filter.After != nil {
+		after = &ClaimHistory{ID: filter.After.ID, CouponName: filter.After.Value}
+		if filter.Sort != ClaimSortCouponName {
+			value, err := time.Parse(time.RFC3339Nano, filter.After.Value)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			after.ClaimedAt, after.UpdatedAt = value, value
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tenant := TenantFromContext(ctx)
+	claims := []UserClaim{}
+	for key, c := range m.coupons {
+		if key.tenant != tenant {
+			continue
+		}
+		for _, claim := range c.userClaims(filter.UserID) {
+			if filter.Status != "" && claim.Status != filter.Status {
+				continue
+			}
+			claims = append(claims, UserClaim{Claim: *claim, Coupon: c.details()})
+		}
+	}
+
+	order := func(a, b *ClaimHistory) int {
+		result := compareClaims(filter.Sort, a, b)
+		if filter.Desc {
+			return -result
+		}
+		return result
+	}
+	if after != nil {
+		claims = slices.DeleteFunc(claims, func(uc UserClaim) bool { return order(&uc.Claim, after) <= 0 })
+	}
+	slices.SortFunc(claims, func(a, b UserClaim) int { return order(&a.Claim, &b.Claim) })
+	if len(claims) > filter.Limit {
+		claims = claims[:filter.Limit]
+	}
+	return claims, nil
+}
+
+// VerifyClaimedCounts never finds a mismatch: the claimed count is the length
+// of the coupon's claim list, so it cannot drift.
+func (m *MemoryRepository) VerifyClaimedCounts(
+	ctx context.Context,
+	fix bool,
+) ([]ClaimCountMismatch, error) {
+	return nil, nil
+}
+
+func (m *MemoryRepository) CreateReservation(
+	ctx context.Context,
+	couponName string,
+	userID string,
+	ttl time.Duration,
+) (*Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.coupon(ctx, couponName)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := c.state(now).rejection(m.log, couponName); err != nil {
+		return nil, err
+	}
+	if err := m.checkUserClaimLimit(c, couponName, userID); err != nil {
+		return nil, err
+	}
+
+	reservation := &memoryReservation{
+		coupon: memoryKey{TenantFromContext(ctx), couponName},
+		Reservation: Reservation{
+			ID:         newUUID(),
+			CouponName: couponName,
+			UserID:     userID,
+			Status:     ReservationStatusActive,
+			ExpiresAt:  now.Add(ttl),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		},
+	}
+	m.reservations[reservation.ID] = reservation
+	c.reserved++
+	c.held[userID]++
+
+	m.log.Info("reservation created successfully", "reservation_id", reservation.ID, "coupon_name", couponName, "user_id", userID)
+	result := reservation.Reservation
+	return &result, nil
+}
+
+func (m *MemoryRepository) GetReservation(
+	ctx context.Context,
+	id string,
+) (*Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservation, err := m.reservation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	result := reservation.Reservation
+	return &result, nil
+}
+
+func (m *MemoryRepository) ConfirmReservation(
+	ctx context.Context,
+	id string,
+) (*Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservation, err := m.reservation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch reservation.Status {
+	case ReservationStatusConfirmed:
+		result := reservation.Reservation
+		return &result, nil
+	case ReservationStatusExpired:
+		m.log.Warn("reservation expired", "reservation_id", id)
+		return nil, ErrReservationExpired
+	case ReservationStatusCancelled:
+		m.log.Warn("reservation is no longer active", "reservation_id", id, "status", reservation.Status)
+		return nil, ErrReservationNotActive
+	}
+
+	c := m.coupons[reservation.coupon]
+	now := time.Now()
+	couponExpired := c.state(now).expired
+	if couponExpired || !reservation.ExpiresAt.After(now) {
+		m.releaseReservation(c, reservation, ReservationStatusExpired, now)
+		if couponExpired {
+			m.log.Warn("coupon expired before reservation was confirmed", "reservation_id", id, "coupon_name", reservation.CouponName)
+			return nil, ErrCouponExpired
+		}
+		m.log.Warn("reservation expired", "reservation_id", id)
+		return nil, ErrReservationExpired
+	}
+
+	// The unit moves from reserved to claimed, and the user's hold from the
+	// reservation to the claim, without ever being free in between.
+	c.reserved--
+	c.release(reservation.UserID)
+	claim := m.insertClaim(c, reservation.CouponName, reservation.UserID, now)
+
+	reservation.Status = ReservationStatusConfirmed
+	reservation.ClaimID = &claim.ID
+	reservation.UpdatedAt = now
+
+	m.log.Info("reservation confirmed successfully", "reservation_id", id, "claim_id", claim.ID)
+	result := reservation.Reservation
+	return &result, nil
+}
+
+func (m *MemoryRepository) CancelReservation(
+	ctx context.Context,
+	id string,
+) (*Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservation, err := m.reservation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch reservation.Status {
+	case ReservationStatusCancelled:
+	case ReservationStatusConfirmed, ReservationStatusExpired:
+		m.log.Warn("reservation is no longer active", "reservation_id", id, "status", reservation.Status)
+		return nil, ErrReservationNotActive
+	default:
+		m.releaseReservation(m.coupons[reservation.coupon], reservation, ReservationStatusCancelled, time.Now())
+		m.log.Info("reservation cancelled successfully", "reservation_id", id, "coupon_name", reservation.CouponName)
+	}
+
+	result := reservation.Reservation
+	return &result, nil
+}
+
+// ExpireReservations returns the stock of holds whose TTL has passed, across
+// all tenants, for at most maxCoupons coupons per call.
+func (m *MemoryRepository) ExpireReservations(
+	ctx context.Context,
+	maxCoupons int,
+) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	swept := map[memoryKey]bool{}
+	var total int64
+	for _, reservation := range m.reservations {
+		if reservation.Status != ReservationStatusActive || reservation.ExpiresAt.After(now) {
+			continue
+		}
+		if !swept[reservation.coupon] {
+			if len(swept) == maxCoupons {
+				continue
+			}
+			swept[reservation.coupon] = true
+		}
+		m.releaseReservation(m.coupons[reservation.coupon], reservation, ReservationStatusExpired, now)
+		total++
+	}
+
+	return total, nil
+}
+
+func (m *MemoryRepository) JoinWaitlist(
+	ctx context.Context,
+	couponName string,
+	userID string,
+) (*WaitlistEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.coupon(ctx, couponName)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	state := c.state(now)
+	switch {
+	case state.notYetActive:
+		m.log.Warn("coupon not yet active", "coupon_name", couponName)
+		return nil, ErrCouponNotYetActive
+	case state.expired:
+		m.log.Warn("coupon expired", "coupon_name", couponName)
+		return nil, ErrCouponExpired
+	case state.claimable():
+		m.log.Warn("coupon in stock", "coupon_name", couponName)
+		return nil, ErrCouponInStock
+	}
+
+	if err := m.checkUserClaimLimit(c, couponName, userID); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range c.waitlist {
+		if entry.UserID == userID && entry.Status == WaitlistStatusWaiting {
+			m.log.Warn("user already on waitlist", "coupon_name", couponName, "user_id", userID)
+			return nil, ErrAlreadyOnWaitlist
+		}
+	}
+
+	m.nextWaitlistID++
+	entry := &WaitlistEntry{
+		ID:         m.nextWaitlistID,
+		CouponName: couponName,
+		UserID:     userID,
+		Status:     WaitlistStatusWaiting,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	c.waitlist = append(c.waitlist, entry)
+
+	result := c.waitlistEntry(entry)
+	m.log.Info("user joined waitlist", "coupon_name", couponName, "user_id", userID, "position", result.Position)
+	return &result, nil
+}
+
+func (m *MemoryRepository) GetWaitlistEntry(
+	ctx context.Context,
+	couponName string,
+	userID string,
+) (*WaitlistEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.coupons[memoryKey{TenantFromContext(ctx), couponName}]; ok {
+		for i := len(c.waitlist) - 1; i >= 0; i-- {
+			if c.waitlist[i].UserID == userID {
+				result := c.waitlistEntry(c.waitlist[i])
+				return &result, nil
+			}
+		}
+	}
+
+	m.log.Warn("waitlist entry not found", "coupon_name", couponName, "user_id", userID)
+	return nil, ErrWaitlistEntryNotFound
+}
+
+func (m *MemoryRepository) LeaveWaitlist(
+	ctx context.Context,
+	couponName string,
+	userID string,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.coupon(ctx, couponName)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range c.waitlist {
+		if entry.UserID == userID && entry.Status == WaitlistStatusWaiting {
+			entry.Status = WaitlistStatusLeft
+			entry.UpdatedAt = time.Now()
+			m.log.Info("user left waitlist", "coupon_name", couponName, "user_id", userID)
+			return nil
+		}
+	}
+
+	m.log.Warn("waitlist entry not found", "coupon_name", couponName, "user_id", userID)
+	return ErrWaitlistEntryNotFound
+}
+
+func (m *MemoryRepository) BeginIdempotentRequest(
+	ctx context.Context,
+	scope string,
+	principal string,
+	key string,
+	requestHash string,
+	ttl time.Duration,
+	lease time.Duration,
+) (*IdempotencyRecord, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	id := memoryIdempotencyKey{TenantFromContext(ctx), scope, principal, key}
+	stored, ok := m.idempotency[id]
+	if !ok || !stored.expiresAt.After(now) {
+		stored = &memoryIdempotencyRecord{
+			record:      IdempotencyRecord{RequestHash: requestHash},
+			expiresAt:   now.Add(ttl),
+			lockedUntil: now.Add(lease),
+			leaseToken:  newUUID(),
+		}
+		m.idempotency[id] = stored
+		return nil, stored.leaseToken, nil
+	}
+
+	if stored.record.RequestHash != requestHash {
+		m.log.Warn("idempotency key reused with different request", "scope", scope, "key", key)
+		return nil, "", ErrIdempotencyKeyReused
+	}
+	if !stored.completed && !stored.lockedUntil.After(now) {
+		m.log.Warn("taking over abandoned idempotency key", "scope", scope, "key", key)
+		stored.lockedUntil = now.Add(lease)
+		stored.leaseToken = newUUID()
+		return nil, stored.leaseToken, nil
+	}
+	if !stored.completed {
+		m.log.Warn("idempotency key still in progress", "scope", scope, "key", key)
+		return nil, "", ErrIdempotencyKeyInProgress
+	}
+
+	record := stored.record
+	record.Body = slices.Clone(record.Body)
+	return &record, "", nil
+}
+
+func (m *MemoryRepository) CompleteIdempotentRequest(
+	ctx context.Context,
+	scope string,
+	principal string,
+	key string,
+	leaseToken string,
+	record IdempotencyRecord,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.idempotency[memoryIdempotencyKey{TenantFromContext(ctx), scope, principal, key}]
+	if !ok || stored.completed || stored.leaseToken != leaseToken {
+		m.log.Warn("idempotency lease lost, response not recorded", "scope", scope, "key", key)
+		return nil
+	}
+	stored.record.StatusCode = record.StatusCode
+	stored.record.ContentType = record.ContentType
+	stored.record.Body = slices.Clone(record.Body)
+	stored.completed = true
+	return nil
+}
+
+func (m *MemoryRepository) AbandonIdempotentRequest(
+	ctx context.Context,
+	scope string,
+	principal string,
+	key string,
+	leaseToken string,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := memoryIdempotencyKey{TenantFromContext(ctx), scope, principal, key}
+	if stored, ok := m.idempotency[id]; ok && !stored.completed && stored.leaseToken == leaseToken {
+		delete(m.idempotency, id)
+	}
+	return nil
+}
+
+func (m *MemoryRepository) PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var purged int64
+	for id, stored := range m.idempotency {
+		if !stored.expiresAt.After(now) {
+			delete(m.idempotency, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *MemoryRepository) LeasePendingEvents(
+	ctx context.Context,
+	limit int,
+	lease time.Duration,
+) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var events []Event
+	for _, event := range m.events {
+		if len(events) == limit {
+			break
+		}
+		if event.status != EventStatusPending || event.nextAttemptAt.After(now) {
+			continue
+		}
+		event.Attempts++
+		event.nextAttemptAt = now.Add(lease)
+		leased := event.Event
+		leased.Data = slices.Clone(event.Data)
+		leased.DeliveredTo = slices.Clone(event.DeliveredTo)
+		events = append(events, leased)
+	}
+	return events, nil
+}
+
+func (m *MemoryRepository) MarkEventDelivered(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event, err := m.event(id)
+	if err != nil {
+		return err
+	}
+	event.status = EventStatusDelivered
+	event.lastError = ""
+	event.deliveredAt = time.Now()
+	return nil
+}
+
+func (m *MemoryRepository) MarkEventSinkDelivered(
+	ctx context.Context,
+	id int64,
+	sink string,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event, err := m.event(id)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(event.DeliveredTo, sink) {
+		event.DeliveredTo = append(event.DeliveredTo, sink)
+	}
+	return nil
+}
+
+func (m *MemoryRepository) MarkEventRetry(
+	ctx context.Context,
+	id int64,
+	reason string,
+	retryAt time.Time,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event, err := m.event(id)
+	if err != nil {
+		return err
+	}
+	event.status = EventStatusPending
+	event.lastError = reason
+	event.nextAttemptAt = retryAt
+	return nil
+}
+
+func (m *MemoryRepository) MarkEventFailed(
+	ctx context.Context,
+	id int64,
+	reason string,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event, err := m.event(id)
+	if err != nil {
+		return err
+	}
+	event.status = EventStatusFailed
+	event.lastError = reason
+	return nil
+}
+
+func (m *MemoryRepository) PurgeDeliveredEvents(
+	ctx context.Context,
+	retention time.Duration,
+) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+	before := len(m.events)
+	m.events = slices.DeleteFunc(m.events, func(event *memoryEvent) bool {
+		return event.status == EventStatusDelivered && event.deliveredAt.Before(cutoff)
+	})
+	return int64(before - len(m.events)), nil
+}
+
+// coupon looks up a coupon of the tenant in ctx. m.mu must be held.
+func (m *MemoryRepository) coupon(ctx context.Context, couponName string) (*memoryCoupon, error) {
+	c, ok := m.coupons[memoryKey{TenantFromContext(ctx), couponName}]
+	if !ok {
+		m.log.Warn("coupon not found", "coupon_name", couponName)
+		return nil, ErrCouponNotFound
+	}
+	return c, nil
+}
+
+// reservation looks up a reservation of the tenant in ctx. Ids are matched
+// case-insensitively, like the uuid column. m.mu must be held.
+func (m *MemoryRepository) reservation(ctx context.Context, id string) (*memoryReservation, error) {
+	reservation, ok := m.reservations[strings.ToLower(id)]
+	if !ok || reservation.coupon.tenant != TenantFromContext(ctx) {
+		m.log.Warn("reservation not found", "reservation_id", id)
+		return nil, ErrReservationNotFound
+	}
+	return reservation, nil
+}
+
+// event looks up an outbox event by id. m.mu must be held.
+func (m *MemoryRepository) event(id int64) (*memoryEvent, error) {
+	i, ok := slices.BinarySearchFunc(m.events, id, func(event *memoryEvent, id int64) int {
+		return cmp.Compare(event.ID, id)
+	})
+	if !ok {
+		m.log.Warn("event not found", "event_id", id)
+		return nil, ErrEventNotFound
+	}
+	return m.events[i], nil
+}
+
+// appendEvent adds a pending event to the outbox. m.mu must be held.
+func (m *MemoryRepository) appendEvent(
+	coupon memoryKey,
+	eventType EventType,
+	payload json.RawMessage,
+	now time.Time,
+) {
+	m.nextEventID++
+	m.events = append(m.events, &memoryEvent{
+		Event: Event{
+			ID:         m.nextEventID,
+			Type:       eventType,
+			TenantID:   coupon.tenant,
+			CouponName: coupon.name,
+			Data:       payload,
+			OccurredAt: now,
+		},
+		status:        EventStatusPending,
+		nextAttemptAt: now,
+	})
+}
+
+func (m *MemoryRepository) checkUserClaimLimit(c *memoryCoupon, couponName string, userID string) error {
+	if c.held[userID] >= c.coupon.MaxClaimsPerUser {
+		m.log.Warn("per-user claim limit reached", "coupon_name", couponName, "user_id", userID, "limit", c.coupon.MaxClaimsPerUser)
+		return ErrClaimLimitReached
+	}
+	return nil
+}
+
+func (m *MemoryRepository) insertClaim(c *memoryCoupon, couponName string, userID string, now time.Time) *ClaimHistory {
+	m.nextClaimID++
+	claim := &ClaimHistory{
+		ID:         m.nextClaimID,
+		UserID:     userID,
+		CouponName: couponName,
+		Status:     ClaimStatusClaimed,
+		ClaimedAt:  now,
+		UpdatedAt:  now,
+	}
+	c.claims = append(c.claims, claim)
+	c.held[userID]++
+
+	// Like the Postgres insertClaim, every new claim is recorded in the outbox.
+	// The payloads hold only strings and integers, so encoding cannot fail.
+	key := memoryKey{c.tenant, couponName}
+	claimed, _ := json.Marshal(CouponClaimedData{
+		UserID:          userID,
+		ClaimID:         claim.ID,
+		RemainingAmount: c.details().RemainingAmount,
+	})
+	m.appendEvent(key, EventCouponClaimed, claimed, now)
+	if len(c.claims) == c.coupon.Amount {
+		m.appendSoldOut(c, couponName, now)
+	}
+	return claim
+}
+
+func (m *MemoryRepository) appendSoldOut(c *memoryCoupon, couponName string, now time.Time) {
+	soldOut, _ := json.Marshal(CouponSoldOutData{Amount: c.coupon.Amount})
+	m.appendEvent(memoryKey{c.tenant, couponName}, EventCouponSoldOut, soldOut, now)
+}
+
+// releaseReservation ends an active hold with status, returns its unit of
+// stock and promotes the waitlist.
+func (m *MemoryRepository) releaseReservation(
+	c *memoryCoupon,
+	reservation *memoryReservation,
+	status ReservationStatus,
+	now time.Time,
+) {
+	reservation.Status = status
+	reservation.UpdatedAt = now
+	c.reserved--
+	c.release(reservation.UserID)
+	m.promoteWaitlist(c, now)
+}
+
+// promoteWaitlist hands free stock to waiting users in join order, skipping
+// users who have since reached their claim limit.
+func (m *MemoryRepository) promoteWaitlist(c *memoryCoupon, now time.Time) {
+	for _, entry := range c.waitlist {
+		if !c.state(now).claimable() {
+			return
+		}
+		if entry.Status != WaitlistStatusWaiting {
+			continue
+		}
+
+		entry.UpdatedAt = now
+		if c.held[entry.UserID] >= c.coupon.MaxClaimsPerUser {
+			entry.Status = WaitlistStatusSkipped
+			continue
+		}
+
+		claim := m.insertClaim(c, entry.CouponName, entry.UserID, now)
+		entry.Status = WaitlistStatusPromoted
+		entry.ClaimID = &claim.ID
+		m.log.Info("waitlist entry promoted", "coupon_name", entry.CouponName, "user_id", entry.UserID, "claim_id", claim.ID)
+	}
+}
+
+func (c *memoryCoupon) state(now time.Time) stockState {
+	return stockState{
+		amount:           c.coupon.Amount,
+		claimed:          len(c.claims),
+		reserved:         c.reserved,
+		maxClaimsPerUser: c.coupon.MaxClaimsPerUser,
+		version:          c.version,
+		notYetActive:     c.coupon.StartsAt != nil && c.coupon.StartsAt.After(now),
+		expired:          c.coupon.EndsAt != nil && !c.coupon.EndsAt.After(now),
+	}
+}
+
+func (c *memoryCoupon) hasStatus(status CouponStatus, now time.Time) bool {
+	state := c.state(now)
+	switch status {
+	case CouponStatusActive:
+		return state.claimable()
+	case CouponStatusExpired:
+		return state.expired
+	case CouponStatusSoldOut:
+		return state.claimed+state.reserved >= state.amount
+	default:
+		return true
+	}
+}
+
+func (c *memoryCoupon) details() Details {
+	return Details{
+		Name:             c.coupon.Name,
+		Amount:           c.coupon.Amount,
+		RemainingAmount:  c.coupon.Amount - len(c.claims) - c.reserved,
+		ClaimedCount:     len(c.claims),
+		ReservedCount:    c.reserved,
+		MaxClaimsPerUser: c.coupon.MaxClaimsPerUser,
+		StartsAt:         copyTime(c.coupon.StartsAt),
+		EndsAt:           copyTime(c.coupon.EndsAt),
+		Version:          c.version,
+		CreatedAt:        c.createdAt,
+	}
+}
+
+func (c *memoryCoupon) userClaims(userID string) []*ClaimHistory {
+	var claims []*ClaimHistory
+	for _, claim := range c.claims {
+		if claim.UserID == userID {
+			claims = append(claims, claim)
+		}
+	}
+	return claims
+}
+
+// release drops one claim or reservation from the user's hold.
+func (c *memoryCoupon) release(userID string) {
+	if c.held[userID]--; c.held[userID] <= 0 {
+		delete(c.held, userID)
+	}
+}
+
+// waitlistEntry copies entry with its current queue position.
+func (c *memoryCoupon) waitlistEntry(entry *WaitlistEntry) WaitlistEntry {
+	result := *entry
+	if entry.Status == WaitlistStatusWaiting {
+		for _, other := range c.waitlist {
+			if other.Status == WaitlistStatusWaiting && other.ID <= entry.ID {
+				result.Position++
+			}
+		}
+	}
+	return result
+}
+
+func setMemoryClaimStatus(claim *ClaimHistory, status ClaimStatus, orderRef *string, now time.Time) {
+	claim.Status = status
+	claim.OrderRef = orderRef
+	claim.UpdatedAt = now
+}
+
+// compareCouponPosition orders coupons by (created_at, name), the keyset the
+// coupon list pages on.
+func compareCouponPosition(createdAt time.Time, name string, other *CouponCursor) int {
+	if result := createdAt.Compare(other.CreatedAt); result != 0 {
+		return result
+	}
+	return strings.Compare(name, other.Name)
+}
+
+// compareClaims orders claims by the sort column with the id as tie-breaker.
+func compareClaims(sort ClaimSort, a, b *ClaimHistory) int {
+	var result int
+	switch sort {
+	case ClaimSortClaimedAt:
+		result = a.ClaimedAt.Compare(b.ClaimedAt)
+	case ClaimSortUpdatedAt:
+		result = a.UpdatedAt.Compare(b.UpdatedAt)
+	case ClaimSortCouponName:
+		result = strings.Compare(a.CouponName, b.CouponName)
+	}
+	if result == 0 {
+		result = cmp.Compare(a.ID, b.ID)
+	}
+	return result
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+// newUUID returns a random version 4 UUID in the lowercase form Postgres
+// prints, for reservation ids and idempotency lease tokens.
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
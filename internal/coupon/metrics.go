@@ -0,0 +1,109 @@
+package coupon
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"scalable-coupon-system/internal/metrics"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Metrics are the coupon-specific series. A nil *Metrics records nothing, so
+// repositories built without one (tests, cmd/claimcount) need no registry.
+type Metrics struct {
+	claims     *metrics.CounterVec
+	txDuration *metrics.HistogramVec
+}
+
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	return &Metrics{
+		claims: reg.NewCounter(
+			"coupon_claims_total",
+			"Claim attempts by outcome.",
+			"outcome",
+		),
+		txDuration: reg.NewHistogram(
+			"coupon_db_transaction_duration_seconds",
+			"Database transaction duration by operation and outcome (commit or rollback).",
+			metrics.DefaultBuckets,
+			"operation", "outcome",
+		),
+	}
+}
+
+// claimOutcome turns a claim result into a bounded label value.
+func claimOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrClaimLimitReached):
+		return "already_claimed"
+	case errors.Is(err, ErrCouponOutOfStock):
+		return "out_of_stock"
+	case errors.Is(err, ErrCouponNotFound):
+		return "not_found"
+	case errors.Is(err, ErrCouponNotYetActive):
+		return "not_yet_active"
+	case errors.Is(err, ErrCouponExpired):
+		return "expired"
+	case errors.Is(err, ErrClaimContention):
+		return "contention"
+	case errors.Is(err, ErrValidationFailed):
+		return "invalid"
+	default:
+		return "error"
+	}
+}
+
+func (m *Metrics) observeClaim(err error) {
+	if m == nil {
+		return
+	}
+	m.claims.Inc(claimOutcome(err))
+}
+
+// beginTx starts a transaction whose duration, from begin to commit or
+// rollback, is recorded under operation.
+func (r *PostgresRepository) beginTx(ctx context.Context, operation string) (pgx.Tx, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil || r.metrics == nil {
+		return tx, err
+	}
+	return &timedTx{Tx: tx, metrics: r.metrics, operation: operation, start: time.Now()}, nil
+}
+
+// timedTx records its duration when it ends. The usual deferred Rollback
+// after a successful Commit is a no-op and is not recorded again.
+type timedTx struct {
+	pgx.Tx
+	metrics   *Metrics
+	operation string
+	start     time.Time
+	done      bool
+}
+
+func (t *timedTx) Commit(ctx context.Context) error {
+	err := t.Tx.Commit(ctx)
+	outcome := "commit"
+	if err != nil {
+		outcome = "commit_failed"
+	}
+	t.observe(outcome)
+	return err
+}
+
+func (t *timedTx) Rollback(ctx context.Context) error {
+	err := t.Tx.Rollback(ctx)
+	t.observe("rollback")
+	return err
+}
+
+func (t *timedTx) observe(outcome string) {
+	if t.done {
+		return
+	}
+	t.done = true
+	t.metrics.txDuration.Observe(time.Since(t.start).Seconds(), t.operation, outcome)
+}
@@ -1,18 +1,158 @@
 package coupon
 
+import "time"
+
 type Coupons struct {
-	Name   string
-	Amount int
+	Name             string
+	Amount           int
+	MaxClaimsPerUser int
+	StartsAt         *time.Time
+	EndsAt           *time.Time
 }
 
+type ClaimStatus string
+
+const (
+	ClaimStatusClaimed  ClaimStatus = "claimed"
+	ClaimStatusRedeemed ClaimStatus = "redeemed"
+	ClaimStatusVoided   ClaimStatus = "voided"
+	ClaimStatusExpired  ClaimStatus = "expired"
+)
+
 type ClaimHistory struct {
+	ID         int64
 	UserID     string
 	CouponName string
+	Status     ClaimStatus
+	OrderRef   *string
+	ClaimedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type ReservationStatus string
+
+const (
+	ReservationStatusActive    ReservationStatus = "active"
+	ReservationStatusConfirmed ReservationStatus = "confirmed"
+	ReservationStatusCancelled ReservationStatus = "cancelled"
+	ReservationStatusExpired   ReservationStatus = "expired"
+)
+
+// Reservation holds one unit of a coupon's stock for a user until it is
+// confirmed into a claim, cancelled, or its expiry passes.
+type Reservation struct {
+	ID         string
+	CouponName string
+	UserID     string
+	Status     ReservationStatus
+	ClaimID    *int64
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type WaitlistStatus string
+
+const (
+	WaitlistStatusWaiting  WaitlistStatus = "waiting"
+	WaitlistStatusPromoted WaitlistStatus = "promoted"
+	WaitlistStatusLeft     WaitlistStatus = "left"
+	WaitlistStatusSkipped  WaitlistStatus = "skipped"
+)
+
+// WaitlistEntry is a user's place in a sold-out coupon's queue. Position is
+// 1-based and only set while the entry is waiting; ClaimID is set once the
+// entry has been promoted to a claim.
+type WaitlistEntry struct {
+	ID         int64
+	CouponName string
+	UserID     string
+	Status     WaitlistStatus
+	Position   int
+	ClaimID    *int64
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
 
 type Details struct {
-	Name            string
-	Amount          int
-	RemainingAmount int
-	ClaimedBy       []string
+	Name             string
+	Amount           int
+	RemainingAmount  int
+	ClaimedCount     int
+	ReservedCount    int
+	MaxClaimsPerUser int
+	StartsAt         *time.Time
+	EndsAt           *time.Time
+	Version          int64
+	CreatedAt        time.Time
+	ClaimedBy        []string
+}
+
+type CouponStatus string
+
+const (
+	CouponStatusActive  CouponStatus = "active"
+	CouponStatusExpired CouponStatus = "expired"
+	CouponStatusSoldOut CouponStatus = "sold_out"
+)
+
+// CouponCursor is the keyset position of the last coupon on a page.
+type CouponCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+}
+
+// CouponClaimCursor is the id of the last claim on a coupon claims page.
+type CouponClaimCursor struct {
+	ID int64 `json:"id"`
+}
+
+type CouponFilter struct {
+	NamePrefix    string
+	Status        CouponStatus
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	After         *CouponCursor
+	Limit         int
+}
+
+// ClaimSort is a column the user claim history can be ordered by.
+type ClaimSort string
+
+const (
+	ClaimSortClaimedAt  ClaimSort = "claimed_at"
+	ClaimSortUpdatedAt  ClaimSort = "updated_at"
+	ClaimSortCouponName ClaimSort = "coupon_name"
+)
+
+// ClaimCursor is the keyset position of the last claim on a page. Value holds
+// the sort column of that claim in text form and ID breaks ties.
+type ClaimCursor struct {
+	Sort  ClaimSort `json:"sort"`
+	Desc  bool      `json:"desc"`
+	Value string    `json:"value"`
+	ID    int64     `json:"id"`
+}
+
+type UserClaimFilter struct {
+	UserID string
+	Status ClaimStatus
+	Sort   ClaimSort
+	Desc   bool
+	After  *ClaimCursor
+	Limit  int
+}
+
+type UserClaim struct {
+	Claim  ClaimHistory
+	Coupon Details
+}
+
+// ClaimCountMismatch is a coupon whose stored claimed_count disagrees with
+// its rows in claim_history.
+type ClaimCountMismatch struct {
+	TenantID   string
+	CouponName string
+	Stored     int
+	Actual     int
 }
@@ -0,0 +1,262 @@
+package coupon
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// EventType names a domain event written to the outbox.
+type EventType string
+
+const (
+	EventCouponCreated EventType = "coupon.created"
+	EventCouponClaimed EventType = "coupon.claimed"
+	EventCouponSoldOut EventType = "coupon.sold_out"
+)
+
+// Event is a domain event as delivered to sinks. Delivery is at least once,
+// so consumers should dedupe on ID, which is unique across tenants. Data holds
+// the CouponCreatedData, CouponClaimedData or CouponSoldOutData for Type.
+type Event struct {
+	ID         int64           `json:"id"`
+	Type       EventType       `json:"type"`
+	TenantID   string          `json:"tenant_id"`
+	CouponName string          `json:"coupon_name"`
+	Data       json.RawMessage `json:"data"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	// Attempts counts deliveries started, including the current one.
+	Attempts int `json:"-"`
+	// DeliveredTo names the sinks that have already accepted the event.
+	DeliveredTo []string `json:"-"`
+}
+
+type CouponCreatedData struct {
+	Amount           int        `json:"amount"`
+	MaxClaimsPerUser int        `json:"max_claims_per_user"`
+	StartsAt         *time.Time `json:"starts_at,omitempty"`
+	EndsAt           *time.Time `json:"ends_at,omitempty"`
+}
+
+type CouponClaimedData struct {
+	UserID          string `json:"user_id"`
+	ClaimID         int64  `json:"claim_id"`
+	RemainingAmount int    `json:"remaining_amount"`
+}
+
+// CouponSoldOutData is sent by the claim that claimed the last unit, whether
+// directly, by confirming a reservation or by waitlist promotion, and by an
+// update that lowers the amount to the number of claims. Units held by active
+// reservations do not count as sold.
+type CouponSoldOutData struct {
+	Amount int `json:"amount"`
+}
+
+type EventStatus string
+
+const (
+	EventStatusPending   EventStatus = "pending"
+	EventStatusDelivered EventStatus = "delivered"
+	EventStatusFailed    EventStatus = "failed"
+)
+
+func (r *PostgresRepository) insertEvent(
+	ctx context.Context,
+	tx pgx.Tx,
+	eventType EventType,
+	couponName string,
+	data any,
+) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		r.log.Error("failed to encode event", "event_type", eventType, "coupon_name", couponName, "error", err)
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO outbox (tenant_id, event_type, coupon_name, payload)
+		VALUES ($1, $2, $3, $4)
+	`, TenantFromContext(ctx), eventType, couponName, json.RawMessage(payload))
+	if err != nil {
+		r.log.Error("failed to insert event", "event_type", eventType, "coupon_name", couponName, "error", err)
+		return err
+	}
+	return nil
+}
+
+// insertClaimEvents records a new claim, and the sell-out if it claimed the
+// last unit. It must run in the transaction that created the claim, with the
+// stock the update that took the unit returned.
+func (r *PostgresRepository) insertClaimEvents(
+	ctx context.Context,
+	tx pgx.Tx,
+	couponName string,
+	userID string,
+	claimID int64,
+	stock CouponStock,
+) error {
+	err := r.insertEvent(ctx, tx, EventCouponClaimed, couponName, CouponClaimedData{
+		UserID:          userID,
+		ClaimID:         claimID,
+		RemainingAmount: stock.Remaining(),
+	})
+	if err != nil {
+		return err
+	}
+	if stock.Claimed == stock.Amount {
+		return r.insertEvent(ctx, tx, EventCouponSoldOut, couponName, CouponSoldOutData{Amount: stock.Amount})
+	}
+	return nil
+}
+
+// LeasePendingEvents returns up to limit pending events of every tenant whose
+// next attempt is due, oldest first, and hides them from other callers for
+// lease. Each returned event counts as an attempt. An event that is neither
+// marked delivered nor failed before the lease ends is returned again.
+func (r *PostgresRepository) LeasePendingEvents(
+	ctx context.Context,
+	limit int,
+	lease time.Duration,
+) ([]Event, error) {
+	rows, err := r.db.Query(ctx, `
+		UPDATE outbox
+		SET attempts = attempts + 1, next_attempt_at = now() + $3::interval
+		WHERE id IN (
+			SELECT id
+			FROM outbox
+			WHERE status = $1 AND next_attempt_at <= now()
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, event_type, tenant_id, coupon_name, payload, created_at, attempts, delivered_sinks
+	`, EventStatusPending, limit, lease)
+	if err != nil {
+		r.log.Error("failed to lease events", "error", err)
+		return nil, err
+	}
+
+	events, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Event, error) {
+		var event Event
+		err := row.Scan(
+			&event.ID,
+			&event.Type,
+			&event.TenantID,
+			&event.CouponName,
+			&event.Data,
+			&event.OccurredAt,
+			&event.Attempts,
+			&event.DeliveredTo,
+		)
+		return event, err
+	})
+	if err != nil {
+		r.log.Error("failed to scan events", "error", err)
+		return nil, err
+	}
+
+	slices.SortFunc(events, func(a, b Event) int { return cmp.Compare(a.ID, b.ID) })
+	return events, nil
+}
+
+func (r *PostgresRepository) MarkEventDelivered(ctx context.Context, id int64) error {
+	return r.setEventStatus(ctx, id, `
+		UPDATE outbox
+		SET status = $2, last_error = NULL, delivered_at = now()
+		WHERE id = $1
+	`, EventStatusDelivered)
+}
+
+// MarkEventSinkDelivered records that sink accepted the event, so later
+// attempts skip it. Recording the same sink twice is a no-op.
+func (r *PostgresRepository) MarkEventSinkDelivered(
+	ctx context.Context,
+	id int64,
+	sink string,
+) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE outbox
+		SET delivered_sinks = CASE
+			WHEN $2::text = ANY(delivered_sinks) THEN delivered_sinks
+			ELSE array_append(delivered_sinks, $2::text)
+		END
+		WHERE id = $1
+	`, id, sink)
+	if err != nil {
+		r.log.Error("failed to record sink delivery", "event_id", id, "sink", sink, "error", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		r.log.Warn("event not found", "event_id", id)
+		return ErrEventNotFound
+	}
+	return nil
+}
+
+// MarkEventRetry keeps the event pending and makes it due again at retryAt.
+func (r *PostgresRepository) MarkEventRetry(
+	ctx context.Context,
+	id int64,
+	reason string,
+	retryAt time.Time,
+) error {
+	return r.setEventStatus(ctx, id, `
+		UPDATE outbox
+		SET status = $2, last_error = $3, next_attempt_at = $4
+		WHERE id = $1
+	`, EventStatusPending, reason, retryAt)
+}
+
+// MarkEventFailed stops delivery of the event; it stays in the outbox with
+// reason for an operator to inspect.
+func (r *PostgresRepository) MarkEventFailed(
+	ctx context.Context,
+	id int64,
+	reason string,
+) error {
+	return r.setEventStatus(ctx, id, `
+		UPDATE outbox
+		SET status = $2, last_error = $3
+		WHERE id = $1
+	`, EventStatusFailed, reason)
+}
+
+func (r *PostgresRepository) setEventStatus(
+	ctx context.Context,
+	id int64,
+	query string,
+	status EventStatus,
+	args ...any,
+) error {
+	tag, err := r.db.Exec(ctx, query, append([]any{id, status}, args...)...)
+	if err != nil {
+		r.log.Error("failed to update event", "event_id", id, "status", status, "error", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		r.log.Warn("event not found", "event_id", id)
+		return ErrEventNotFound
+	}
+	return nil
+}
+
+// PurgeDeliveredEvents deletes events of every tenant that were delivered
+// more than retention ago. Failed events are kept for an operator.
+func (r *PostgresRepository) PurgeDeliveredEvents(
+	ctx context.Context,
+	retention time.Duration,
+) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+		DELETE FROM outbox
+		WHERE status = $1 AND delivered_at < now() - $2::interval
+	`, EventStatusDelivered, retention)
+	if err != nil {
+		r.log.Error("failed to purge delivered events", "error", err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
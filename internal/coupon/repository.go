@@ -3,32 +3,150 @@ package coupon
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"strings"
+	"time"
+
+	"scalable-coupon-system/pkg/couponapi"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type Repository struct {
-	db  *pgxpool.Pool
-	log *slog.Logger
+// SchemaVersion is the latest migration in migration/ that this code relies
+// on. Bump it with every new migration; the internal/migrate tests fail when
+// it falls behind.
+const SchemaVersion = 20
+
+// uniqueViolation is PostgreSQL's SQLSTATE for a duplicate key.
+const uniqueViolation = "23505"
+
+// Repository stores coupons, claims, reservations, waitlists and idempotency
+// keys. Every implementation must serialise stock changes per coupon, so a
+// coupon is never oversold and a user never holds more than its per-user
+// limit, and must report failures with the sentinel errors below. InsertCoupon
+// and ClaimCoupon record their events in the outbox atomically with the change.
+// Methods act on the tenant in ctx, except ExpireReservations,
+// PurgeExpiredIdempotencyKeys, VerifyClaimedCounts and the outbox methods,
+// which cover every tenant.
+type Repository interface {
+	CheckCouponExist(ctx context.Context, couponName string) (bool, error)
+	InsertCoupon(ctx context.Context, coupon Coupons) error
+	UpdateCoupon(ctx context.Context, couponName string, expectedVersion int64, req UpdateCouponRequest) error
+	GetCouponDetails(ctx context.Context, couponName string, claimedByLimit int) (*Details, error)
+	ListCoupons(ctx context.Context, filter CouponFilter) ([]Details, error)
+
+	ClaimCoupon(ctx context.Context, req ClaimCouponRequest) error
+	ListCouponClaims(ctx context.Context, couponName string, afterID int64, limit int) ([]ClaimHistory, error)
+	RedeemClaim(ctx context.Context, req RedeemCouponRequest) (*ClaimHistory, error)
+	VoidClaim(ctx context.Context, req VoidCouponRequest) (*ClaimHistory, error)
+	ReleaseClaim(ctx context.Context, couponName string, userID string) error
+	ListUserClaims(ctx context.Context, filter UserClaimFilter) ([]UserClaim, error)
+	VerifyClaimedCounts(ctx context.Context, fix bool) ([]ClaimCountMismatch, error)
+
+	CreateReservation(ctx context.Context, couponName string, userID string, ttl time.Duration) (*Reservation, error)
+	GetReservation(ctx context.Context, id string) (*Reservation, error)
+	ConfirmReservation(ctx context.Context, id string) (*Reservation, error)
+	CancelReservation(ctx context.Context, id string) (*Reservation, error)
+	ExpireReservations(ctx context.Context, maxCoupons int) (int64, error)
+
+	JoinWaitlist(ctx context.Context, couponName string, userID string) (*WaitlistEntry, error)
+	GetWaitlistEntry(ctx context.Context, couponName string, userID string) (*WaitlistEntry, error)
+	LeaveWaitlist(ctx context.Context, couponName string, userID string) error
+
+	BeginIdempotentRequest(ctx context.Context, scope string, principal string, key string, requestHash string, ttl time.Duration, lease time.Duration) (*IdempotencyRecord, string, error)
+	CompleteIdempotentRequest(ctx context.Context, scope string, principal string, key string, leaseToken string, record IdempotencyRecord) error
+	AbandonIdempotentRequest(ctx context.Context, scope string, principal string, key string, leaseToken string) error
+	PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+
+	LeasePendingEvents(ctx context.Context, limit int, lease time.Duration) ([]Event, error)
+	MarkEventSinkDelivered(ctx context.Context, id int64, sink string) error
+	MarkEventDelivered(ctx context.Context, id int64) error
+	MarkEventRetry(ctx context.Context, id int64, reason string, retryAt time.Time) error
+	MarkEventFailed(ctx context.Context, id int64, reason string) error
+	PurgeDeliveredEvents(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+// PostgresRepository is the Repository backed by the schema in migration/.
+type PostgresRepository struct {
+	db       *pgxpool.Pool
+	log      *slog.Logger
+	strategy ClaimStrategy
+	metrics  *Metrics
 }
 
-func NewRepository(db *pgxpool.Pool, log *slog.Logger) *Repository {
-	return &Repository{
-		db:  db,
-		log: log,
+var _ Repository = (*PostgresRepository)(nil)
+
+// NewRepository returns a Postgres repository that claims with the atomic
+// conditional-update strategy.
+func NewRepository(db *pgxpool.Pool, log *slog.Logger) *PostgresRepository {
+	return NewRepositoryWithStrategy(db, log, NewAtomicStrategy(log))
+}
+
+func NewRepositoryWithStrategy(db *pgxpool.Pool,
+	log *slog.Logger,
+	strategy ClaimStrategy,
+) *PostgresRepository {
+	return &PostgresRepository{
+		db:       db,
+		log:      log,
+		strategy: strategy,
 	}
 }
 
+// WithMetrics records transaction durations in m and returns r.
+func (r *PostgresRepository) WithMetrics(m *Metrics) *PostgresRepository {
+	r.metrics = m
+	return r
+}
+
+// The errors clients can see are defined in couponapi, with the codes they
+// are answered with.
 var (
-	ErrCouponAlreadyExists  = errors.New("coupon already exists")
-	ErrCouponNotFound       = errors.New("coupon not found")
-	ErrCouponAlreadyClaimed = errors.New("coupon already claimed")
-	ErrCouponOutOfStock     = errors.New("coupon out of stock")
+	ErrCouponAlreadyExists = couponapi.ErrCouponAlreadyExists
+	ErrCouponNotFound      = couponapi.ErrCouponNotFound
+	ErrClaimLimitReached   = couponapi.ErrClaimLimitReached
+	ErrCouponOutOfStock    = couponapi.ErrCouponOutOfStock
+	ErrCouponNotYetActive  = couponapi.ErrCouponNotYetActive
+	ErrCouponExpired       = couponapi.ErrCouponExpired
+	ErrClaimNotFound       = couponapi.ErrClaimNotFound
+	ErrClaimNotRedeemable  = couponapi.ErrClaimNotRedeemable
+	ErrClaimNotVoidable    = couponapi.ErrClaimNotVoidable
+	ErrClaimNotReleasable  = couponapi.ErrClaimNotReleasable
+	ErrVersionMismatch     = couponapi.ErrVersionMismatch
+	ErrAmountBelowClaimed  = couponapi.ErrAmountBelowClaimed
+	ErrInvalidCursor       = couponapi.ErrInvalidCursor
+	ErrInvalidStatusFilter = couponapi.ErrInvalidStatusFilter
+	ErrInvalidSort         = couponapi.ErrInvalidSort
+	ErrClaimContention     = couponapi.ErrClaimContention
+
+	ErrReservationNotFound   = couponapi.ErrReservationNotFound
+	ErrReservationNotActive  = couponapi.ErrReservationNotActive
+	ErrReservationExpired    = couponapi.ErrReservationExpired
+	ErrInvalidReservationTTL = couponapi.ErrInvalidReservationTTL
+
+	ErrCouponInStock         = couponapi.ErrCouponInStock
+	ErrAlreadyOnWaitlist     = couponapi.ErrAlreadyOnWaitlist
+	ErrWaitlistEntryNotFound = couponapi.ErrWaitlistEntryNotFound
+
+	ErrIdempotencyKeyReused     = couponapi.ErrIdempotencyKeyReused
+	ErrIdempotencyKeyInProgress = couponapi.ErrIdempotencyKeyInProgress
+
+	ErrEventNotFound = errors.New("event not found")
+
+	ErrInvalidValidityWindow = couponapi.ErrInvalidValidityWindow
+	ErrInvalidTenant         = couponapi.ErrInvalidTenant
+	ErrValidationFailed      = couponapi.ErrValidationFailed
+
+	ErrAdminRequired  = couponapi.ErrAdminRequired
+	ErrUserMismatch   = couponapi.ErrUserMismatch
+	ErrUserIDRequired = couponapi.ErrUserIDRequired
+	ErrTenantMismatch = couponapi.ErrTenantMismatch
 )
 
-func (r *Repository) CheckCouponExist(
+func (r *PostgresRepository) CheckCouponExist(
 	ctx context.Context,
 	couponName string,
 ) (bool, error) {
@@ -39,9 +157,9 @@ func (r *Repository) CheckCouponExist(
 	query := `
 		SELECT COUNT(1)
 		FROM coupons
-		WHERE name = $1`
+		WHERE tenant_id = $1 AND name = $2`
 
-	err := r.db.QueryRow(ctx, query, couponName).Scan(&count)
+	err := r.db.QueryRow(ctx, query, TenantFromContext(ctx), couponName).Scan(&count)
 	if err != nil {
 		r.log.Error("failed to check coupon existence", "coupon_name", couponName, "error", err)
 		return false, err
@@ -52,90 +170,191 @@ func (r *Repository) CheckCouponExist(
 	return exists, nil
 }
 
-func (r *Repository) InsertCoupon(
+func (r *PostgresRepository) InsertCoupon(
 	ctx context.Context,
 	coupon Coupons,
 ) error {
-	r.log.Info("inserting coupon", "coupon_name", coupon.Name, "amount", coupon.Amount)
+	r.log.Info("inserting coupon", "coupon_name", coupon.Name, "amount", coupon.Amount, "max_claims_per_user", coupon.MaxClaimsPerUser)
 	defer r.log.Info("finished inserting coupon", "coupon_name", coupon.Name)
 
+	tx, err := r.beginTx(ctx, "insert_coupon")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "coupon_name", coupon.Name, "error", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
 	query := `
-		INSERT INTO coupons (name, amount) 
-		VALUES ($1, $2)
+		INSERT INTO coupons (tenant_id, name, amount, max_claims_per_user, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
 	`
-	_, err := r.db.Exec(ctx, query, coupon.Name, coupon.Amount)
+	_, err = tx.Exec(ctx, query, TenantFromContext(ctx),
+		coupon.Name, coupon.Amount, coupon.MaxClaimsPerUser, coupon.StartsAt, coupon.EndsAt)
 	if err != nil {
+		// A concurrent create can pass the service's existence check too; the
+		// primary key decides which one wins.
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			r.log.Warn("coupon already exists", "coupon_name", coupon.Name)
+			return ErrCouponAlreadyExists
+		}
 		r.log.Error("failed to insert coupon", "coupon_name", coupon.Name, "error", err)
 		return err
 	}
 
+	err = r.insertEvent(ctx, tx, EventCouponCreated, coupon.Name, CouponCreatedData{
+		Amount:           coupon.Amount,
+		MaxClaimsPerUser: coupon.MaxClaimsPerUser,
+		StartsAt:         coupon.StartsAt,
+		EndsAt:           coupon.EndsAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.log.Error("failed to commit transaction", "coupon_name", coupon.Name, "error", err)
+		return err
+	}
+
 	r.log.Info("coupon inserted successfully", "coupon_name", coupon.Name)
 	return nil
 }
 
-func (r *Repository) ClaimCoupon(
+func (r *PostgresRepository) UpdateCoupon(
 	ctx context.Context,
-	req ClaimCouponRequest,
+	couponName string,
+	expectedVersion int64,
+	req UpdateCouponRequest,
 ) error {
-	r.log.Info("starting coupon claim", "coupon_name", req.CouponName, "user_id", req.UserId)
-	defer r.log.Info("finished coupon claim", "coupon_name", req.CouponName, "user_id", req.UserId)
+	r.log.Info("starting coupon update", "coupon_name", couponName, "expected_version", expectedVersion)
+	defer r.log.Info("finished coupon update", "coupon_name", couponName)
 
-	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	tx, err := r.beginTx(ctx, "update_coupon")
 	if err != nil {
-		r.log.Error("failed to begin transaction", "coupon_name", req.CouponName, "user_id", req.UserId, "error", err)
+		r.log.Error("failed to begin transaction", "coupon_name", couponName, "error", err)
 		return err
 	}
 	defer tx.Rollback(ctx)
 
-	var alreadyClaimed bool
+	// Lock the row like ClaimCoupon does so claimed and reserved stock cannot
+	// change until this update commits.
+	var current Coupons
+	var version int64
+	var claimed, reserved int
 	err = tx.QueryRow(ctx, `
-		SELECT EXISTS(
-			SELECT 1 
-			FROM claim_history 
-			WHERE coupon_name = $1 AND user_id = $2
-		)
-	`, req.CouponName, req.UserId).Scan(&alreadyClaimed)
+		SELECT amount, max_claims_per_user, starts_at, ends_at, version, claimed_count, reserved_count
+		FROM coupons
+		WHERE tenant_id = $1 AND name = $2
+		FOR UPDATE
+	`, TenantFromContext(ctx), couponName).Scan(
+		&current.Amount,
+		&current.MaxClaimsPerUser,
+		&current.StartsAt,
+		&current.EndsAt,
+		&version,
+		&claimed,
+		&reserved,
+	)
 	if err != nil {
-		r.log.Error("failed to check claim history", "coupon_name", req.CouponName, "user_id", req.UserId, "error", err)
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.log.Warn("coupon not found", "coupon_name", couponName)
+			return ErrCouponNotFound
+		}
+		r.log.Error("failed to lock coupon", "coupon_name", couponName, "error", err)
 		return err
 	}
-	if alreadyClaimed {
-		r.log.Warn("coupon already claimed", "coupon_name", req.CouponName, "user_id", req.UserId)
-		return ErrCouponAlreadyClaimed
+
+	if version != expectedVersion {
+		r.log.Warn("coupon version mismatch", "coupon_name", couponName, "expected_version", expectedVersion, "version", version)
+		return ErrVersionMismatch
 	}
 
-	var amount int
-	var used int
-	err = tx.QueryRow(ctx, `
-		SELECT
-			c.amount,
-			(SELECT COUNT(*) FROM claim_history ch WHERE ch.coupon_name = c.name)
-		FROM coupons c
-		WHERE c.name = $1
-		FOR UPDATE
-	`, req.CouponName).Scan(&amount, &used)
+	soldOut := current.Amount == claimed
+	if req.Amount != nil {
+		current.Amount = *req.Amount
+	}
+	if req.MaxClaimsPerUser != nil {
+		current.MaxClaimsPerUser = *req.MaxClaimsPerUser
+	}
+	if req.StartsAt != nil {
+		current.StartsAt = req.StartsAt
+	}
+	if req.EndsAt != nil {
+		current.EndsAt = req.EndsAt
+	}
 
+	if current.StartsAt != nil && current.EndsAt != nil &&
+		!current.EndsAt.After(*current.StartsAt) {
+		return ErrInvalidValidityWindow
+	}
+
+	if used := claimed + reserved; current.Amount < used {
+		r.log.Warn("amount below claimed", "coupon_name", couponName, "amount", current.Amount, "used", used)
+		return ErrAmountBelowClaimed
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE coupons
+		SET amount = $3,
+			max_claims_per_user = $4,
+			starts_at = $5,
+			ends_at = $6,
+			version = version + 1
+		WHERE tenant_id = $1 AND name = $2
+	`, TenantFromContext(ctx), couponName, current.Amount, current.MaxClaimsPerUser, current.StartsAt, current.EndsAt)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			r.log.Warn("coupon not found", "coupon_name", req.CouponName)
-			return ErrCouponNotFound
+		r.log.Error("failed to update coupon", "coupon_name", couponName, "error", err)
+		return err
+	}
+
+	// Lowering the amount to the claimed count sells the coupon out as surely
+	// as claiming the last unit does.
+	if !soldOut && current.Amount == claimed {
+		if err := r.insertEvent(ctx, tx, EventCouponSoldOut, couponName, CouponSoldOutData{Amount: current.Amount}); err != nil {
+			return err
 		}
-		r.log.Error("failed to check stock", "coupon_name", req.CouponName, "error", err)
+	}
+
+	// A higher amount or a reopened window can free stock for the waitlist.
+	if _, err := r.promoteWaitlist(ctx, tx, couponName); err != nil {
 		return err
 	}
 
-	if amount-used <= 0 {
-		r.log.Warn("coupon out of stock", "coupon_name", req.CouponName, "amount", amount, "used", used)
-		return ErrCouponOutOfStock
+	err = tx.Commit(ctx)
+	if err != nil {
+		r.log.Error("failed to commit transaction", "coupon_name", couponName, "error", err)
+		return err
 	}
 
-	_, err = tx.Exec(ctx, `
-		INSERT INTO claim_history (coupon_name, user_id)
-		VALUES ($1, $2)
-	`, req.CouponName, req.UserId)
+	r.log.Info("coupon updated successfully", "coupon_name", couponName, "version", version+1)
+	return nil
+}
+
+func (r *PostgresRepository) ClaimCoupon(
+	ctx context.Context,
+	req ClaimCouponRequest,
+) error {
+	r.log.Info("starting coupon claim", "coupon_name", req.CouponName, "user_id", req.UserId, "strategy", r.strategy.Name())
+	defer r.log.Info("finished coupon claim", "coupon_name", req.CouponName, "user_id", req.UserId)
+
+	tx, err := r.beginTx(ctx, "claim")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "coupon_name", req.CouponName, "user_id", req.UserId, "error", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
 
+	stock, err := r.strategy.ReserveStock(ctx, tx, req.CouponName)
 	if err != nil {
-		r.log.Error("failed to insert claim", "coupon_name", req.CouponName, "user_id", req.UserId, "error", err)
+		return err
+	}
+
+	if err := r.checkUserClaimLimit(ctx, tx, req.CouponName, req.UserId, stock.MaxClaimsPerUser); err != nil {
+		return err
+	}
+
+	if _, err := r.insertClaim(ctx, tx, req.CouponName, req.UserId, stock); err != nil {
 		return err
 	}
 
@@ -149,9 +368,72 @@ func (r *Repository) ClaimCoupon(
 	return nil
 }
 
-func (r *Repository) GetCouponDetails(
+// checkUserClaimLimit must run while the coupon row is locked: the count is a
+// separate statement, so it sees every claim and reservation committed by the
+// previous lock holder. Active reservations count towards the limit.
+func (r *PostgresRepository) checkUserClaimLimit(
+	ctx context.Context,
+	tx pgx.Tx,
+	couponName string,
+	userID string,
+	maxClaimsPerUser int,
+) error {
+	var userClaims int
+	err := tx.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*)
+			FROM claim_history
+			WHERE tenant_id = $1 AND coupon_name = $2 AND user_id = $3)
+			+
+			(SELECT COUNT(*)
+			FROM reservations
+			WHERE tenant_id = $1 AND coupon_name = $2 AND user_id = $3 AND status = 'active')
+	`, TenantFromContext(ctx), couponName, userID).Scan(&userClaims)
+	if err != nil {
+		r.log.Error("failed to check claim history", "coupon_name", couponName, "user_id", userID, "error", err)
+		return err
+	}
+	if userClaims >= maxClaimsPerUser {
+		r.log.Warn("per-user claim limit reached", "coupon_name", couponName, "user_id", userID, "limit", maxClaimsPerUser)
+		return ErrClaimLimitReached
+	}
+	return nil
+}
+
+// insertClaim records a claim whose unit is already counted in claimed_count,
+// and its events. stock is the coupon as the update that took the unit left
+// it. Every path that creates a claim goes through here, so none is missing
+// from the outbox.
+func (r *PostgresRepository) insertClaim(
 	ctx context.Context,
+	tx pgx.Tx,
 	couponName string,
+	userID string,
+	stock CouponStock,
+) (int64, error) {
+	var claimID int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO claim_history (tenant_id, coupon_name, user_id)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, TenantFromContext(ctx), couponName, userID).Scan(&claimID)
+	if err != nil {
+		r.log.Error("failed to insert claim", "coupon_name", couponName, "user_id", userID, "error", err)
+		return 0, err
+	}
+	if err := r.insertClaimEvents(ctx, tx, couponName, userID, claimID, stock); err != nil {
+		return 0, err
+	}
+	return claimID, nil
+}
+
+// GetCouponDetails returns the coupon with its claim count. At most
+// claimedByLimit user ids are included, in claim order; the full list is
+// served by ListCouponClaims.
+func (r *PostgresRepository) GetCouponDetails(
+	ctx context.Context,
+	couponName string,
+	claimedByLimit int,
 ) (*Details, error) {
 	r.log.Info("getting coupon details", "coupon_name", couponName)
 	defer r.log.Info("finished getting coupon details", "coupon_name", couponName)
@@ -160,27 +442,45 @@ func (r *Repository) GetCouponDetails(
 		SELECT
 			c.name,
 			c.amount,
-			c.amount - COUNT(ch.user_id) AS remaining_amount,
-			COALESCE(
-				ARRAY_AGG(ch.user_id) FILTER (WHERE ch.user_id IS NOT NULL),
-				'{}'::text[]
+			c.amount - c.claimed_count - c.reserved_count AS remaining_amount,
+			c.claimed_count,
+			c.reserved_count,
+			c.max_claims_per_user,
+			c.starts_at,
+			c.ends_at,
+			c.version,
+			c.created_at,
+			ARRAY(
+				SELECT ch.user_id
+				FROM claim_history ch
+				WHERE ch.tenant_id = c.tenant_id AND ch.coupon_name = c.name
+				ORDER BY ch.id
+				LIMIT $3
 			) AS claimed_by
 		FROM coupons c
-		LEFT JOIN claim_history ch
-			ON c.name = ch.coupon_name
-		WHERE c.name = $1
-		GROUP BY c.name, c.amount`
+		WHERE c.tenant_id = $1 AND c.name = $2`
 
 	var resp Details
 
-	err := r.db.QueryRow(ctx, query, couponName).Scan(
+	err := r.db.QueryRow(ctx, query, TenantFromContext(ctx), couponName, claimedByLimit).Scan(
 		&resp.Name,
 		&resp.Amount,
 		&resp.RemainingAmount,
+		&resp.ClaimedCount,
+		&resp.ReservedCount,
+		&resp.MaxClaimsPerUser,
+		&resp.StartsAt,
+		&resp.EndsAt,
+		&resp.Version,
+		&resp.CreatedAt,
 		&resp.ClaimedBy,
 	)
 
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.log.Warn("coupon not found", "coupon_name", couponName)
+			return nil, ErrCouponNotFound
+		}
 		r.log.Error("failed to get coupon details", "coupon_name", couponName, "error", err)
 		return nil, err
 	}
@@ -189,3 +489,620 @@ func (r *Repository) GetCouponDetails(
 	return &resp, nil
 
 }
+
+// ListCouponClaims returns up to limit claims on the coupon in claim order,
+// starting after the claim with id afterID.
+func (r *PostgresRepository) ListCouponClaims(
+	ctx context.Context,
+	couponName string,
+	afterID int64,
+	limit int,
+) ([]ClaimHistory, error) {
+	r.log.Info("listing coupon claims", "coupon_name", couponName, "after_id", afterID, "limit", limit)
+	defer r.log.Info("finished listing coupon claims", "coupon_name", couponName)
+
+	rows, err := r.db.Query(ctx, `
+		SELECT id, user_id, coupon_name, status, order_ref, claimed_at, updated_at
+		FROM claim_history
+		WHERE tenant_id = $1 AND coupon_name = $2 AND id > $3
+		ORDER BY id
+		LIMIT $4
+	`, TenantFromContext(ctx), couponName, afterID, limit)
+	if err != nil {
+		r.log.Error("failed to list coupon claims", "coupon_name", couponName, "error", err)
+		return nil, err
+	}
+
+	claims, err := pgx.CollectRows(rows, scanClaim)
+	if err != nil {
+		r.log.Error("failed to scan coupon claims", "coupon_name", couponName, "error", err)
+		return nil, err
+	}
+
+	r.log.Info("coupon claims listed", "coupon_name", couponName, "count", len(claims))
+	return claims, nil
+}
+
+func (r *PostgresRepository) RedeemClaim(
+	ctx context.Context,
+	req RedeemCouponRequest,
+) (*ClaimHistory, error) {
+	r.log.Info("starting claim redemption", "coupon_name", req.CouponName, "user_id", req.UserId, "order_ref", req.OrderRef)
+	defer r.log.Info("finished claim redemption", "coupon_name", req.CouponName, "user_id", req.UserId, "order_ref", req.OrderRef)
+
+	tx, err := r.beginTx(ctx, "redeem")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "coupon_name", req.CouponName, "user_id", req.UserId, "error", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	claims, err := r.lockUserClaims(ctx, tx, req.CouponName, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if len(claims) == 0 {
+		r.log.Warn("claim not found", "coupon_name", req.CouponName, "user_id", req.UserId)
+		return nil, ErrClaimNotFound
+	}
+
+	var target *ClaimHistory
+	for i := range claims {
+		claim := &claims[i]
+		if claim.OrderRef != nil && *claim.OrderRef == req.OrderRef {
+			if claim.Status == ClaimStatusRedeemed {
+				r.log.Info("claim already redeemed for order", "claim_id", claim.ID, "order_ref", req.OrderRef)
+				return claim, nil
+			}
+			r.log.Warn("claim for order is no longer redeemable", "claim_id", claim.ID, "status", claim.Status)
+			return nil, ErrClaimNotRedeemable
+		}
+		if target == nil && claim.Status == ClaimStatusClaimed {
+			target = claim
+		}
+	}
+	if target == nil {
+		r.log.Warn("no redeemable claim", "coupon_name", req.CouponName, "user_id", req.UserId)
+		return nil, ErrClaimNotRedeemable
+	}
+
+	var expired bool
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(ends_at <= now(), false)
+		FROM coupons
+		WHERE tenant_id = $1 AND name = $2
+	`, TenantFromContext(ctx), req.CouponName).Scan(&expired)
+	if err != nil {
+		r.log.Error("failed to check coupon expiry", "coupon_name", req.CouponName, "error", err)
+		return nil, err
+	}
+
+	if expired {
+		if err := r.setClaimStatus(ctx, tx, target, ClaimStatusExpired, nil); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			r.log.Error("failed to commit transaction", "claim_id", target.ID, "error", err)
+			return nil, err
+		}
+		r.log.Warn("claim expired before redemption", "claim_id", target.ID, "coupon_name", req.CouponName)
+		return nil, ErrCouponExpired
+	}
+
+	if err := r.setClaimStatus(ctx, tx, target, ClaimStatusRedeemed, &req.OrderRef); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.log.Error("failed to commit transaction", "claim_id", target.ID, "error", err)
+		return nil, err
+	}
+
+	r.log.Info("claim redeemed successfully", "claim_id", target.ID, "order_ref", req.OrderRef)
+	return target, nil
+}
+
+func (r *PostgresRepository) VoidClaim(
+	ctx context.Context,
+	req VoidCouponRequest,
+) (*ClaimHistory, error) {
+	r.log.Info("starting claim void", "coupon_name", req.CouponName, "user_id", req.UserId, "order_ref", req.OrderRef)
+	defer r.log.Info("finished claim void", "coupon_name", req.CouponName, "user_id", req.UserId, "order_ref", req.OrderRef)
+
+	tx, err := r.beginTx(ctx, "void")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "coupon_name", req.CouponName, "user_id", req.UserId, "error", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	claims, err := r.lockUserClaims(ctx, tx, req.CouponName, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *ClaimHistory
+	for i := range claims {
+		if claims[i].OrderRef != nil && *claims[i].OrderRef == req.OrderRef {
+			target = &claims[i]
+			break
+		}
+	}
+	if target == nil {
+		r.log.Warn("claim not found for order", "coupon_name", req.CouponName, "user_id", req.UserId, "order_ref", req.OrderRef)
+		return nil, ErrClaimNotFound
+	}
+
+	switch target.Status {
+	case ClaimStatusVoided:
+		r.log.Info("claim already voided for order", "claim_id", target.ID, "order_ref", req.OrderRef)
+		return target, nil
+	case ClaimStatusRedeemed:
+	default:
+		r.log.Warn("claim cannot be voided", "claim_id", target.ID, "status", target.Status)
+		return nil, ErrClaimNotVoidable
+	}
+
+	if err := r.setClaimStatus(ctx, tx, target, ClaimStatusVoided, target.OrderRef); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.log.Error("failed to commit transaction", "claim_id", target.ID, "error", err)
+		return nil, err
+	}
+
+	r.log.Info("claim voided successfully", "claim_id", target.ID, "order_ref", req.OrderRef)
+	return target, nil
+}
+
+// lockCoupon takes the coupon row lock. Every path that changes stock takes
+// it before touching claims or reservations, in the same order as
+// ClaimCoupon, so they are serialised per coupon and cannot deadlock.
+func (r *PostgresRepository) lockCoupon(
+	ctx context.Context,
+	tx pgx.Tx,
+	couponName string,
+) error {
+	var locked string
+	err := tx.QueryRow(ctx, `
+		SELECT name
+		FROM coupons
+		WHERE tenant_id = $1 AND name = $2
+		FOR UPDATE
+	`, TenantFromContext(ctx), couponName).Scan(&locked)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.log.Warn("coupon not found", "coupon_name", couponName)
+			return ErrCouponNotFound
+		}
+		r.log.Error("failed to lock coupon", "coupon_name", couponName, "error", err)
+		return err
+	}
+	return nil
+}
+
+// lockUserClaims locks every claim the user holds on the coupon, oldest first.
+// Locking the whole set serialises concurrent status changes for the same
+// user, so two orders can never pick the same claim.
+func (r *PostgresRepository) lockUserClaims(
+	ctx context.Context,
+	tx pgx.Tx,
+	couponName string,
+	userID string,
+) ([]ClaimHistory, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, user_id, coupon_name, status, order_ref, claimed_at, updated_at
+		FROM claim_history
+		WHERE tenant_id = $1 AND coupon_name = $2 AND user_id = $3
+		ORDER BY id
+		FOR UPDATE
+	`, TenantFromContext(ctx), couponName, userID)
+	if err != nil {
+		r.log.Error("failed to lock claims", "coupon_name", couponName, "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	claims, err := pgx.CollectRows(rows, scanClaim)
+	if err != nil {
+		r.log.Error("failed to scan claims", "coupon_name", couponName, "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (r *PostgresRepository) setClaimStatus(
+	ctx context.Context,
+	tx pgx.Tx,
+	claim *ClaimHistory,
+	status ClaimStatus,
+	orderRef *string,
+) error {
+	err := tx.QueryRow(ctx, `
+		UPDATE claim_history
+		SET status = $2, order_ref = $3, updated_at = now()
+		WHERE id = $1
+		RETURNING updated_at
+	`, claim.ID, status, orderRef).Scan(&claim.UpdatedAt)
+	if err != nil {
+		r.log.Error("failed to update claim status", "claim_id", claim.ID, "status", status, "error", err)
+		return err
+	}
+
+	claim.Status = status
+	claim.OrderRef = orderRef
+	return nil
+}
+
+func scanClaim(row pgx.CollectableRow) (ClaimHistory, error) {
+	var claim ClaimHistory
+	err := row.Scan(
+		&claim.ID,
+		&claim.UserID,
+		&claim.CouponName,
+		&claim.Status,
+		&claim.OrderRef,
+		&claim.ClaimedAt,
+		&claim.UpdatedAt,
+	)
+	return claim, err
+}
+
+func (r *PostgresRepository) ReleaseClaim(
+	ctx context.Context,
+	couponName string,
+	userID string,
+) error {
+	r.log.Info("starting claim release", "coupon_name", couponName, "user_id", userID)
+	defer r.log.Info("finished claim release", "coupon_name", couponName, "user_id", userID)
+
+	tx, err := r.beginTx(ctx, "release")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "coupon_name", couponName, "user_id", userID, "error", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := r.lockCoupon(ctx, tx, couponName); err != nil {
+		return err
+	}
+
+	claims, err := r.lockUserClaims(ctx, tx, couponName, userID)
+	if err != nil {
+		return err
+	}
+	if len(claims) == 0 {
+		r.log.Warn("claim not found", "coupon_name", couponName, "user_id", userID)
+		return ErrClaimNotFound
+	}
+
+	var target *ClaimHistory
+	for i := len(claims) - 1; i >= 0; i-- {
+		if claims[i].Status == ClaimStatusClaimed {
+			target = &claims[i]
+			break
+		}
+	}
+	if target == nil {
+		r.log.Warn("no releasable claim", "coupon_name", couponName, "user_id", userID)
+		return ErrClaimNotReleasable
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM claim_history
+		WHERE id = $1
+	`, target.ID)
+	if err != nil {
+		r.log.Error("failed to delete claim", "claim_id", target.ID, "error", err)
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE coupons
+		SET claimed_count = claimed_count - 1
+		WHERE tenant_id = $1 AND name = $2
+	`, TenantFromContext(ctx), couponName)
+	if err != nil {
+		r.log.Error("failed to return stock", "coupon_name", couponName, "error", err)
+		return err
+	}
+
+	if _, err := r.promoteWaitlist(ctx, tx, couponName); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		r.log.Error("failed to commit transaction", "claim_id", target.ID, "error", err)
+		return err
+	}
+
+	r.log.Info("claim released successfully", "claim_id", target.ID, "coupon_name", couponName, "user_id", userID)
+	return nil
+}
+
+// ListCoupons returns up to filter.Limit coupons newest first, starting after
+// filter.After. Paging is keyset-based on (created_at, name), so coupons
+// inserted while a client pages never shift the rows it has yet to see.
+func (r *PostgresRepository) ListCoupons(
+	ctx context.Context,
+	filter CouponFilter,
+) ([]Details, error) {
+	r.log.Info("listing coupons", "name_prefix", filter.NamePrefix, "status", filter.Status, "limit", filter.Limit)
+	defer r.log.Info("finished listing coupons")
+
+	var conditions []string
+	var args []any
+	addCondition := func(format string, values ...any) {
+		placeholders := make([]any, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+	}
+
+	addCondition("tenant_id = %s", TenantFromContext(ctx))
+	if filter.NamePrefix != "" {
+		addCondition("starts_with(name, %s)", filter.NamePrefix)
+	}
+	if filter.CreatedAfter != nil {
+		addCondition("created_at >= %s", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		addCondition("created_at < %s", *filter.CreatedBefore)
+	}
+	if filter.After != nil {
+		addCondition("(created_at, name) < (%s, %s)", filter.After.CreatedAt, filter.After.Name)
+	}
+
+	switch filter.Status {
+	case "":
+	case CouponStatusActive:
+		conditions = append(conditions,
+			"COALESCE(starts_at <= now(), true)",
+			"COALESCE(ends_at > now(), true)",
+			"remaining_amount > 0")
+	case CouponStatusExpired:
+		conditions = append(conditions, "ends_at <= now()")
+	case CouponStatusSoldOut:
+		conditions = append(conditions, "remaining_amount <= 0")
+	default:
+		return nil, ErrInvalidStatusFilter
+	}
+
+	where := "WHERE " + strings.Join(conditions, " AND ")
+
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf(`
+		SELECT name, amount, remaining_amount, max_claims_per_user,
+			starts_at, ends_at, version, created_at
+		FROM (
+			SELECT c.*, c.amount - c.claimed_count - c.reserved_count AS remaining_amount
+			FROM coupons c
+		) c
+		%s
+		ORDER BY created_at DESC, name DESC
+		LIMIT $%d`, where, len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		r.log.Error("failed to list coupons", "error", err)
+		return nil, err
+	}
+
+	coupons, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Details, error) {
+		var d Details
+		err := row.Scan(
+			&d.Name,
+			&d.Amount,
+			&d.RemainingAmount,
+			&d.MaxClaimsPerUser,
+			&d.StartsAt,
+			&d.EndsAt,
+			&d.Version,
+			&d.CreatedAt,
+		)
+		return d, err
+	})
+	if err != nil {
+		r.log.Error("failed to scan coupons", "error", err)
+		return nil, err
+	}
+
+	r.log.Info("coupons listed", "count", len(coupons))
+	return coupons, nil
+}
+
+// claimSortColumns maps each supported sort to its column and the type its
+// cursor value is cast to.
+var claimSortColumns = map[ClaimSort]struct {
+	column string
+	cast   string
+}{
+	ClaimSortClaimedAt:  {column: "ch.claimed_at", cast: "timestamptz"},
+	ClaimSortUpdatedAt:  {column: "ch.updated_at", cast: "timestamptz"},
+	ClaimSortCouponName: {column: "ch.coupon_name", cast: "text"},
+}
+
+// ListUserClaims returns up to filter.Limit of the user's claims, each joined
+// with the current state of its coupon, keyset-paginated on the sort column
+// with the claim id as tie-breaker.
+func (r *PostgresRepository) ListUserClaims(
+	ctx context.Context,
+	filter UserClaimFilter,
+) ([]UserClaim, error) {
+	r.log.Info("listing user claims", "user_id", filter.UserID, "sort", filter.Sort, "desc", filter.Desc, "limit", filter.Limit)
+	defer r.log.Info("finished listing user claims", "user_id", filter.UserID)
+
+	sort, ok := claimSortColumns[filter.Sort]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+
+	direction, comparison := "ASC", ">"
+	if filter.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	args := []any{TenantFromContext(ctx), filter.UserID}
+	conditions := []string{"ch.tenant_id = $1", "ch.user_id = $2"}
+
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("ch.status = $%d", len(args)))
+	}
+	if filter.After != nil {
+		args = append(args, filter.After.Value, filter.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, ch.id) %s ($%d::%s, $%d)",
+			sort.column, comparison, len(args)-1, sort.cast, len(args)))
+	}
+
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf(`
+		SELECT
+			ch.id, ch.user_id, ch.coupon_name, ch.status, ch.order_ref,
+			ch.claimed_at, ch.updated_at,
+			c.name, c.amount,
+			c.amount - c.claimed_count - c.reserved_count AS remaining_amount,
+			c.max_claims_per_user, c.starts_at, c.ends_at, c.version, c.created_at
+		FROM claim_history ch
+		JOIN coupons c ON c.tenant_id = ch.tenant_id AND c.name = ch.coupon_name
+		WHERE %s
+		ORDER BY %s %s, ch.id %s
+		LIMIT $%d`,
+		strings.Join(conditions, " AND "), sort.column, direction, direction, len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		r.log.Error("failed to list user claims", "user_id", filter.UserID, "error", err)
+		return nil, err
+	}
+
+	claims, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (UserClaim, error) {
+		var uc UserClaim
+		err := row.Scan(
+			&uc.Claim.ID,
+			&uc.Claim.UserID,
+			&uc.Claim.CouponName,
+			&uc.Claim.Status,
+			&uc.Claim.OrderRef,
+			&uc.Claim.ClaimedAt,
+			&uc.Claim.UpdatedAt,
+			&uc.Coupon.Name,
+			&uc.Coupon.Amount,
+			&uc.Coupon.RemainingAmount,
+			&uc.Coupon.MaxClaimsPerUser,
+			&uc.Coupon.StartsAt,
+			&uc.Coupon.EndsAt,
+			&uc.Coupon.Version,
+			&uc.Coupon.CreatedAt,
+		)
+		return uc, err
+	})
+	if err != nil {
+		r.log.Error("failed to scan user claims", "user_id", filter.UserID, "error", err)
+		return nil, err
+	}
+
+	r.log.Info("user claims listed", "user_id", filter.UserID, "count", len(claims))
+	return claims, nil
+}
+
+// VerifyClaimedCounts compares every coupon's stored claimed_count with its
+// rows in claim_history, across all tenants. With fix set, each mismatch is corrected under the
+// same row lock claims take, so it is safe to run against live traffic.
+func (r *PostgresRepository) VerifyClaimedCounts(
+	ctx context.Context,
+	fix bool,
+) ([]ClaimCountMismatch, error) {
+	r.log.Info("verifying claimed counts", "fix", fix)
+	defer r.log.Info("finished verifying claimed counts")
+
+	rows, err := r.db.Query(ctx, `
+		SELECT c.tenant_id, c.name, c.claimed_count, COUNT(ch.id)
+		FROM coupons c
+		LEFT JOIN claim_history ch ON ch.tenant_id = c.tenant_id AND ch.coupon_name = c.name
+		GROUP BY c.tenant_id, c.name, c.claimed_count
+		HAVING c.claimed_count <> COUNT(ch.id)
+		ORDER BY c.tenant_id, c.name
+	`)
+	if err != nil {
+		r.log.Error("failed to compare claimed counts", "error", err)
+		return nil, err
+	}
+
+	mismatches, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ClaimCountMismatch, error) {
+		var m ClaimCountMismatch
+		err := row.Scan(&m.TenantID, &m.CouponName, &m.Stored, &m.Actual)
+		return m, err
+	})
+	if err != nil {
+		r.log.Error("failed to scan claimed counts", "error", err)
+		return nil, err
+	}
+
+	if !fix {
+		return mismatches, nil
+	}
+
+	for i := range mismatches {
+		if err := r.fixClaimedCount(ctx, &mismatches[i]); err != nil {
+			return mismatches, err
+		}
+	}
+
+	return mismatches, nil
+}
+
+func (r *PostgresRepository) fixClaimedCount(
+	ctx context.Context,
+	mismatch *ClaimCountMismatch,
+) error {
+	tx, err := r.beginTx(ctx, "fix_claimed_count")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "coupon_name", mismatch.CouponName, "error", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		SELECT claimed_count
+		FROM coupons
+		WHERE tenant_id = $1 AND name = $2
+		FOR UPDATE
+	`, mismatch.TenantID, mismatch.CouponName).Scan(&mismatch.Stored)
+	if err != nil {
+		r.log.Error("failed to lock coupon", "tenant_id", mismatch.TenantID, "coupon_name", mismatch.CouponName, "error", err)
+		return err
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM claim_history
+		WHERE tenant_id = $1 AND coupon_name = $2
+	`, mismatch.TenantID, mismatch.CouponName).Scan(&mismatch.Actual)
+	if err != nil {
+		r.log.Error("failed to count claims", "coupon_name", mismatch.CouponName, "error", err)
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE coupons
+		SET claimed_count = $3
+		WHERE tenant_id = $1 AND name = $2
+	`, mismatch.TenantID, mismatch.CouponName, mismatch.Actual)
+	if err != nil {
+		r.log.Error("failed to fix claimed count", "coupon_name", mismatch.CouponName, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.log.Error("failed to commit transaction", "coupon_name", mismatch.CouponName, "error", err)
+		return err
+	}
+
+	r.log.Info("claimed count fixed", "tenant_id", mismatch.TenantID, "coupon_name", mismatch.CouponName, "stored", mismatch.Stored, "actual", mismatch.Actual)
+	return nil
+}
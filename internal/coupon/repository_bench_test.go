@@ -0,0 +1,139 @@
+package coupon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// legacyClaimCoupon is the claim path from before claimed_count existed: it
+// locks the coupon row and counts claim_history on every claim. It is kept
+// here only as the baseline for BenchmarkClaimCoupon.
+func legacyClaimCoupon(ctx context.Context, db *pgxpool.Pool, req ClaimCouponRequest) error {
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var amount, maxClaimsPerUser int
+	err = tx.QueryRow(ctx, `
+		SELECT amount, max_claims_per_user
+		FROM coupons
+		WHERE name = $1
+		FOR UPDATE
+	`, req.CouponName).Scan(&amount, &maxClaimsPerUser)
+	if err != nil {
+		return err
+	}
+
+	var userClaims int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM claim_history
+		WHERE coupon_name = $1 AND user_id = $2
+	`, req.CouponName, req.UserId).Scan(&userClaims)
+	if err != nil {
+		return err
+	}
+	if userClaims >= maxClaimsPerUser {
+		return ErrClaimLimitReached
+	}
+
+	var used int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM claim_history
+		WHERE coupon_name = $1
+	`, req.CouponName).Scan(&used)
+	if err != nil {
+		return err
+	}
+	if amount-used <= 0 {
+		return ErrCouponOutOfStock
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO claim_history (coupon_name, user_id)
+		VALUES ($1, $2)
+	`, req.CouponName, req.UserId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// seedClaims creates a coupon that already carries existing claims, with
+// claimed_count kept in step.
+func seedClaims(b *testing.B, db *pgxpool.Pool, couponName string, existing int) {
+	ctx := context.Background()
+
+	_, err := db.Exec(ctx, `
+		INSERT INTO coupons (name, amount, claimed_count)
+		VALUES ($1, 100000000, $2)
+	`, couponName, existing)
+	if err != nil {
+		b.Fatalf("Failed to create coupon: %v", err)
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO claim_history (coupon_name, user_id)
+		SELECT $1, 'seed_' || g
+		FROM generate_series(1, $2::int) g
+	`, couponName, existing)
+	if err != nil {
+		b.Fatalf("Failed to seed claims: %v", err)
+	}
+
+	_, err = db.Exec(ctx, `ANALYZE claim_history`)
+	if err != nil {
+		b.Fatalf("Failed to analyze claim_history: %v", err)
+	}
+}
+
+func BenchmarkClaimCoupon(b *testing.B) {
+	db := setupTestDB(b)
+	defer db.Close()
+	defer cleanupTestDB(b, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+
+	ctx := context.Background()
+
+	claimers := map[string]func(ClaimCouponRequest) error{
+		"count": func(req ClaimCouponRequest) error {
+			return legacyClaimCoupon(ctx, db, req)
+		},
+		"counter": func(req ClaimCouponRequest) error {
+			return repo.ClaimCoupon(ctx, req)
+		},
+	}
+
+	for _, existing := range []int{10_000, 100_000} {
+		for _, name := range []string{"count", "counter"} {
+			claim := claimers[name]
+			b.Run(fmt.Sprintf("%s/existing=%d", name, existing), func(b *testing.B) {
+				couponName := fmt.Sprintf("BENCH_%s_%d", name, existing)
+				seedClaims(b, db, couponName, existing)
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					err := claim(ClaimCouponRequest{
+						UserId:     fmt.Sprintf("bench_%d", i),
+						CouponName: couponName,
+					})
+					if err != nil {
+						b.Fatalf("Claim failed: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package coupon
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"scalable-coupon-system/pkg/couponapi"
+)
+
+const (
+	RequestIDHeader       = couponapi.RequestIDHeader
+	maxRequestIDLength    = 128
+	generatedRequestIDLen = 16
+)
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request's ID, or "" outside a request.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// validRequestID accepts up to 128 printable ASCII characters, so a
+// caller-supplied ID is safe to echo back and to log.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if c := requestID[i]; c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID tags each request with the caller's X-Request-ID, or a fresh
+// random one, and echoes it in the response so errors can be traced in logs.
+func (h *Handler) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), requestID)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, generatedRequestIDLen)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
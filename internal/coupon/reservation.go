@@ -0,0 +1,471 @@
+package coupon
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	DefaultReservationTTL = 10 * time.Minute
+	MaxReservationTTL     = time.Hour
+)
+
+// CreateReservation holds one unit of stock for the user until ttl passes.
+// The hold counts against both the coupon's stock and the user's claim limit
+// for as long as it is active.
+func (r *PostgresRepository) CreateReservation(
+	ctx context.Context,
+	couponName string,
+	userID string,
+	ttl time.Duration,
+) (*Reservation, error) {
+	r.log.Info("starting reservation", "coupon_name", couponName, "user_id", userID, "ttl", ttl)
+	defer r.log.Info("finished reservation", "coupon_name", couponName, "user_id", userID)
+
+	tx, err := r.beginTx(ctx, "create_reservation")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "coupon_name", couponName, "user_id", userID, "error", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var maxClaimsPerUser int
+	err = tx.QueryRow(ctx, `
+		UPDATE coupons
+		SET reserved_count = reserved_count + 1
+		WHERE tenant_id = $1 AND name = $2
+			AND claimed_count + reserved_count < amount
+			AND COALESCE(starts_at <= now(), true)
+			AND COALESCE(ends_at > now(), true)
+		RETURNING max_claims_per_user
+	`, TenantFromContext(ctx), couponName).Scan(&maxClaimsPerUser)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, claimRejection(ctx, tx, r.log, couponName)
+		}
+		r.log.Error("failed to reserve stock", "coupon_name", couponName, "error", err)
+		return nil, err
+	}
+
+	if err := r.checkUserClaimLimit(ctx, tx, couponName, userID, maxClaimsPerUser); err != nil {
+		return nil, err
+	}
+
+	reservation, err := scanReservation(tx.QueryRow(ctx, `
+		INSERT INTO reservations (tenant_id, coupon_name, user_id, expires_at)
+		VALUES ($1, $2, $3, now() + $4::interval)
+		RETURNING id::text, coupon_name, user_id, status, claim_id, expires_at, created_at, updated_at
+	`, TenantFromContext(ctx), couponName, userID, ttl))
+	if err != nil {
+		r.log.Error("failed to insert reservation", "coupon_name", couponName, "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		r.log.Error("failed to commit transaction", "coupon_name", couponName, "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	r.log.Info("reservation created successfully", "reservation_id", reservation.ID, "coupon_name", couponName, "user_id", userID)
+	return &reservation, nil
+}
+
+func (r *PostgresRepository) GetReservation(
+	ctx context.Context,
+	id string,
+) (*Reservation, error) {
+	if !isUUID(id) {
+		return nil, ErrReservationNotFound
+	}
+
+	reservation, err := scanReservation(r.db.QueryRow(ctx, `
+		SELECT id::text, coupon_name, user_id, status, claim_id, expires_at, created_at, updated_at
+		FROM reservations
+		WHERE tenant_id = $1 AND id = $2::uuid
+	`, TenantFromContext(ctx), id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.log.Warn("reservation not found", "reservation_id", id)
+			return nil, ErrReservationNotFound
+		}
+		r.log.Error("failed to get reservation", "reservation_id", id, "error", err)
+		return nil, err
+	}
+
+	return &reservation, nil
+}
+
+// ConfirmReservation turns an active hold into a claim. The unit of stock
+// moves from reserved_count to claimed_count, so it is never free in between.
+// Confirming an already confirmed reservation returns it unchanged.
+func (r *PostgresRepository) ConfirmReservation(
+	ctx context.Context,
+	id string,
+) (*Reservation, error) {
+	r.log.Info("starting reservation confirm", "reservation_id", id)
+	defer r.log.Info("finished reservation confirm", "reservation_id", id)
+
+	tx, err := r.beginTx(ctx, "confirm_reservation")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "reservation_id", id, "error", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	reservation, expired, err := r.lockReservation(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch reservation.Status {
+	case ReservationStatusConfirmed:
+		r.log.Info("reservation already confirmed", "reservation_id", id, "claim_id", reservation.ClaimID)
+		return reservation, nil
+	case ReservationStatusExpired:
+		r.log.Warn("reservation expired", "reservation_id", id)
+		return nil, ErrReservationExpired
+	case ReservationStatusCancelled:
+		r.log.Warn("reservation is no longer active", "reservation_id", id, "status", reservation.Status)
+		return nil, ErrReservationNotActive
+	}
+
+	var couponExpired bool
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(ends_at <= now(), false)
+		FROM coupons
+		WHERE tenant_id = $1 AND name = $2
+	`, TenantFromContext(ctx), reservation.CouponName).Scan(&couponExpired)
+	if err != nil {
+		r.log.Error("failed to check coupon expiry", "coupon_name", reservation.CouponName, "error", err)
+		return nil, err
+	}
+
+	if expired || couponExpired {
+		if err := r.releaseReservation(ctx, tx, reservation, ReservationStatusExpired); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			r.log.Error("failed to commit transaction", "reservation_id", id, "error", err)
+			return nil, err
+		}
+		if couponExpired {
+			r.log.Warn("coupon expired before reservation was confirmed", "reservation_id", id, "coupon_name", reservation.CouponName)
+			return nil, ErrCouponExpired
+		}
+		r.log.Warn("reservation expired", "reservation_id", id)
+		return nil, ErrReservationExpired
+	}
+
+	var stock CouponStock
+	err = tx.QueryRow(ctx, `
+		UPDATE coupons
+		SET reserved_count = reserved_count - 1, claimed_count = claimed_count + 1
+		WHERE tenant_id = $1 AND name = $2
+		RETURNING amount, claimed_count, reserved_count, max_claims_per_user
+	`, TenantFromContext(ctx), reservation.CouponName).Scan(&stock.Amount, &stock.Claimed, &stock.Reserved, &stock.MaxClaimsPerUser)
+	if err != nil {
+		r.log.Error("failed to move reserved stock to claimed", "coupon_name", reservation.CouponName, "error", err)
+		return nil, err
+	}
+
+	claimID, err := r.insertClaim(ctx, tx, reservation.CouponName, reservation.UserID, stock)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE reservations
+		SET status = $2, claim_id = $3, updated_at = now()
+		WHERE id = $1::uuid
+		RETURNING updated_at
+	`, id, ReservationStatusConfirmed, claimID).Scan(&reservation.UpdatedAt)
+	if err != nil {
+		r.log.Error("failed to confirm reservation", "reservation_id", id, "error", err)
+		return nil, err
+	}
+	reservation.Status = ReservationStatusConfirmed
+	reservation.ClaimID = &claimID
+
+	if err := tx.Commit(ctx); err != nil {
+		r.log.Error("failed to commit transaction", "reservation_id", id, "error", err)
+		return nil, err
+	}
+
+	r.log.Info("reservation confirmed successfully", "reservation_id", id, "claim_id", claimID)
+	return reservation, nil
+}
+
+// CancelReservation returns an active hold to stock. Cancelling an already
+// cancelled reservation returns it unchanged.
+func (r *PostgresRepository) CancelReservation(
+	ctx context.Context,
+	id string,
+) (*Reservation, error) {
+	r.log.Info("starting reservation cancel", "reservation_id", id)
+	defer r.log.Info("finished reservation cancel", "reservation_id", id)
+
+	tx, err := r.beginTx(ctx, "cancel_reservation")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "reservation_id", id, "error", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	reservation, _, err := r.lockReservation(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch reservation.Status {
+	case ReservationStatusCancelled:
+		r.log.Info("reservation already cancelled", "reservation_id", id)
+		return reservation, nil
+	case ReservationStatusConfirmed, ReservationStatusExpired:
+		r.log.Warn("reservation is no longer active", "reservation_id", id, "status", reservation.Status)
+		return nil, ErrReservationNotActive
+	}
+
+	if err := r.releaseReservation(ctx, tx, reservation, ReservationStatusCancelled); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.log.Error("failed to commit transaction", "reservation_id", id, "error", err)
+		return nil, err
+	}
+
+	r.log.Info("reservation cancelled successfully", "reservation_id", id, "coupon_name", reservation.CouponName)
+	return reservation, nil
+}
+
+// ExpireReservations returns the stock of holds whose TTL has passed, across
+// all tenants. Each coupon is handled in its own transaction under the coupon
+// row lock, so a sweep never races a confirm or cancel of the same hold. At
+// most maxCoupons coupons are swept per call.
+func (r *PostgresRepository) ExpireReservations(
+	ctx context.Context,
+	maxCoupons int,
+) (int64, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT DISTINCT tenant_id, coupon_name
+		FROM reservations
+		WHERE status = 'active' AND expires_at <= now()
+		LIMIT $1
+	`, maxCoupons)
+	if err != nil {
+		r.log.Error("failed to find expired reservations", "error", err)
+		return 0, err
+	}
+	type tenantCoupon struct {
+		TenantID   string
+		CouponName string
+	}
+	coupons, err := pgx.CollectRows(rows, pgx.RowToStructByPos[tenantCoupon])
+	if err != nil {
+		r.log.Error("failed to scan expired reservations", "error", err)
+		return 0, err
+	}
+
+	var total int64
+	for _, c := range coupons {
+		expired, err := r.expireCouponReservations(WithTenant(ctx, c.TenantID), c.CouponName)
+		if err != nil {
+			return total, err
+		}
+		total += expired
+	}
+
+	return total, nil
+}
+
+func (r *PostgresRepository) expireCouponReservations(
+	ctx context.Context,
+	couponName string,
+) (int64, error) {
+	tx, err := r.beginTx(ctx, "expire_reservations")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "coupon_name", couponName, "error", err)
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := r.lockCoupon(ctx, tx, couponName); err != nil {
+		return 0, err
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE reservations
+		SET status = 'expired', updated_at = now()
+		WHERE tenant_id = $1 AND coupon_name = $2 AND status = 'active' AND expires_at <= now()
+	`, TenantFromContext(ctx), couponName)
+	if err != nil {
+		r.log.Error("failed to expire reservations", "coupon_name", couponName, "error", err)
+		return 0, err
+	}
+	expired := tag.RowsAffected()
+	if expired == 0 {
+		return 0, nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE coupons
+		SET reserved_count = reserved_count - $3
+		WHERE tenant_id = $1 AND name = $2
+	`, TenantFromContext(ctx), couponName, expired)
+	if err != nil {
+		r.log.Error("failed to return reserved stock", "coupon_name", couponName, "error", err)
+		return 0, err
+	}
+
+	if _, err := r.promoteWaitlist(ctx, tx, couponName); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.log.Error("failed to commit transaction", "coupon_name", couponName, "error", err)
+		return 0, err
+	}
+
+	r.log.Info("reservations expired", "coupon_name", couponName, "count", expired)
+	return expired, nil
+}
+
+// lockReservation locks the reservation's coupon and then the reservation
+// itself, in the same order as every other stock change. expired reports
+// whether an active reservation's TTL has passed.
+func (r *PostgresRepository) lockReservation(
+	ctx context.Context,
+	tx pgx.Tx,
+	id string,
+) (*Reservation, bool, error) {
+	if !isUUID(id) {
+		r.log.Warn("reservation not found", "reservation_id", id)
+		return nil, false, ErrReservationNotFound
+	}
+
+	// coupon_name never changes, so it is safe to read before the lock.
+	var couponName string
+	err := tx.QueryRow(ctx, `
+		SELECT coupon_name
+		FROM reservations
+		WHERE tenant_id = $1 AND id = $2::uuid
+	`, TenantFromContext(ctx), id).Scan(&couponName)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.log.Warn("reservation not found", "reservation_id", id)
+			return nil, false, ErrReservationNotFound
+		}
+		r.log.Error("failed to find reservation", "reservation_id", id, "error", err)
+		return nil, false, err
+	}
+
+	if err := r.lockCoupon(ctx, tx, couponName); err != nil {
+		return nil, false, err
+	}
+
+	var reservation Reservation
+	var expired bool
+	err = tx.QueryRow(ctx, `
+		SELECT id::text, coupon_name, user_id, status, claim_id, expires_at, created_at, updated_at,
+			expires_at <= now()
+		FROM reservations
+		WHERE tenant_id = $1 AND id = $2::uuid
+		FOR UPDATE
+	`, TenantFromContext(ctx), id).Scan(
+		&reservation.ID,
+		&reservation.CouponName,
+		&reservation.UserID,
+		&reservation.Status,
+		&reservation.ClaimID,
+		&reservation.ExpiresAt,
+		&reservation.CreatedAt,
+		&reservation.UpdatedAt,
+		&expired,
+	)
+	if err != nil {
+		r.log.Error("failed to lock reservation", "reservation_id", id, "error", err)
+		return nil, false, err
+	}
+
+	return &reservation, expired, nil
+}
+
+// releaseReservation ends an active hold with status and returns its unit of
+// stock, promoting the waitlist if anyone is queued. The coupon row must
+// already be locked.
+func (r *PostgresRepository) releaseReservation(
+	ctx context.Context,
+	tx pgx.Tx,
+	reservation *Reservation,
+	status ReservationStatus,
+) error {
+	err := tx.QueryRow(ctx, `
+		UPDATE reservations
+		SET status = $2, updated_at = now()
+		WHERE id = $1::uuid
+		RETURNING updated_at
+	`, reservation.ID, status).Scan(&reservation.UpdatedAt)
+	if err != nil {
+		r.log.Error("failed to update reservation status", "reservation_id", reservation.ID, "status", status, "error", err)
+		return err
+	}
+	reservation.Status = status
+
+	_, err = tx.Exec(ctx, `
+		UPDATE coupons
+		SET reserved_count = reserved_count - 1
+		WHERE tenant_id = $1 AND name = $2
+	`, TenantFromContext(ctx), reservation.CouponName)
+	if err != nil {
+		r.log.Error("failed to return reserved stock", "coupon_name", reservation.CouponName, "error", err)
+		return err
+	}
+
+	if _, err := r.promoteWaitlist(ctx, tx, reservation.CouponName); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func scanReservation(row pgx.Row) (Reservation, error) {
+	var reservation Reservation
+	err := row.Scan(
+		&reservation.ID,
+		&reservation.CouponName,
+		&reservation.UserID,
+		&reservation.Status,
+		&reservation.ClaimID,
+		&reservation.ExpiresAt,
+		&reservation.CreatedAt,
+		&reservation.UpdatedAt,
+	)
+	return reservation, err
+}
+
+// isUUID reports whether id has the canonical 8-4-4-4-12 hex form. Ids that do
+// not are treated as unknown rather than sent to Postgres, which would reject
+// the cast with an error.
+func isUUID(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -1,8 +0,0 @@
-package coupon
-
-type GetCouponDetailsResponse struct {
-	Name            string   `json:"name"`
-	Amount          int      `json:"amount"`
-	RemainingAmount int      `json:"remaining_amount"`
-	ClaimedBy       []string `json:"claimed_by"`
-}
@@ -1,13 +1,35 @@
 package coupon
 
-import "net/http"
+import (
+	"net/http"
 
+	"scalable-coupon-system/internal/metrics"
+)
+
+// Routes registers the API. Managing coupons, releasing claims and reading
+// other users' claims needs an admin; everything else needs an authenticated
+// user, who may only act on their own claims, reservations and waitlist
+// entries.
 func (h *Handler) Routes() http.Handler {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /api/coupons", h.CreateCoupon)
-	mux.HandleFunc("POST /api/coupons/claim", h.ClaimCoupon)
-	mux.HandleFunc("GET /api/coupons/{name}", h.GetCouponDetails)
+	mux.Handle("POST /api/coupons", h.requireAdmin(h.CreateCoupon))
+	mux.Handle("GET /api/coupons", h.requireAdmin(h.ListCoupons))
+	mux.Handle("POST /api/coupons/claim", h.requireUser(h.ClaimCoupon))
+	mux.Handle("POST /api/coupons/redeem", h.requireUser(h.RedeemCoupon))
+	mux.Handle("POST /api/coupons/void", h.requireUser(h.VoidCoupon))
+	mux.Handle("GET /api/coupons/{name}", h.requireUser(h.GetCouponDetails))
+	mux.Handle("PATCH /api/coupons/{name}", h.requireAdmin(h.UpdateCoupon))
+	mux.Handle("GET /api/coupons/{name}/claims", h.requireAdmin(h.ListCouponClaims))
+	mux.Handle("DELETE /api/coupons/{name}/claims/{user_id}", h.requireAdmin(h.ReleaseClaim))
+	mux.Handle("POST /api/coupons/{name}/reservations", h.requireUser(h.CreateReservation))
+	mux.Handle("POST /api/coupons/{name}/waitlist", h.requireUser(h.JoinWaitlist))
+	mux.Handle("GET /api/coupons/{name}/waitlist/{user_id}", h.requireUser(h.GetWaitlistEntry))
+	mux.Handle("DELETE /api/coupons/{name}/waitlist/{user_id}", h.requireUser(h.LeaveWaitlist))
+	mux.Handle("GET /api/reservations/{id}", h.requireUser(h.GetReservation))
+	mux.Handle("POST /api/reservations/{id}/confirm", h.requireUser(h.ConfirmReservation))
+	mux.Handle("POST /api/reservations/{id}/cancel", h.requireUser(h.CancelReservation))
+	mux.Handle("GET /api/users/{user_id}/claims", h.requireUser(h.ListUserClaims))
 
-	return mux
+	return h.withRequestID(h.withTenant(metrics.RecordRoute(mux)))
 }
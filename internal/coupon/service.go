@@ -2,29 +2,80 @@ package coupon
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"log/slog"
+	"time"
+
+	"scalable-coupon-system/pkg/couponapi"
+)
+
+// DefaultMaxClaimsPerUser applies when a coupon is created without an
+// explicit per-user limit.
+const DefaultMaxClaimsPerUser = 1
+
+const (
+	MaxClaimedByDetails = couponapi.MaxClaimedByDetails
+	MaxPageSize         = couponapi.MaxPageSize
+	defaultPageSize     = 20
 )
 
-type Service struct {
-	repo *Repository
+// Service is the coupon API's business logic: request validation, paging
+// and the mapping of stored rows to responses. Handler depends only on this
+// interface.
+type Service interface {
+	CreateCoupon(ctx context.Context, request CreateCouponRequest) error
+	UpdateCoupon(ctx context.Context, couponName string, expectedVersion int64, req UpdateCouponRequest) (GetCouponDetailsResponse, error)
+	ListCoupons(ctx context.Context, req ListCouponsRequest) (ListCouponsResponse, error)
+	GetCouponDetails(ctx context.Context, couponName string, includeClaimedBy bool) (GetCouponDetailsResponse, error)
+
+	ClaimCoupon(ctx context.Context, req ClaimCouponRequest) error
+	ListCouponClaims(ctx context.Context, req ListCouponClaimsRequest) (ListCouponClaimsResponse, error)
+	RedeemCoupon(ctx context.Context, req RedeemCouponRequest) (ClaimResponse, error)
+	VoidCoupon(ctx context.Context, req VoidCouponRequest) (ClaimResponse, error)
+	ReleaseClaim(ctx context.Context, couponName string, userID string) error
+	ListUserClaims(ctx context.Context, req ListUserClaimsRequest) (ListUserClaimsResponse, error)
+
+	CreateReservation(ctx context.Context, couponName string, req CreateReservationRequest, ttl time.Duration) (ReservationResponse, error)
+	GetReservation(ctx context.Context, id string) (ReservationResponse, error)
+	ConfirmReservation(ctx context.Context, id string) (ReservationResponse, error)
+	CancelReservation(ctx context.Context, id string) (ReservationResponse, error)
+
+	JoinWaitlist(ctx context.Context, couponName string, req JoinWaitlistRequest) (WaitlistEntryResponse, error)
+	GetWaitlistEntry(ctx context.Context, couponName string, userID string) (WaitlistEntryResponse, error)
+	LeaveWaitlist(ctx context.Context, couponName string, userID string) error
+
+	BeginIdempotentRequest(ctx context.Context, scope string, principal string, key string, requestHash string, ttl time.Duration, lease time.Duration) (*IdempotencyRecord, string, error)
+	CompleteIdempotentRequest(ctx context.Context, scope string, principal string, key string, leaseToken string, record IdempotencyRecord) error
+	AbandonIdempotentRequest(ctx context.Context, scope string, principal string, key string, leaseToken string) error
+}
+
+type service struct {
+	repo Repository
 	log  *slog.Logger
 }
 
-func NewService(repo *Repository,
+// NewService returns the Service on top of repo, whichever backend it is.
+func NewService(repo Repository,
 	log *slog.Logger,
-) *Service {
-	return &Service{
+) Service {
+	return &service{
 		repo: repo,
 		log:  log,
 	}
 }
 
-func (s *Service) CreateCoupon(
+func (s *service) CreateCoupon(
 	ctx context.Context,
 	request CreateCouponRequest,
 ) error {
+	if err := request.Validate(); err != nil {
+		return err
+	}
+	if request.StartsAt != nil && request.EndsAt != nil &&
+		!request.EndsAt.After(*request.StartsAt) {
+		return ErrInvalidValidityWindow
+	}
+
 	couponExist, err := s.repo.CheckCouponExist(ctx, request.Name)
 	if err != nil {
 		return err
@@ -34,9 +85,17 @@ func (s *Service) CreateCoupon(
 		return ErrCouponAlreadyExists
 	}
 
+	maxClaimsPerUser := request.MaxClaimsPerUser
+	if maxClaimsPerUser <= 0 {
+		maxClaimsPerUser = DefaultMaxClaimsPerUser
+	}
+
 	coupon := Coupons{
-		Name:   request.Name,
-		Amount: request.Amount,
+		Name:             request.Name,
+		Amount:           request.Amount,
+		MaxClaimsPerUser: maxClaimsPerUser,
+		StartsAt:         request.StartsAt,
+		EndsAt:           request.EndsAt,
 	}
 	err = s.repo.InsertCoupon(ctx, coupon)
 	if err != nil {
@@ -46,10 +105,78 @@ func (s *Service) CreateCoupon(
 	return nil
 }
 
-func (s *Service) ClaimCoupon(
+func (s *service) UpdateCoupon(
+	ctx context.Context,
+	couponName string,
+	expectedVersion int64,
+	req UpdateCouponRequest,
+) (GetCouponDetailsResponse, error) {
+	if err := req.Validate(); err != nil {
+		return GetCouponDetailsResponse{}, err
+	}
+
+	err := s.repo.UpdateCoupon(ctx, couponName, expectedVersion, req)
+	if err != nil {
+		return GetCouponDetailsResponse{}, err
+	}
+
+	return s.GetCouponDetails(ctx, couponName, false)
+}
+
+func (s *service) ListCoupons(
+	ctx context.Context,
+	req ListCouponsRequest,
+) (ListCouponsResponse, error) {
+	resp := ListCouponsResponse{Coupons: []CouponSummaryResponse{}}
+
+	filter := CouponFilter{
+		NamePrefix:    req.NamePrefix,
+		Status:        CouponStatus(req.Status),
+		CreatedAfter:  req.CreatedAfter,
+		CreatedBefore: req.CreatedBefore,
+		Limit:         pageSize(req.Limit) + 1,
+	}
+
+	if req.Cursor != "" {
+		var after CouponCursor
+		if err := decodeCursor(req.Cursor, &after); err != nil {
+			return resp, err
+		}
+		filter.After = &after
+	}
+
+	coupons, err := s.repo.ListCoupons(ctx, filter)
+	if err != nil {
+		return resp, err
+	}
+
+	if len(coupons) == filter.Limit {
+		coupons = coupons[:len(coupons)-1]
+		last := coupons[len(coupons)-1]
+		resp.NextCursor, err = encodeCursor(CouponCursor{
+			CreatedAt: last.CreatedAt,
+			Name:      last.Name,
+		})
+		if err != nil {
+			return resp, err
+		}
+	}
+
+	for _, c := range coupons {
+		resp.Coupons = append(resp.Coupons, toCouponSummaryResponse(c))
+	}
+
+	return resp, nil
+}
+
+func (s *service) ClaimCoupon(
 	ctx context.Context,
 	req ClaimCouponRequest,
 ) error {
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
 	err := s.repo.ClaimCoupon(ctx, req)
 	if err == nil {
 		return nil
@@ -60,31 +187,399 @@ func (s *Service) ClaimCoupon(
 		return ErrCouponNotFound
 	case errors.Is(err, ErrCouponOutOfStock):
 		return ErrCouponOutOfStock
-	case errors.Is(err, ErrCouponAlreadyClaimed):
-		return ErrCouponAlreadyClaimed
+	case errors.Is(err, ErrClaimLimitReached):
+		return ErrClaimLimitReached
+	case errors.Is(err, ErrCouponNotYetActive):
+		return ErrCouponNotYetActive
+	case errors.Is(err, ErrCouponExpired):
+		return ErrCouponExpired
+	case errors.Is(err, ErrClaimContention):
+		return ErrClaimContention
 	default:
 		return err
 	}
 }
 
-func (s *Service) GetCouponDetails(
+func (s *service) BeginIdempotentRequest(
+	ctx context.Context,
+	scope string,
+	principal string,
+	key string,
+	requestHash string,
+	ttl time.Duration,
+	lease time.Duration,
+) (*IdempotencyRecord, string, error) {
+	return s.repo.BeginIdempotentRequest(ctx, scope, principal, key, requestHash, ttl, lease)
+}
+
+func (s *service) CompleteIdempotentRequest(
+	ctx context.Context,
+	scope string,
+	principal string,
+	key string,
+	leaseToken string,
+	record IdempotencyRecord,
+) error {
+	return s.repo.CompleteIdempotentRequest(ctx, scope, principal, key, leaseToken, record)
+}
+
+func (s *service) AbandonIdempotentRequest(
+	ctx context.Context,
+	scope string,
+	principal string,
+	key string,
+	leaseToken string,
+) error {
+	return s.repo.AbandonIdempotentRequest(ctx, scope, principal, key, leaseToken)
+}
+
+func (s *service) GetCouponDetails(
 	ctx context.Context,
 	couponName string,
+	includeClaimedBy bool,
 ) (GetCouponDetailsResponse, error) {
 	var resp GetCouponDetailsResponse
 
-	details, err := s.repo.GetCouponDetails(ctx, couponName)
+	claimedByLimit := 0
+	if includeClaimedBy {
+		claimedByLimit = MaxClaimedByDetails
+	}
+
+	details, err := s.repo.GetCouponDetails(ctx, couponName, claimedByLimit)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return resp, ErrCouponNotFound
-		}
 		return resp, err
 	}
 
 	resp.Name = details.Name
 	resp.Amount = details.Amount
 	resp.RemainingAmount = details.RemainingAmount
+	resp.ClaimedCount = details.ClaimedCount
+	resp.ReservedCount = details.ReservedCount
+	resp.MaxClaimsPerUser = details.MaxClaimsPerUser
+	resp.StartsAt = details.StartsAt
+	resp.EndsAt = details.EndsAt
+	resp.Version = details.Version
+	resp.CreatedAt = details.CreatedAt
 	resp.ClaimedBy = details.ClaimedBy
 
 	return resp, nil
 }
+
+func (s *service) ListCouponClaims(
+	ctx context.Context,
+	req ListCouponClaimsRequest,
+) (ListCouponClaimsResponse, error) {
+	resp := ListCouponClaimsResponse{Claims: []ClaimResponse{}}
+
+	var after CouponClaimCursor
+	if req.Cursor != "" {
+		if err := decodeCursor(req.Cursor, &after); err != nil {
+			return resp, err
+		}
+	}
+
+	limit := pageSize(req.Limit) + 1
+	claims, err := s.repo.ListCouponClaims(ctx, req.CouponName, after.ID, limit)
+	if err != nil {
+		return resp, err
+	}
+
+	if len(claims) == 0 && req.Cursor == "" {
+		exists, err := s.repo.CheckCouponExist(ctx, req.CouponName)
+		if err != nil {
+			return resp, err
+		}
+		if !exists {
+			return resp, ErrCouponNotFound
+		}
+	}
+
+	if len(claims) == limit {
+		claims = claims[:len(claims)-1]
+		resp.NextCursor, err = encodeCursor(CouponClaimCursor{ID: claims[len(claims)-1].ID})
+		if err != nil {
+			return resp, err
+		}
+	}
+
+	for _, claim := range claims {
+		resp.Claims = append(resp.Claims, toClaimResponse(claim))
+	}
+
+	return resp, nil
+}
+
+func (s *service) RedeemCoupon(
+	ctx context.Context,
+	req RedeemCouponRequest,
+) (ClaimResponse, error) {
+	if err := req.Validate(); err != nil {
+		return ClaimResponse{}, err
+	}
+
+	claim, err := s.repo.RedeemClaim(ctx, req)
+	if err != nil {
+		return ClaimResponse{}, err
+	}
+
+	return toClaimResponse(*claim), nil
+}
+
+func (s *service) VoidCoupon(
+	ctx context.Context,
+	req VoidCouponRequest,
+) (ClaimResponse, error) {
+	if err := req.Validate(); err != nil {
+		return ClaimResponse{}, err
+	}
+
+	claim, err := s.repo.VoidClaim(ctx, req)
+	if err != nil {
+		return ClaimResponse{}, err
+	}
+
+	return toClaimResponse(*claim), nil
+}
+
+func (s *service) ReleaseClaim(
+	ctx context.Context,
+	couponName string,
+	userID string,
+) error {
+	return s.repo.ReleaseClaim(ctx, couponName, userID)
+}
+
+// CreateReservation holds stock for ttl. A zero ttl is rejected; the handler
+// substitutes the deployment default when the request leaves it out.
+func (s *service) CreateReservation(
+	ctx context.Context,
+	couponName string,
+	req CreateReservationRequest,
+	ttl time.Duration,
+) (ReservationResponse, error) {
+	if err := req.Validate(); err != nil {
+		return ReservationResponse{}, err
+	}
+	if ttl <= 0 || ttl > MaxReservationTTL {
+		s.log.Warn("invalid reservation ttl", "coupon_name", couponName, "ttl", ttl)
+		return ReservationResponse{}, ErrInvalidReservationTTL
+	}
+
+	reservation, err := s.repo.CreateReservation(ctx, couponName, req.UserId, ttl)
+	if err != nil {
+		return ReservationResponse{}, err
+	}
+
+	return toReservationResponse(*reservation), nil
+}
+
+func (s *service) GetReservation(
+	ctx context.Context,
+	id string,
+) (ReservationResponse, error) {
+	reservation, err := s.repo.GetReservation(ctx, id)
+	if err != nil {
+		return ReservationResponse{}, err
+	}
+
+	return toReservationResponse(*reservation), nil
+}
+
+func (s *service) ConfirmReservation(
+	ctx context.Context,
+	id string,
+) (ReservationResponse, error) {
+	reservation, err := s.repo.ConfirmReservation(ctx, id)
+	if err != nil {
+		return ReservationResponse{}, err
+	}
+
+	return toReservationResponse(*reservation), nil
+}
+
+func (s *service) CancelReservation(
+	ctx context.Context,
+	id string,
+) (ReservationResponse, error) {
+	reservation, err := s.repo.CancelReservation(ctx, id)
+	if err != nil {
+		return ReservationResponse{}, err
+	}
+
+	return toReservationResponse(*reservation), nil
+}
+
+func (s *service) JoinWaitlist(
+	ctx context.Context,
+	couponName string,
+	req JoinWaitlistRequest,
+) (WaitlistEntryResponse, error) {
+	if err := req.Validate(); err != nil {
+		return WaitlistEntryResponse{}, err
+	}
+
+	entry, err := s.repo.JoinWaitlist(ctx, couponName, req.UserId)
+	if err != nil {
+		return WaitlistEntryResponse{}, err
+	}
+
+	return toWaitlistEntryResponse(*entry), nil
+}
+
+func (s *service) GetWaitlistEntry(
+	ctx context.Context,
+	couponName string,
+	userID string,
+) (WaitlistEntryResponse, error) {
+	entry, err := s.repo.GetWaitlistEntry(ctx, couponName, userID)
+	if err != nil {
+		return WaitlistEntryResponse{}, err
+	}
+
+	return toWaitlistEntryResponse(*entry), nil
+}
+
+func (s *service) LeaveWaitlist(
+	ctx context.Context,
+	couponName string,
+	userID string,
+) error {
+	return s.repo.LeaveWaitlist(ctx, couponName, userID)
+}
+
+func (s *service) ListUserClaims(
+	ctx context.Context,
+	req ListUserClaimsRequest,
+) (ListUserClaimsResponse, error) {
+	resp := ListUserClaimsResponse{Claims: []UserClaimResponse{}}
+
+	filter := UserClaimFilter{
+		UserID: req.UserID,
+		Status: ClaimStatus(req.Status),
+		Sort:   ClaimSort(req.Sort),
+		Limit:  pageSize(req.Limit) + 1,
+	}
+
+	switch filter.Status {
+	case "", ClaimStatusClaimed, ClaimStatusRedeemed, ClaimStatusVoided, ClaimStatusExpired:
+	default:
+		return resp, ErrInvalidStatusFilter
+	}
+
+	if filter.Sort == "" {
+		filter.Sort = ClaimSortClaimedAt
+	}
+
+	switch req.Order {
+	case "", "desc":
+		filter.Desc = true
+	case "asc":
+		filter.Desc = false
+	default:
+		return resp, ErrInvalidSort
+	}
+
+	if req.Cursor != "" {
+		var after ClaimCursor
+		if err := decodeCursor(req.Cursor, &after); err != nil {
+			return resp, err
+		}
+		if after.Sort != filter.Sort || after.Desc != filter.Desc {
+			return resp, ErrInvalidCursor
+		}
+		filter.After = &after
+	}
+
+	claims, err := s.repo.ListUserClaims(ctx, filter)
+	if err != nil {
+		return resp, err
+	}
+
+	if len(claims) == filter.Limit {
+		claims = claims[:len(claims)-1]
+		last := claims[len(claims)-1].Claim
+		next := ClaimCursor{Sort: filter.Sort, Desc: filter.Desc, ID: last.ID}
+		switch filter.Sort {
+		case ClaimSortClaimedAt:
+			next.Value = last.ClaimedAt.Format(time.RFC3339Nano)
+		case ClaimSortUpdatedAt:
+			next.Value = last.UpdatedAt.Format(time.RFC3339Nano)
+		case ClaimSortCouponName:
+			next.Value = last.CouponName
+		}
+		resp.NextCursor, err = encodeCursor(next)
+		if err != nil {
+			return resp, err
+		}
+	}
+
+	for _, uc := range claims {
+		resp.Claims = append(resp.Claims, UserClaimResponse{
+			ClaimResponse: toClaimResponse(uc.Claim),
+			Coupon:        toCouponSummaryResponse(uc.Coupon),
+		})
+	}
+
+	return resp, nil
+}
+
+func toCouponSummaryResponse(d Details) CouponSummaryResponse {
+	return CouponSummaryResponse{
+		Name:             d.Name,
+		Amount:           d.Amount,
+		RemainingAmount:  d.RemainingAmount,
+		MaxClaimsPerUser: d.MaxClaimsPerUser,
+		StartsAt:         d.StartsAt,
+		EndsAt:           d.EndsAt,
+		Version:          d.Version,
+		CreatedAt:        d.CreatedAt,
+	}
+}
+
+func toClaimResponse(claim ClaimHistory) ClaimResponse {
+	return ClaimResponse{
+		ID:         claim.ID,
+		UserID:     claim.UserID,
+		CouponName: claim.CouponName,
+		Status:     string(claim.Status),
+		OrderRef:   claim.OrderRef,
+		ClaimedAt:  claim.ClaimedAt,
+		UpdatedAt:  claim.UpdatedAt,
+	}
+}
+
+func toReservationResponse(reservation Reservation) ReservationResponse {
+	return ReservationResponse{
+		ID:         reservation.ID,
+		CouponName: reservation.CouponName,
+		UserID:     reservation.UserID,
+		Status:     string(reservation.Status),
+		ClaimID:    reservation.ClaimID,
+		ExpiresAt:  reservation.ExpiresAt,
+		CreatedAt:  reservation.CreatedAt,
+		UpdatedAt:  reservation.UpdatedAt,
+	}
+}
+
+func toWaitlistEntryResponse(entry WaitlistEntry) WaitlistEntryResponse {
+	return WaitlistEntryResponse{
+		CouponName: entry.CouponName,
+		UserID:     entry.UserID,
+		Status:     string(entry.Status),
+		Position:   entry.Position,
+		ClaimID:    entry.ClaimID,
+		JoinedAt:   entry.CreatedAt,
+		UpdatedAt:  entry.UpdatedAt,
+	}
+}
+
+func pageSize(limit int) int {
+	switch {
+	case limit <= 0:
+		return defaultPageSize
+	case limit > MaxPageSize:
+		return MaxPageSize
+	default:
+		return limit
+	}
+}
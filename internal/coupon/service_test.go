@@ -1,19 +1,30 @@
 package coupon
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"scalable-coupon-system/internal/health"
+	"scalable-coupon-system/internal/migrate"
+	"scalable-coupon-system/migration"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 )
 
-func setupTestDB(t *testing.T) *pgxpool.Pool {
+func setupTestDB(t testing.TB) *pgxpool.Pool {
 	wd, _ := os.Getwd()
 	var envPath string
 
@@ -53,47 +64,41 @@ func setupTestDB(t *testing.T) *pgxpool.Pool {
 		t.Fatalf("Failed to ping test database: %v", err)
 	}
 
-	createTables(t, pool)
+	migrateTestDB(t, pool)
 
 	return pool
 }
 
-func createTables(t *testing.T, db *pgxpool.Pool) {
+// migrateTestDB rebuilds the schema from the embedded migrations, so tests
+// run against exactly what production gets.
+func migrateTestDB(t testing.TB, db *pgxpool.Pool) {
 	ctx := context.Background()
 
 	_, err := db.Exec(ctx, `
-		DROP TABLE IF EXISTS claim_history CASCADE;
-		DROP TABLE IF EXISTS coupons CASCADE;
+		DROP SCHEMA public CASCADE;
+		CREATE SCHEMA public;
 	`)
 	if err != nil {
-		t.Fatalf("Failed to drop tables: %v", err)
+		t.Fatalf("Failed to reset schema: %v", err)
 	}
 
-	_, err = db.Exec(ctx, `
-		CREATE TABLE coupons (
-			name VARCHAR(255) PRIMARY KEY,
-			amount INTEGER NOT NULL
-		);
-	`)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	migrator, err := migrate.New(db, logger, migration.FS)
 	if err != nil {
-		t.Fatalf("Failed to create coupons table: %v", err)
+		t.Fatalf("Failed to load migrations: %v", err)
 	}
-
-	_, err = db.Exec(ctx, `
-		CREATE TABLE claim_history (
-			user_id VARCHAR(255) NOT NULL,
-			coupon_name VARCHAR(255) NOT NULL,
-			CONSTRAINT claim_history_unique UNIQUE (user_id, coupon_name)
-		);
-	`)
-	if err != nil {
-		t.Fatalf("Failed to create claim_history table: %v", err)
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Failed to apply migrations: %v", err)
 	}
 }
 
-func cleanupTestDB(t *testing.T, db *pgxpool.Pool) {
+func cleanupTestDB(t testing.TB, db *pgxpool.Pool) {
 	ctx := context.Background()
 	_, err := db.Exec(ctx, `
+		TRUNCATE TABLE outbox CASCADE;
+		TRUNCATE TABLE waitlist CASCADE;
+		TRUNCATE TABLE reservations CASCADE;
+		TRUNCATE TABLE idempotency_keys CASCADE;
 		TRUNCATE TABLE claim_history CASCADE;
 		TRUNCATE TABLE coupons CASCADE;
 	`)
@@ -155,7 +160,7 @@ func TestFlashSaleAttack(t *testing.T) {
 
 	wg.Wait()
 
-	details, err := service.GetCouponDetails(ctx, couponName)
+	details, err := service.GetCouponDetails(ctx, couponName, false)
 	if err != nil {
 		t.Fatalf("Failed to get coupon details: %v", err)
 	}
@@ -233,7 +238,7 @@ func TestDoubleDipAttack(t *testing.T) {
 
 	wg.Wait()
 
-	details, err := service.GetCouponDetails(ctx, couponName)
+	details, err := service.GetCouponDetails(ctx, couponName, true)
 	if err != nil {
 		t.Fatalf("Failed to get coupon details: %v", err)
 	}
@@ -262,6 +267,10 @@ func TestDoubleDipAttack(t *testing.T) {
 		t.Errorf("Expected user %s to appear exactly once in claimed_by, but found %d times", userID, claimedByCount)
 	}
 
+	if details.ClaimedCount != 1 {
+		t.Errorf("Expected claimed_count 1, got %d", details.ClaimedCount)
+	}
+
 	t.Logf("Double Dip Attack Results:")
 	t.Logf("  Total Requests: %d", concurrentRequests)
 	t.Logf("  Successful Claims: %d", successCount)
@@ -269,3 +278,1606 @@ func TestDoubleDipAttack(t *testing.T) {
 	t.Logf("  Remaining Stock: %d", details.RemainingAmount)
 	t.Logf("  Error Breakdown: %v", errors)
 }
+
+func TestClaimStrategies(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	ctx := context.Background()
+
+	stockAmount := 5
+	concurrentRequests := 50
+
+	for _, name := range ClaimStrategies {
+		t.Run(name, func(t *testing.T) {
+			strategy, err := NewClaimStrategy(name, logger)
+			if err != nil {
+				t.Fatalf("Failed to build strategy: %v", err)
+			}
+			repo := NewRepositoryWithStrategy(db, logger, strategy)
+			service := NewService(repo, logger)
+
+			flashSale := "FLASH_" + name
+			doubleDip := "DIP_" + name
+			for _, req := range []CreateCouponRequest{
+				{Name: flashSale, Amount: stockAmount},
+				{Name: doubleDip, Amount: concurrentRequests},
+			} {
+				if err := service.CreateCoupon(ctx, req); err != nil {
+					t.Fatalf("Failed to create coupon %s: %v", req.Name, err)
+				}
+			}
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			flashSuccess := 0
+			dipSuccess := 0
+
+			for i := 0; i < concurrentRequests; i++ {
+				wg.Add(2)
+				go func(userID int) {
+					defer wg.Done()
+					err := service.ClaimCoupon(ctx, ClaimCouponRequest{
+						UserId:     fmt.Sprintf("user_%d", userID),
+						CouponName: flashSale,
+					})
+					mu.Lock()
+					if err == nil {
+						flashSuccess++
+					}
+					mu.Unlock()
+				}(i)
+				go func() {
+					defer wg.Done()
+					err := service.ClaimCoupon(ctx, ClaimCouponRequest{
+						UserId:     "user_12345",
+						CouponName: doubleDip,
+					})
+					mu.Lock()
+					if err == nil {
+						dipSuccess++
+					}
+					mu.Unlock()
+				}()
+			}
+
+			wg.Wait()
+
+			if flashSuccess != stockAmount {
+				t.Errorf("Flash sale: expected exactly %d successful claims, got %d", stockAmount, flashSuccess)
+			}
+
+			if dipSuccess != 1 {
+				t.Errorf("Double dip: expected exactly 1 successful claim, got %d", dipSuccess)
+			}
+
+			details, err := service.GetCouponDetails(ctx, flashSale, false)
+			if err != nil {
+				t.Fatalf("Failed to get coupon details: %v", err)
+			}
+			if details.RemainingAmount != 0 {
+				t.Errorf("Flash sale: expected 0 remaining stock, got %d", details.RemainingAmount)
+			}
+		})
+	}
+}
+
+// TestClaimStrategiesHonourUpdates queues a PATCH that closes the validity
+// window ahead of claims that have already read the coupon, so every strategy
+// has to notice the change between its read and its update.
+func TestClaimStrategiesHonourUpdates(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	ctx := context.Background()
+
+	concurrentClaims := 10
+
+	// Every claim, the update, the lock holder and the waiter count need a
+	// connection of their own, or they would queue on the pool instead.
+	config := db.Config()
+	config.MaxConns = int32(concurrentClaims + 3)
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("Failed to open pool: %v", err)
+	}
+	defer pool.Close()
+
+	for _, name := range ClaimStrategies {
+		t.Run(name, func(t *testing.T) {
+			strategy, err := NewClaimStrategy(name, logger)
+			if err != nil {
+				t.Fatalf("Failed to build strategy: %v", err)
+			}
+			repo := NewRepositoryWithStrategy(pool, logger, strategy)
+			service := NewService(repo, logger)
+
+			couponName := "CLOSING_" + name
+			if err := service.CreateCoupon(ctx, CreateCouponRequest{Name: couponName, Amount: concurrentClaims}); err != nil {
+				t.Fatalf("Failed to create coupon: %v", err)
+			}
+
+			// Hold the row lock so the update and then the claims queue on it
+			// in that order.
+			holder, err := pool.Begin(ctx)
+			if err != nil {
+				t.Fatalf("Failed to begin transaction: %v", err)
+			}
+			defer holder.Rollback(ctx)
+			_, err = holder.Exec(ctx, `SELECT 1 FROM coupons WHERE tenant_id = $1 AND name = $2 FOR UPDATE`, DefaultTenant, couponName)
+			if err != nil {
+				t.Fatalf("Failed to lock coupon: %v", err)
+			}
+
+			endsAt := time.Now()
+			updateErr := make(chan error, 1)
+			go func() {
+				_, err := service.UpdateCoupon(ctx, couponName, 1, UpdateCouponRequest{EndsAt: &endsAt})
+				updateErr <- err
+			}()
+			waitForLockWaiters(t, pool, 1)
+
+			var wg sync.WaitGroup
+			claimErrs := make(chan error, concurrentClaims)
+			for i := 0; i < concurrentClaims; i++ {
+				wg.Add(1)
+				go func(userID int) {
+					defer wg.Done()
+					claimErrs <- service.ClaimCoupon(ctx, ClaimCouponRequest{
+						UserId:     fmt.Sprintf("user_%d", userID),
+						CouponName: couponName,
+					})
+				}(i)
+			}
+			waitForLockWaiters(t, pool, 1+concurrentClaims)
+
+			if err := holder.Rollback(ctx); err != nil {
+				t.Fatalf("Failed to release lock: %v", err)
+			}
+			if err := <-updateErr; err != nil {
+				t.Fatalf("Failed to update coupon: %v", err)
+			}
+			wg.Wait()
+			close(claimErrs)
+
+			for err := range claimErrs {
+				if !errors.Is(err, ErrCouponExpired) {
+					t.Errorf("Expected %v for a claim after the window closed, got %v", ErrCouponExpired, err)
+				}
+			}
+
+			details, err := service.GetCouponDetails(ctx, couponName, false)
+			if err != nil {
+				t.Fatalf("Failed to get coupon details: %v", err)
+			}
+			if details.ClaimedCount != 0 {
+				t.Errorf("Expected no claims after the window closed, got %d", details.ClaimedCount)
+			}
+		})
+	}
+}
+
+// waitForLockWaiters blocks until at least n sessions wait on a lock.
+func waitForLockWaiters(t *testing.T, db *pgxpool.Pool, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var waiting int
+		err := db.QueryRow(context.Background(), `
+			SELECT COUNT(*)
+			FROM pg_stat_activity
+			WHERE datname = current_database() AND wait_event_type = 'Lock'
+		`).Scan(&waiting)
+		if err != nil {
+			t.Fatalf("Failed to count lock waiters: %v", err)
+		}
+		if waiting >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d sessions waiting on a lock, got %d", n, waiting)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFlashSaleWithReleases(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+
+	couponName := "PROMO_SUPER"
+	stockAmount := 5
+	concurrentClaims := 30
+
+	err := service.CreateCoupon(ctx, CreateCouponRequest{
+		Name:   couponName,
+		Amount: stockAmount,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+
+	for i := 0; i < stockAmount; i++ {
+		err := service.ClaimCoupon(ctx, ClaimCouponRequest{
+			UserId:     fmt.Sprintf("holder_%d", i),
+			CouponName: couponName,
+		})
+		if err != nil {
+			t.Fatalf("Failed to claim coupon for holder_%d: %v", i, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	successCount := 0
+	releaseCount := 0
+	errors := make(map[string]int)
+
+	for i := 0; i < stockAmount; i++ {
+		wg.Add(1)
+		go func(holder int) {
+			defer wg.Done()
+
+			err := service.ReleaseClaim(ctx, couponName, fmt.Sprintf("holder_%d", holder))
+
+			mu.Lock()
+			if err != nil {
+				errors["release: "+err.Error()]++
+			} else {
+				releaseCount++
+			}
+			mu.Unlock()
+		}(i)
+	}
+
+	for i := 0; i < concurrentClaims; i++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+
+			err := service.ClaimCoupon(ctx, ClaimCouponRequest{
+				UserId:     fmt.Sprintf("user_%d", userID),
+				CouponName: couponName,
+			})
+
+			mu.Lock()
+			if err != nil {
+				errors[err.Error()]++
+			} else {
+				successCount++
+			}
+			mu.Unlock()
+		}(i)
+	}
+
+	wg.Wait()
+
+	details, err := service.GetCouponDetails(ctx, couponName, false)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+
+	var claimRows int
+	err = db.QueryRow(ctx, `SELECT COUNT(*) FROM claim_history WHERE coupon_name = $1`, couponName).Scan(&claimRows)
+	if err != nil {
+		t.Fatalf("Failed to count claims: %v", err)
+	}
+
+	if releaseCount != stockAmount {
+		t.Errorf("Expected %d successful releases, got %d", stockAmount, releaseCount)
+	}
+
+	if successCount > stockAmount {
+		t.Errorf("Oversold: %d claims succeeded for %d units", successCount, stockAmount)
+	}
+
+	if claimRows != successCount {
+		t.Errorf("Expected %d claim rows, got %d", successCount, claimRows)
+	}
+
+	if details.RemainingAmount != stockAmount-successCount {
+		t.Errorf("Expected %d remaining stock, got %d", stockAmount-successCount, details.RemainingAmount)
+	}
+
+	t.Logf("Flash Sale With Releases Results:")
+	t.Logf("  Releases: %d", releaseCount)
+	t.Logf("  Successful Claims: %d", successCount)
+	t.Logf("  Remaining Stock: %d", details.RemainingAmount)
+	t.Logf("  Error Breakdown: %v", errors)
+}
+
+func TestClaimReleaseChurn(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+
+	couponName := "PROMO_CHURN"
+	stockAmount := 2
+	users := 10
+	rounds := 20
+
+	err := service.CreateCoupon(ctx, CreateCouponRequest{
+		Name:   couponName,
+		Amount: stockAmount,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	holders := 0
+	maxHolders := 0
+
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+
+			for round := 0; round < rounds; round++ {
+				err := service.ClaimCoupon(ctx, ClaimCouponRequest{
+					UserId:     userID,
+					CouponName: couponName,
+				})
+				if errors.Is(err, ErrCouponOutOfStock) || errors.Is(err, ErrClaimLimitReached) {
+					continue
+				}
+				if err != nil {
+					t.Errorf("Unexpected claim error for %s: %v", userID, err)
+					return
+				}
+
+				// holders is raised after the claim commits and lowered before
+				// the release starts, so it never exceeds the real claim count.
+				// The claim is held across a read of the coupon, so holders of
+				// different users overlap and an oversell shows in both.
+				mu.Lock()
+				holders++
+				maxHolders = max(maxHolders, holders)
+				mu.Unlock()
+
+				details, err := service.GetCouponDetails(ctx, couponName, false)
+				if err != nil {
+					t.Errorf("Failed to get coupon details: %v", err)
+				} else if details.ClaimedCount > stockAmount || details.RemainingAmount < 0 {
+					t.Errorf("Oversold during churn: %d claimed, %d remaining of %d", details.ClaimedCount, details.RemainingAmount, stockAmount)
+				}
+
+				mu.Lock()
+				holders--
+				mu.Unlock()
+
+				if err := service.ReleaseClaim(ctx, couponName, userID); err != nil {
+					t.Errorf("Failed to release claim for %s: %v", userID, err)
+				}
+			}
+		}(fmt.Sprintf("user_%d", i))
+	}
+
+	wg.Wait()
+
+	details, err := service.GetCouponDetails(ctx, couponName, false)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+
+	if maxHolders > stockAmount {
+		t.Errorf("Oversold: observed %d concurrent holders for %d units", maxHolders, stockAmount)
+	}
+
+	if details.RemainingAmount != stockAmount {
+		t.Errorf("Expected all %d units back in stock, got %d", stockAmount, details.RemainingAmount)
+	}
+}
+
+func TestPerUserClaimLimit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+
+	couponName := "PROMO_TRIPLE"
+	userID := "user_12345"
+	maxClaims := 3
+	concurrentRequests := 10
+
+	err := service.CreateCoupon(ctx, CreateCouponRequest{
+		Name:             couponName,
+		Amount:           10,
+		MaxClaimsPerUser: maxClaims,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	successCount := 0
+	limitCount := 0
+
+	for i := 0; i < concurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := service.ClaimCoupon(ctx, ClaimCouponRequest{
+				UserId:     userID,
+				CouponName: couponName,
+			})
+
+			mu.Lock()
+			switch {
+			case err == nil:
+				successCount++
+			case errors.Is(err, ErrClaimLimitReached):
+				limitCount++
+			default:
+				t.Errorf("Unexpected claim error: %v", err)
+			}
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	details, err := service.GetCouponDetails(ctx, couponName, false)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+
+	if successCount != maxClaims {
+		t.Errorf("Expected exactly %d successful claims, got %d", maxClaims, successCount)
+	}
+
+	if limitCount != concurrentRequests-maxClaims {
+		t.Errorf("Expected %d limit rejections, got %d", concurrentRequests-maxClaims, limitCount)
+	}
+
+	if details.RemainingAmount != 10-maxClaims {
+		t.Errorf("Expected %d remaining stock, got %d", 10-maxClaims, details.RemainingAmount)
+	}
+}
+
+func TestClaimOutsideValidityWindow(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+	longAgo := now.Add(-2 * time.Hour)
+
+	cases := []struct {
+		name     string
+		startsAt *time.Time
+		endsAt   *time.Time
+		wantErr  error
+	}{
+		{name: "PROMO_UPCOMING", startsAt: &future, wantErr: ErrCouponNotYetActive},
+		{name: "PROMO_ENDED", startsAt: &longAgo, endsAt: &past, wantErr: ErrCouponExpired},
+		{name: "PROMO_OPEN", startsAt: &past, endsAt: &future, wantErr: nil},
+	}
+
+	for _, tc := range cases {
+		err := service.CreateCoupon(ctx, CreateCouponRequest{
+			Name:     tc.name,
+			Amount:   1,
+			StartsAt: tc.startsAt,
+			EndsAt:   tc.endsAt,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create coupon %s: %v", tc.name, err)
+		}
+
+		err = service.ClaimCoupon(ctx, ClaimCouponRequest{
+			UserId:     "user_1",
+			CouponName: tc.name,
+		})
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("Claim %s: expected error %v, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+
+	err := service.CreateCoupon(ctx, CreateCouponRequest{
+		Name:     "PROMO_INVERTED",
+		Amount:   1,
+		StartsAt: &future,
+		EndsAt:   &past,
+	})
+	if !errors.Is(err, ErrInvalidValidityWindow) {
+		t.Errorf("Expected %v for inverted window, got %v", ErrInvalidValidityWindow, err)
+	}
+}
+
+func TestConcurrentRedeemAndVoid(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+
+	couponName := "PROMO_CHECKOUT"
+	userID := "user_12345"
+	concurrentRequests := 10
+
+	err := service.CreateCoupon(ctx, CreateCouponRequest{
+		Name:   couponName,
+		Amount: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+
+	err = service.ClaimCoupon(ctx, ClaimCouponRequest{
+		UserId:     userID,
+		CouponName: couponName,
+	})
+	if err != nil {
+		t.Fatalf("Failed to claim coupon: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemedOrders := []string{}
+
+	for i := 0; i < concurrentRequests; i++ {
+		wg.Add(1)
+		go func(order int) {
+			defer wg.Done()
+
+			orderRef := fmt.Sprintf("order_%d", order)
+			_, err := service.RedeemCoupon(ctx, RedeemCouponRequest{
+				UserId:     userID,
+				CouponName: couponName,
+				OrderRef:   orderRef,
+			})
+
+			mu.Lock()
+			switch {
+			case err == nil:
+				redeemedOrders = append(redeemedOrders, orderRef)
+			case errors.Is(err, ErrClaimNotRedeemable):
+			default:
+				t.Errorf("Unexpected redeem error: %v", err)
+			}
+			mu.Unlock()
+		}(i)
+	}
+
+	wg.Wait()
+
+	if len(redeemedOrders) != 1 {
+		t.Fatalf("Expected exactly 1 redemption, got %d", len(redeemedOrders))
+	}
+	orderRef := redeemedOrders[0]
+
+	replay, err := service.RedeemCoupon(ctx, RedeemCouponRequest{
+		UserId:     userID,
+		CouponName: couponName,
+		OrderRef:   orderRef,
+	})
+	if err != nil {
+		t.Fatalf("Expected redeem replay to succeed, got %v", err)
+	}
+	if replay.Status != string(ClaimStatusRedeemed) {
+		t.Errorf("Expected status %s on replay, got %s", ClaimStatusRedeemed, replay.Status)
+	}
+
+	for i := 0; i < 2; i++ {
+		voided, err := service.VoidCoupon(ctx, VoidCouponRequest{
+			UserId:     userID,
+			CouponName: couponName,
+			OrderRef:   orderRef,
+		})
+		if err != nil {
+			t.Fatalf("Void attempt %d failed: %v", i+1, err)
+		}
+		if voided.Status != string(ClaimStatusVoided) {
+			t.Errorf("Expected status %s, got %s", ClaimStatusVoided, voided.Status)
+		}
+	}
+
+	_, err = service.RedeemCoupon(ctx, RedeemCouponRequest{
+		UserId:     userID,
+		CouponName: couponName,
+		OrderRef:   orderRef,
+	})
+	if !errors.Is(err, ErrClaimNotRedeemable) {
+		t.Errorf("Expected %v when redeeming a voided claim, got %v", ErrClaimNotRedeemable, err)
+	}
+}
+
+func TestUpdateCouponStock(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+
+	couponName := "PROMO_RESTOCK"
+	concurrentUpdates := 10
+
+	err := service.CreateCoupon(ctx, CreateCouponRequest{
+		Name:   couponName,
+		Amount: 5,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err := service.ClaimCoupon(ctx, ClaimCouponRequest{
+			UserId:     fmt.Sprintf("user_%d", i),
+			CouponName: couponName,
+		})
+		if err != nil {
+			t.Fatalf("Failed to claim coupon: %v", err)
+		}
+	}
+
+	details, err := service.GetCouponDetails(ctx, couponName, false)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+
+	tooLow := 2
+	_, err = service.UpdateCoupon(ctx, couponName, details.Version, UpdateCouponRequest{Amount: &tooLow})
+	if !errors.Is(err, ErrAmountBelowClaimed) {
+		t.Errorf("Expected %v, got %v", ErrAmountBelowClaimed, err)
+	}
+
+	_, err = service.UpdateCoupon(ctx, couponName, details.Version+1, UpdateCouponRequest{Amount: &tooLow})
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Expected %v for stale version, got %v", ErrVersionMismatch, err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	successCount := 0
+	mismatchCount := 0
+
+	for i := 0; i < concurrentUpdates; i++ {
+		wg.Add(1)
+		go func(amount int) {
+			defer wg.Done()
+
+			_, err := service.UpdateCoupon(ctx, couponName, details.Version, UpdateCouponRequest{Amount: &amount})
+
+			mu.Lock()
+			switch {
+			case err == nil:
+				successCount++
+			case errors.Is(err, ErrVersionMismatch):
+				mismatchCount++
+			default:
+				t.Errorf("Unexpected update error: %v", err)
+			}
+			mu.Unlock()
+		}(10 + i)
+	}
+
+	wg.Wait()
+
+	if successCount != 1 {
+		t.Errorf("Expected exactly 1 successful update, got %d", successCount)
+	}
+
+	if mismatchCount != concurrentUpdates-1 {
+		t.Errorf("Expected %d version conflicts, got %d", concurrentUpdates-1, mismatchCount)
+	}
+
+	updated, err := service.GetCouponDetails(ctx, couponName, false)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+
+	if updated.Version != details.Version+1 {
+		t.Errorf("Expected version %d, got %d", details.Version+1, updated.Version)
+	}
+
+	if updated.RemainingAmount != updated.Amount-3 {
+		t.Errorf("Expected %d remaining stock, got %d", updated.Amount-3, updated.RemainingAmount)
+	}
+}
+
+func TestListCouponsPagination(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+
+	listed := []string{"LIST_A", "LIST_B", "LIST_C", "LIST_D", "LIST_E"}
+	for _, name := range append([]string{"OTHER"}, listed...) {
+		err := service.CreateCoupon(ctx, CreateCouponRequest{Name: name, Amount: 1})
+		if err != nil {
+			t.Fatalf("Failed to create coupon %s: %v", name, err)
+		}
+	}
+
+	err := service.ClaimCoupon(ctx, ClaimCouponRequest{UserId: "user_1", CouponName: "LIST_C"})
+	if err != nil {
+		t.Fatalf("Failed to claim coupon: %v", err)
+	}
+
+	seen := make(map[string]int)
+	cursor := ""
+	pages := 0
+	for {
+		page, err := service.ListCoupons(ctx, ListCouponsRequest{
+			NamePrefix: "LIST_",
+			Cursor:     cursor,
+			Limit:      2,
+		})
+		if err != nil {
+			t.Fatalf("Failed to list coupons: %v", err)
+		}
+		pages++
+
+		for _, c := range page.Coupons {
+			seen[c.Name]++
+			if c.Name == "LIST_C" && c.RemainingAmount != 0 {
+				t.Errorf("Expected LIST_C to have 0 remaining, got %d", c.RemainingAmount)
+			}
+		}
+
+		// A coupon created mid-pagination sorts before the cursor and must
+		// not shift the remaining pages.
+		if pages == 1 {
+			err := service.CreateCoupon(ctx, CreateCouponRequest{Name: "LIST_NEW", Amount: 1})
+			if err != nil {
+				t.Fatalf("Failed to create coupon: %v", err)
+			}
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	for _, name := range listed {
+		if seen[name] != 1 {
+			t.Errorf("Expected %s exactly once, saw it %d times", name, seen[name])
+		}
+	}
+	if seen["OTHER"] != 0 || seen["LIST_NEW"] != 0 {
+		t.Errorf("Unexpected coupons in listing: %v", seen)
+	}
+	if pages != 3 {
+		t.Errorf("Expected 3 pages, got %d", pages)
+	}
+
+	soldOut, err := service.ListCoupons(ctx, ListCouponsRequest{Status: string(CouponStatusSoldOut)})
+	if err != nil {
+		t.Fatalf("Failed to list sold out coupons: %v", err)
+	}
+	if len(soldOut.Coupons) != 1 || soldOut.Coupons[0].Name != "LIST_C" {
+		t.Errorf("Expected only LIST_C to be sold out, got %v", soldOut.Coupons)
+	}
+
+	_, err = service.ListCoupons(ctx, ListCouponsRequest{Cursor: "not-a-cursor"})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected %v, got %v", ErrInvalidCursor, err)
+	}
+}
+
+func TestListUserClaims(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+
+	userID := "user_12345"
+	couponNames := []string{"PROMO_ONE", "PROMO_TWO", "PROMO_THREE"}
+
+	for _, name := range couponNames {
+		err := service.CreateCoupon(ctx, CreateCouponRequest{Name: name, Amount: 5})
+		if err != nil {
+			t.Fatalf("Failed to create coupon %s: %v", name, err)
+		}
+		err = service.ClaimCoupon(ctx, ClaimCouponRequest{UserId: userID, CouponName: name})
+		if err != nil {
+			t.Fatalf("Failed to claim coupon %s: %v", name, err)
+		}
+	}
+
+	err := service.ClaimCoupon(ctx, ClaimCouponRequest{UserId: "someone_else", CouponName: "PROMO_ONE"})
+	if err != nil {
+		t.Fatalf("Failed to claim coupon: %v", err)
+	}
+
+	var got []UserClaimResponse
+	cursor := ""
+	for {
+		page, err := service.ListUserClaims(ctx, ListUserClaimsRequest{
+			UserID: userID,
+			Order:  "asc",
+			Cursor: cursor,
+			Limit:  2,
+		})
+		if err != nil {
+			t.Fatalf("Failed to list user claims: %v", err)
+		}
+		got = append(got, page.Claims...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if len(got) != len(couponNames) {
+		t.Fatalf("Expected %d claims, got %d", len(couponNames), len(got))
+	}
+
+	for i, claim := range got {
+		if claim.CouponName != couponNames[i] {
+			t.Errorf("Claim %d: expected coupon %s, got %s", i, couponNames[i], claim.CouponName)
+		}
+		if claim.Status != string(ClaimStatusClaimed) {
+			t.Errorf("Claim %d: expected status %s, got %s", i, ClaimStatusClaimed, claim.Status)
+		}
+	}
+
+	if got[0].Coupon.RemainingAmount != 3 {
+		t.Errorf("Expected PROMO_ONE to have 3 remaining, got %d", got[0].Coupon.RemainingAmount)
+	}
+
+	_, err = service.RedeemCoupon(ctx, RedeemCouponRequest{
+		UserId:     userID,
+		CouponName: "PROMO_TWO",
+		OrderRef:   "order_1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to redeem coupon: %v", err)
+	}
+
+	redeemed, err := service.ListUserClaims(ctx, ListUserClaimsRequest{
+		UserID: userID,
+		Status: string(ClaimStatusRedeemed),
+	})
+	if err != nil {
+		t.Fatalf("Failed to list redeemed claims: %v", err)
+	}
+	if len(redeemed.Claims) != 1 || redeemed.Claims[0].CouponName != "PROMO_TWO" {
+		t.Errorf("Expected only PROMO_TWO to be redeemed, got %v", redeemed.Claims)
+	}
+
+	_, err = service.ListUserClaims(ctx, ListUserClaimsRequest{UserID: userID, Sort: "amount"})
+	if !errors.Is(err, ErrInvalidSort) {
+		t.Errorf("Expected %v, got %v", ErrInvalidSort, err)
+	}
+}
+
+func TestListCouponClaims(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+
+	couponName := "PROMO_POPULAR"
+	claims := 7
+
+	err := service.CreateCoupon(ctx, CreateCouponRequest{Name: couponName, Amount: claims})
+	if err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+
+	for i := 0; i < claims; i++ {
+		err := service.ClaimCoupon(ctx, ClaimCouponRequest{
+			UserId:     fmt.Sprintf("user_%d", i),
+			CouponName: couponName,
+		})
+		if err != nil {
+			t.Fatalf("Failed to claim coupon: %v", err)
+		}
+	}
+
+	details, err := service.GetCouponDetails(ctx, couponName, false)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+	if details.ClaimedCount != claims {
+		t.Errorf("Expected claimed_count %d, got %d", claims, details.ClaimedCount)
+	}
+	if len(details.ClaimedBy) != 0 {
+		t.Errorf("Expected claimed_by to be omitted by default, got %d entries", len(details.ClaimedBy))
+	}
+
+	var users []string
+	cursor := ""
+	for {
+		page, err := service.ListCouponClaims(ctx, ListCouponClaimsRequest{
+			CouponName: couponName,
+			Cursor:     cursor,
+			Limit:      3,
+		})
+		if err != nil {
+			t.Fatalf("Failed to list coupon claims: %v", err)
+		}
+		for _, claim := range page.Claims {
+			users = append(users, claim.UserID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if len(users) != claims {
+		t.Fatalf("Expected %d claims, got %d", claims, len(users))
+	}
+	for i, userID := range users {
+		if userID != fmt.Sprintf("user_%d", i) {
+			t.Errorf("Claim %d: expected user_%d in claim order, got %s", i, i, userID)
+		}
+	}
+
+	_, err = service.ListCouponClaims(ctx, ListCouponClaimsRequest{CouponName: "MISSING"})
+	if !errors.Is(err, ErrCouponNotFound) {
+		t.Errorf("Expected %v, got %v", ErrCouponNotFound, err)
+	}
+}
+
+func TestStockReservations(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+
+	couponName := "CHECKOUT_HOLD"
+	err := service.CreateCoupon(ctx, CreateCouponRequest{
+		Name:   couponName,
+		Amount: 3,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+
+	first, err := service.CreateReservation(ctx, couponName, CreateReservationRequest{UserId: "user_1"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	second, err := service.CreateReservation(ctx, couponName, CreateReservationRequest{UserId: "user_2"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+
+	_, err = service.CreateReservation(ctx, couponName, CreateReservationRequest{UserId: "user_1"}, time.Minute)
+	if !errors.Is(err, ErrClaimLimitReached) {
+		t.Errorf("Expected an active hold to count towards the claim limit, got %v", err)
+	}
+
+	details, err := service.GetCouponDetails(ctx, couponName, false)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+	if details.ReservedCount != 2 || details.RemainingAmount != 1 {
+		t.Errorf("Expected 2 reserved and 1 remaining, got %d reserved and %d remaining",
+			details.ReservedCount, details.RemainingAmount)
+	}
+
+	if err := service.ClaimCoupon(ctx, ClaimCouponRequest{UserId: "user_3", CouponName: couponName}); err != nil {
+		t.Fatalf("Failed to claim the last free unit: %v", err)
+	}
+	err = service.ClaimCoupon(ctx, ClaimCouponRequest{UserId: "user_4", CouponName: couponName})
+	if !errors.Is(err, ErrCouponOutOfStock) {
+		t.Errorf("Expected held stock to be unavailable to claims, got %v", err)
+	}
+
+	confirmed, err := service.ConfirmReservation(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("Failed to confirm reservation: %v", err)
+	}
+	if confirmed.Status != string(ReservationStatusConfirmed) || confirmed.ClaimID == nil {
+		t.Errorf("Expected a confirmed reservation with a claim, got %+v", confirmed)
+	}
+
+	again, err := service.ConfirmReservation(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("Failed to repeat confirm: %v", err)
+	}
+	if again.ClaimID == nil || *again.ClaimID != *confirmed.ClaimID {
+		t.Errorf("Expected repeated confirm to return the same claim")
+	}
+
+	if _, err := service.CancelReservation(ctx, first.ID); !errors.Is(err, ErrReservationNotActive) {
+		t.Errorf("Expected cancelling a confirmed reservation to fail, got %v", err)
+	}
+
+	if _, err := service.CancelReservation(ctx, second.ID); err != nil {
+		t.Fatalf("Failed to cancel reservation: %v", err)
+	}
+
+	details, err = service.GetCouponDetails(ctx, couponName, false)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+	if details.ClaimedCount != 2 || details.ReservedCount != 0 || details.RemainingAmount != 1 {
+		t.Errorf("Expected 2 claimed, 0 reserved and 1 remaining, got %d, %d and %d",
+			details.ClaimedCount, details.ReservedCount, details.RemainingAmount)
+	}
+
+	short, err := service.CreateReservation(ctx, couponName, CreateReservationRequest{UserId: "user_5"}, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	NewSweeper(repo, logger, time.Second).Sweep(ctx)
+
+	expired, err := service.GetReservation(ctx, short.ID)
+	if err != nil {
+		t.Fatalf("Failed to get reservation: %v", err)
+	}
+	if expired.Status != string(ReservationStatusExpired) {
+		t.Errorf("Expected the sweeper to expire the reservation, got %s", expired.Status)
+	}
+	if _, err := service.ConfirmReservation(ctx, short.ID); !errors.Is(err, ErrReservationExpired) {
+		t.Errorf("Expected confirming an expired reservation to fail, got %v", err)
+	}
+
+	details, err = service.GetCouponDetails(ctx, couponName, false)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+	if details.ReservedCount != 0 || details.RemainingAmount != 1 {
+		t.Errorf("Expected expired stock back on sale, got %d reserved and %d remaining",
+			details.ReservedCount, details.RemainingAmount)
+	}
+}
+
+func TestReservationsAndClaimsNeverOversell(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+
+	couponName := "MIXED_CHECKOUT"
+	stockAmount := 20
+	concurrentRequests := 200
+
+	err := service.CreateCoupon(ctx, CreateCouponRequest{
+		Name:   couponName,
+		Amount: stockAmount,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var reservationIDs []string
+	claims := 0
+
+	for i := 0; i < concurrentRequests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			userID := fmt.Sprintf("user_%d", i)
+			if i%2 == 0 {
+				resp, err := service.CreateReservation(ctx, couponName, CreateReservationRequest{UserId: userID}, time.Minute)
+				if err != nil {
+					if !errors.Is(err, ErrCouponOutOfStock) {
+						t.Errorf("Unexpected reservation error: %v", err)
+					}
+					return
+				}
+				mu.Lock()
+				reservationIDs = append(reservationIDs, resp.ID)
+				mu.Unlock()
+				return
+			}
+
+			err := service.ClaimCoupon(ctx, ClaimCouponRequest{UserId: userID, CouponName: couponName})
+			if err != nil {
+				if !errors.Is(err, ErrCouponOutOfStock) {
+					t.Errorf("Unexpected claim error: %v", err)
+				}
+				return
+			}
+			mu.Lock()
+			claims++
+			mu.Unlock()
+		}(i)
+	}
+
+	wg.Wait()
+
+	if len(reservationIDs)+claims != stockAmount {
+		t.Errorf("Expected exactly %d units taken, got %d reservations and %d claims",
+			stockAmount, len(reservationIDs), claims)
+	}
+
+	for _, id := range reservationIDs {
+		if _, err := service.ConfirmReservation(ctx, id); err != nil {
+			t.Errorf("Failed to confirm reservation %s: %v", id, err)
+		}
+	}
+
+	details, err := service.GetCouponDetails(ctx, couponName, false)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+	if details.ClaimedCount != stockAmount || details.ReservedCount != 0 || details.RemainingAmount != 0 {
+		t.Errorf("Expected %d claimed and nothing reserved or remaining, got %d, %d and %d",
+			stockAmount, details.ClaimedCount, details.ReservedCount, details.RemainingAmount)
+	}
+}
+
+func TestWaitlistPromotion(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	ctx := context.Background()
+
+	couponName := "SOLD_OUT_QUEUE"
+	err := service.CreateCoupon(ctx, CreateCouponRequest{
+		Name:   couponName,
+		Amount: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+
+	join := func(userID string) WaitlistEntryResponse {
+		t.Helper()
+		entry, err := service.JoinWaitlist(ctx, couponName, JoinWaitlistRequest{UserId: userID})
+		if err != nil {
+			t.Fatalf("Failed to join waitlist as %s: %v", userID, err)
+		}
+		return entry
+	}
+	entry := func(userID string) WaitlistEntryResponse {
+		t.Helper()
+		entry, err := service.GetWaitlistEntry(ctx, couponName, userID)
+		if err != nil {
+			t.Fatalf("Failed to get waitlist entry for %s: %v", userID, err)
+		}
+		return entry
+	}
+
+	_, err = service.JoinWaitlist(ctx, couponName, JoinWaitlistRequest{UserId: "user_early"})
+	if !errors.Is(err, ErrCouponInStock) {
+		t.Errorf("Expected joining an in-stock coupon to fail, got %v", err)
+	}
+
+	if err := service.ClaimCoupon(ctx, ClaimCouponRequest{UserId: "user_1", CouponName: couponName}); err != nil {
+		t.Fatalf("Failed to claim: %v", err)
+	}
+	reservation, err := service.CreateReservation(ctx, couponName, CreateReservationRequest{UserId: "user_2"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+
+	for i, userID := range []string{"user_3", "user_4", "user_5", "user_6"} {
+		if got := join(userID).Position; got != i+1 {
+			t.Errorf("Expected %s at position %d, got %d", userID, i+1, got)
+		}
+	}
+	if _, err := service.JoinWaitlist(ctx, couponName, JoinWaitlistRequest{UserId: "user_3"}); !errors.Is(err, ErrAlreadyOnWaitlist) {
+		t.Errorf("Expected a second join to fail, got %v", err)
+	}
+
+	if err := service.LeaveWaitlist(ctx, couponName, "user_4"); err != nil {
+		t.Fatalf("Failed to leave waitlist: %v", err)
+	}
+	if got := entry("user_5").Position; got != 2 {
+		t.Errorf("Expected user_5 to move up to position 2, got %d", got)
+	}
+
+	if err := service.ReleaseClaim(ctx, couponName, "user_1"); err != nil {
+		t.Fatalf("Failed to release claim: %v", err)
+	}
+	if e := entry("user_3"); e.Status != string(WaitlistStatusPromoted) || e.ClaimID == nil {
+		t.Errorf("Expected user_3 promoted by the release, got %+v", e)
+	}
+	if got := entry("user_5").Position; got != 1 {
+		t.Errorf("Expected user_5 at the head of the queue, got %d", got)
+	}
+
+	if _, err := service.CancelReservation(ctx, reservation.ID); err != nil {
+		t.Fatalf("Failed to cancel reservation: %v", err)
+	}
+	if e := entry("user_5"); e.Status != string(WaitlistStatusPromoted) {
+		t.Errorf("Expected user_5 promoted by the cancelled reservation, got %s", e.Status)
+	}
+
+	details, err := service.GetCouponDetails(ctx, couponName, false)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+	amount := 3
+	if _, err := service.UpdateCoupon(ctx, couponName, details.Version, UpdateCouponRequest{Amount: &amount}); err != nil {
+		t.Fatalf("Failed to raise amount: %v", err)
+	}
+	if e := entry("user_6"); e.Status != string(WaitlistStatusPromoted) {
+		t.Errorf("Expected user_6 promoted by the stock increase, got %s", e.Status)
+	}
+
+	details, err = service.GetCouponDetails(ctx, couponName, true)
+	if err != nil {
+		t.Fatalf("Failed to get coupon details: %v", err)
+	}
+	if details.ClaimedCount != 3 || details.RemainingAmount != 0 {
+		t.Errorf("Expected 3 claimed and nothing remaining, got %d and %d", details.ClaimedCount, details.RemainingAmount)
+	}
+	if e := entry("user_4"); e.Status != string(WaitlistStatusLeft) {
+		t.Errorf("Expected user_4 to have left the waitlist, got %s", e.Status)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	service := NewService(repo, logger)
+
+	brandA := WithTenant(context.Background(), "brand_a")
+	brandB := WithTenant(context.Background(), "brand_b")
+	brandC := WithTenant(context.Background(), "brand_c")
+
+	couponName := "WELCOME10"
+	if err := service.CreateCoupon(brandA, CreateCouponRequest{Name: couponName, Amount: 1}); err != nil {
+		t.Fatalf("Failed to create coupon for brand_a: %v", err)
+	}
+	if err := service.CreateCoupon(brandB, CreateCouponRequest{Name: couponName, Amount: 2}); err != nil {
+		t.Fatalf("Expected brand_b to create a coupon with the same name, got %v", err)
+	}
+
+	if err := service.ClaimCoupon(brandA, ClaimCouponRequest{UserId: "user_1", CouponName: couponName}); err != nil {
+		t.Fatalf("Failed to claim brand_a coupon: %v", err)
+	}
+	if err := service.ClaimCoupon(brandA, ClaimCouponRequest{UserId: "user_2", CouponName: couponName}); !errors.Is(err, ErrCouponOutOfStock) {
+		t.Errorf("Expected brand_a coupon to be sold out, got %v", err)
+	}
+	if err := service.ClaimCoupon(brandB, ClaimCouponRequest{UserId: "user_1", CouponName: couponName}); err != nil {
+		t.Errorf("Expected brand_b stock and claim limit to be unaffected by brand_a, got %v", err)
+	}
+
+	details, err := service.GetCouponDetails(brandB, couponName, true)
+	if err != nil {
+		t.Fatalf("Failed to get brand_b coupon: %v", err)
+	}
+	if details.Amount != 2 || details.ClaimedCount != 1 || len(details.ClaimedBy) != 1 {
+		t.Errorf("Expected brand_b to see only its own coupon and claim, got %+v", details)
+	}
+
+	if _, err := service.GetCouponDetails(brandC, couponName, false); !errors.Is(err, ErrCouponNotFound) {
+		t.Errorf("Expected brand_c not to read another tenant's coupon, got %v", err)
+	}
+	if err := service.ClaimCoupon(brandC, ClaimCouponRequest{UserId: "user_3", CouponName: couponName}); !errors.Is(err, ErrCouponNotFound) {
+		t.Errorf("Expected brand_c not to claim another tenant's coupon, got %v", err)
+	}
+	if _, err := service.CreateReservation(brandC, couponName, CreateReservationRequest{UserId: "user_3"}, time.Minute); !errors.Is(err, ErrCouponNotFound) {
+		t.Errorf("Expected brand_c not to reserve another tenant's coupon, got %v", err)
+	}
+	if err := service.ReleaseClaim(brandC, couponName, "user_1"); !errors.Is(err, ErrCouponNotFound) {
+		t.Errorf("Expected brand_c not to release another tenant's claim, got %v", err)
+	}
+
+	listed, err := service.ListCoupons(brandC, ListCouponsRequest{})
+	if err != nil {
+		t.Fatalf("Failed to list coupons: %v", err)
+	}
+	if len(listed.Coupons) != 0 {
+		t.Errorf("Expected brand_c to list no coupons, got %d", len(listed.Coupons))
+	}
+
+	claims, err := service.ListUserClaims(brandA, ListUserClaimsRequest{UserID: "user_1"})
+	if err != nil {
+		t.Fatalf("Failed to list user claims: %v", err)
+	}
+	if len(claims.Claims) != 1 || claims.Claims[0].Coupon.Amount != 1 {
+		t.Errorf("Expected brand_a to see only its own claim for user_1, got %+v", claims.Claims)
+	}
+
+	reservation, err := service.CreateReservation(brandB, couponName, CreateReservationRequest{UserId: "user_2"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to reserve brand_b coupon: %v", err)
+	}
+	if _, err := service.GetReservation(brandA, reservation.ID); !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("Expected brand_a not to read brand_b's reservation, got %v", err)
+	}
+	if _, err := service.ConfirmReservation(brandA, reservation.ID); !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("Expected brand_a not to confirm brand_b's reservation, got %v", err)
+	}
+}
+
+func TestInputValidation(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	service := NewService(NewMemoryRepository(logger), logger)
+	ctx := context.Background()
+
+	err := service.CreateCoupon(ctx, CreateCouponRequest{Name: "", Amount: 0, MaxClaimsPerUser: -1})
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || !errors.Is(err, ErrValidationFailed) {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	fields := map[string]bool{}
+	for _, f := range invalid.Fields {
+		fields[f.Field] = true
+	}
+	if !fields["name"] || !fields["amount"] || !fields["max_claims_per_user"] || len(fields) != 3 {
+		t.Errorf("Expected errors for name, amount and max_claims_per_user in one response, got %+v", invalid.Fields)
+	}
+
+	if err := service.CreateCoupon(ctx, CreateCouponRequest{Name: strings.Repeat("A", MaxCouponNameLength+1), Amount: 1}); !errors.Is(err, ErrValidationFailed) {
+		t.Errorf("Expected an over-long name to be rejected, got %v", err)
+	}
+	if err := service.CreateCoupon(ctx, CreateCouponRequest{Name: "PROMO SUPER", Amount: 1}); !errors.Is(err, ErrValidationFailed) {
+		t.Errorf("Expected a name with a space to be rejected, got %v", err)
+	}
+	if err := service.CreateCoupon(ctx, CreateCouponRequest{Name: "PROMO_VALID", Amount: 1}); err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+	if err := service.ClaimCoupon(ctx, ClaimCouponRequest{UserId: "", CouponName: "PROMO_VALID"}); !errors.Is(err, ErrValidationFailed) {
+		t.Errorf("Expected an empty user_id to be rejected, got %v", err)
+	}
+}
+
+// TestInputCheckConstraints checks that the CHECK constraints stop bad rows
+// that bypass the service's validation.
+func TestInputCheckConstraints(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer cleanupTestDB(t, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewRepository(db, logger)
+	ctx := context.Background()
+
+	if err := repo.InsertCoupon(ctx, Coupons{Name: "PROMO_ZERO", Amount: 0, MaxClaimsPerUser: 1}); err == nil {
+		t.Errorf("Expected the database to reject a zero amount")
+	}
+	if err := repo.InsertCoupon(ctx, Coupons{Name: "bad name", Amount: 1, MaxClaimsPerUser: 1}); err == nil {
+		t.Errorf("Expected the database to reject an invalid name")
+	}
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	migrator, err := migrate.New(db, logger, migration.FS)
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	ctx := context.Background()
+
+	relationExists := func(name string) bool {
+		var exists bool
+		if err := db.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists); err != nil {
+			t.Fatalf("Failed to look up %s: %v", name, err)
+		}
+		return exists
+	}
+	// The hand-written test schema this replaced lacked these.
+	for _, index := range []string{"idx_claim_history_tenant_coupon_user", "idx_reservations_active_expires_at", "idx_waitlist_tenant_waiting_queue"} {
+		if !relationExists(index) {
+			t.Errorf("Expected index %s after migrating up", index)
+		}
+	}
+
+	if count, err := migrator.Up(ctx); err != nil || count != 0 {
+		t.Errorf("Expected a second up to apply nothing, got %d, %v", count, err)
+	}
+
+	// Every down migration must run cleanly, and up must rebuild from there.
+	if _, err := migrator.Down(ctx, migrator.Latest()); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	if relationExists("coupons") {
+		t.Errorf("Expected the coupons table to be gone after migrating down")
+	}
+	// Only versions that exist are recorded, so down accounts for all of them.
+	var recorded int
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM schema_migrations`).Scan(&recorded); err != nil {
+		t.Fatalf("Failed to count recorded migrations: %v", err)
+	}
+	if recorded != 0 {
+		t.Errorf("Expected no recorded migrations after migrating down, got %d", recorded)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Failed to migrate up again: %v", err)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read migration status: %v", err)
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Errorf("Expected migration %03d_%s to be applied", status.Version, status.Name)
+		}
+	}
+	if statuses[len(statuses)-1].Version != SchemaVersion {
+		t.Errorf("Expected the latest migration to be %d, got %d", SchemaVersion, statuses[len(statuses)-1].Version)
+	}
+
+	// Readiness needs every migration, not just the newest one.
+	checker := health.NewChecker(db, logger, migrator.Versions(SchemaVersion))
+	ready := func() (int, health.Response) {
+		rec := httptest.NewRecorder()
+		checker.Readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp health.Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Expected a JSON body, got %q: %v", rec.Body, err)
+		}
+		return rec.Code, resp
+	}
+	if code, resp := ready(); code != http.StatusOK {
+		t.Errorf("Expected a fully migrated schema to be ready, got %d %+v", code, resp)
+	}
+	if _, err := db.Exec(ctx, `DELETE FROM schema_migrations WHERE version = 3`); err != nil {
+		t.Fatalf("Failed to forget migration: %v", err)
+	}
+	if code, resp := ready(); code != http.StatusServiceUnavailable || resp.Checks["schema"] != "schema missing migrations 3" {
+		t.Errorf("Expected a missing lower migration to fail readiness, got %d %+v", code, resp)
+	}
+	if _, err := db.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES (3)`); err != nil {
+		t.Fatalf("Failed to restore migration: %v", err)
+	}
+}
+
+func TestEventDispatch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	repo := NewMemoryRepository(logger)
+	service := NewService(repo, logger)
+	ctx := context.Background()
+
+	if err := service.CreateCoupon(ctx, CreateCouponRequest{Name: "PROMO_EVENTS", Amount: 1}); err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+	if err := service.ClaimCoupon(ctx, ClaimCouponRequest{UserId: "user_1", CouponName: "PROMO_EVENTS"}); err != nil {
+		t.Fatalf("Failed to claim coupon: %v", err)
+	}
+
+	// The webhook is down for the first batch.
+	var mu sync.Mutex
+	var received []string
+	down := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil || r.Header.Get(EventIDHeader) != fmt.Sprint(event.ID) {
+			t.Errorf("Expected the event id header to match the body, got %s and %+v (%v)", r.Header.Get(EventIDHeader), event, err)
+		}
+		received = append(received, r.Header.Get(EventTypeHeader))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	fileSink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("Failed to open file sink: %v", err)
+	}
+	defer fileSink.Close()
+
+	dispatcher := NewDispatcher(repo, []Sink{fileSink, NewHTTPSink(server.URL, nil)}, logger, time.Second)
+	if leased := dispatcher.Dispatch(ctx); leased != 3 {
+		t.Fatalf("Expected created, claimed and sold_out events, got %d", leased)
+	}
+	if leased := dispatcher.Dispatch(ctx); leased != 0 {
+		t.Errorf("Expected failed events to wait for their retry, got %d", leased)
+	}
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	time.Sleep(1100 * time.Millisecond)
+
+	if leased := dispatcher.Dispatch(ctx); leased != 3 {
+		t.Fatalf("Expected all 3 events to be retried, got %d", leased)
+	}
+	if leased := dispatcher.Dispatch(ctx); leased != 0 {
+		t.Errorf("Expected delivered events not to be sent again, got %d", leased)
+	}
+	if fmt.Sprint(received) != "[coupon.created coupon.claimed coupon.sold_out]" {
+		t.Errorf("Expected the webhook to get every event in order, got %v", received)
+	}
+
+	// The file sink accepted the first attempt, so the retry skipped it.
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open event file: %v", err)
+	}
+	defer file.Close()
+	var lines []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Expected one JSON event per line, got %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, event)
+	}
+	if len(lines) != 3 || lines[0].Type != EventCouponCreated || lines[2].Type != EventCouponSoldOut {
+		t.Fatalf("Expected each event written once, got %+v", lines)
+	}
+	var claimed CouponClaimedData
+	if err := json.Unmarshal(lines[1].Data, &claimed); err != nil || claimed.UserID != "user_1" || claimed.RemainingAmount != 0 {
+		t.Errorf("Expected the claimed event to carry the claim, got %+v, %v", claimed, err)
+	}
+
+	if purged, err := repo.PurgeDeliveredEvents(ctx, DefaultEventRetention); err != nil || purged != 0 {
+		t.Errorf("Expected recent events to be kept, got %d purged, %v", purged, err)
+	}
+}
@@ -0,0 +1,359 @@
+package coupon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ClaimStrategy takes one unit of a coupon's stock inside a claim transaction.
+// Every implementation must leave the coupon row locked when it returns
+// successfully, because ClaimCoupon then counts the user's claims and relies
+// on that lock to serialise claims on the same coupon.
+type ClaimStrategy interface {
+	Name() string
+	ReserveStock(ctx context.Context, tx pgx.Tx, couponName string) (CouponStock, error)
+}
+
+// CouponStock is a coupon's stock as the statement that changed it left it,
+// read back with RETURNING so the claim needs no further query.
+type CouponStock struct {
+	Amount           int
+	Claimed          int
+	Reserved         int
+	MaxClaimsPerUser int
+}
+
+// Remaining is the stock neither claimed nor held by a reservation.
+func (s CouponStock) Remaining() int {
+	return s.Amount - s.Claimed - s.Reserved
+}
+
+const (
+	StrategyPessimistic = "pessimistic"
+	StrategyAtomic      = "atomic"
+	StrategyOptimistic  = "optimistic"
+	StrategyAdvisory    = "advisory"
+)
+
+// ClaimStrategies lists every strategy name NewClaimStrategy accepts.
+var ClaimStrategies = []string{
+	StrategyPessimistic,
+	StrategyAtomic,
+	StrategyOptimistic,
+	StrategyAdvisory,
+}
+
+// NewClaimStrategy builds the strategy configured for this deployment. An
+// empty name selects the atomic strategy.
+func NewClaimStrategy(name string, log *slog.Logger) (ClaimStrategy, error) {
+	switch name {
+	case StrategyPessimistic:
+		return NewPessimisticStrategy(log), nil
+	case "", StrategyAtomic:
+		return NewAtomicStrategy(log), nil
+	case StrategyOptimistic:
+		return NewOptimisticStrategy(log), nil
+	case StrategyAdvisory:
+		return NewAdvisoryStrategy(log), nil
+	default:
+		return nil, fmt.Errorf("unknown claim strategy %q", name)
+	}
+}
+
+// PessimisticStrategy locks the coupon row with SELECT ... FOR UPDATE, checks
+// the stock in Go and then bumps claimed_count.
+type PessimisticStrategy struct {
+	log *slog.Logger
+}
+
+func NewPessimisticStrategy(log *slog.Logger) *PessimisticStrategy {
+	return &PessimisticStrategy{log: log}
+}
+
+func (s *PessimisticStrategy) Name() string { return StrategyPessimistic }
+
+func (s *PessimisticStrategy) ReserveStock(
+	ctx context.Context,
+	tx pgx.Tx,
+	couponName string,
+) (CouponStock, error) {
+	var stock CouponStock
+	state, err := readStockState(ctx, tx, s.log, couponName, true)
+	if err != nil {
+		return stock, err
+	}
+	if err := state.rejection(s.log, couponName); err != nil {
+		return stock, err
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE coupons
+		SET claimed_count = claimed_count + 1
+		WHERE tenant_id = $1 AND name = $2
+		RETURNING amount, claimed_count, reserved_count, max_claims_per_user
+	`, TenantFromContext(ctx), couponName).Scan(&stock.Amount, &stock.Claimed, &stock.Reserved, &stock.MaxClaimsPerUser)
+	if err != nil {
+		s.log.Error("failed to reserve stock", "coupon_name", couponName, "error", err)
+		return stock, err
+	}
+
+	return stock, nil
+}
+
+// AtomicStrategy reserves stock with a single conditional UPDATE. The update
+// takes the row lock, and concurrent claims queue on it and re-check the
+// condition against the committed claimed_count.
+type AtomicStrategy struct {
+	log *slog.Logger
+}
+
+func NewAtomicStrategy(log *slog.Logger) *AtomicStrategy {
+	return &AtomicStrategy{log: log}
+}
+
+func (s *AtomicStrategy) Name() string { return StrategyAtomic }
+
+func (s *AtomicStrategy) ReserveStock(
+	ctx context.Context,
+	tx pgx.Tx,
+	couponName string,
+) (CouponStock, error) {
+	var stock CouponStock
+	err := tx.QueryRow(ctx, `
+		UPDATE coupons
+		SET claimed_count = claimed_count + 1
+		WHERE tenant_id = $1 AND name = $2
+			AND claimed_count + reserved_count < amount
+			AND COALESCE(starts_at <= now(), true)
+			AND COALESCE(ends_at > now(), true)
+		RETURNING amount, claimed_count, reserved_count, max_claims_per_user
+	`, TenantFromContext(ctx), couponName).Scan(&stock.Amount, &stock.Claimed, &stock.Reserved, &stock.MaxClaimsPerUser)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return stock, claimRejection(ctx, tx, s.log, couponName)
+		}
+		s.log.Error("failed to reserve stock", "coupon_name", couponName, "error", err)
+		return stock, err
+	}
+
+	return stock, nil
+}
+
+// maxOptimisticAttempts bounds how often OptimisticStrategy re-reads the
+// coupon before giving up with ErrClaimContention.
+const maxOptimisticAttempts = 32
+
+// OptimisticStrategy reads the coupon without locking it and then bumps
+// claimed_count only if the stock counters and the version still hold the
+// values that were read, retrying on a miss. Every stock change goes through
+// the counters and every PATCH bumps the version, so an unchanged row means
+// the read is current.
+type OptimisticStrategy struct {
+	log *slog.Logger
+}
+
+func NewOptimisticStrategy(log *slog.Logger) *OptimisticStrategy {
+	return &OptimisticStrategy{log: log}
+}
+
+func (s *OptimisticStrategy) Name() string { return StrategyOptimistic }
+
+func (s *OptimisticStrategy) ReserveStock(
+	ctx context.Context,
+	tx pgx.Tx,
+	couponName string,
+) (CouponStock, error) {
+	var stock CouponStock
+	for attempt := 1; attempt <= maxOptimisticAttempts; attempt++ {
+		state, err := readStockState(ctx, tx, s.log, couponName, false)
+		if err != nil {
+			return stock, err
+		}
+		if err := state.rejection(s.log, couponName); err != nil {
+			return stock, err
+		}
+
+		err = tx.QueryRow(ctx, `
+			UPDATE coupons
+			SET claimed_count = claimed_count + 1
+			WHERE tenant_id = $1 AND name = $2
+				AND claimed_count = $3
+				AND reserved_count = $4
+				AND version = $5
+				AND claimed_count + reserved_count < amount
+				AND COALESCE(starts_at <= now(), true)
+				AND COALESCE(ends_at > now(), true)
+			RETURNING amount, claimed_count, reserved_count, max_claims_per_user
+		`, TenantFromContext(ctx), couponName, state.claimed, state.reserved, state.version).Scan(&stock.Amount, &stock.Claimed, &stock.Reserved, &stock.MaxClaimsPerUser)
+		if err == nil {
+			return stock, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			s.log.Error("failed to reserve stock", "coupon_name", couponName, "error", err)
+			return stock, err
+		}
+
+		s.log.Info("optimistic claim lost race, retrying", "coupon_name", couponName, "attempt", attempt)
+	}
+
+	s.log.Warn("optimistic claim gave up", "coupon_name", couponName, "attempts", maxOptimisticAttempts)
+	return stock, ErrClaimContention
+}
+
+// AdvisoryStrategy serialises claims on a coupon with a transaction-scoped
+// Postgres advisory lock keyed on the tenant and coupon name, then reads and
+// bumps the row. The bump re-checks the stock and the validity window because
+// releases and updates lock the row rather than the advisory key.
+type AdvisoryStrategy struct {
+	log *slog.Logger
+}
+
+func NewAdvisoryStrategy(log *slog.Logger) *AdvisoryStrategy {
+	return &AdvisoryStrategy{log: log}
+}
+
+func (s *AdvisoryStrategy) Name() string { return StrategyAdvisory }
+
+func (s *AdvisoryStrategy) ReserveStock(
+	ctx context.Context,
+	tx pgx.Tx,
+	couponName string,
+) (CouponStock, error) {
+	var stock CouponStock
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1::text || '/' || $2::text, 0))`, TenantFromContext(ctx), couponName)
+	if err != nil {
+		s.log.Error("failed to take advisory lock", "coupon_name", couponName, "error", err)
+		return stock, err
+	}
+
+	state, err := readStockState(ctx, tx, s.log, couponName, false)
+	if err != nil {
+		return stock, err
+	}
+	if err := state.rejection(s.log, couponName); err != nil {
+		return stock, err
+	}
+
+	// max_claims_per_user comes from the update, not the read above, so a
+	// PATCH that committed in between is honoured.
+	err = tx.QueryRow(ctx, `
+		UPDATE coupons
+		SET claimed_count = claimed_count + 1
+		WHERE tenant_id = $1 AND name = $2
+			AND claimed_count + reserved_count < amount
+			AND COALESCE(starts_at <= now(), true)
+			AND COALESCE(ends_at > now(), true)
+		RETURNING amount, claimed_count, reserved_count, max_claims_per_user
+	`, TenantFromContext(ctx), couponName).Scan(&stock.Amount, &stock.Claimed, &stock.Reserved, &stock.MaxClaimsPerUser)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return stock, claimRejection(ctx, tx, s.log, couponName)
+		}
+		s.log.Error("failed to reserve stock", "coupon_name", couponName, "error", err)
+		return stock, err
+	}
+
+	return stock, nil
+}
+
+// stockState is the part of a coupon row a claim decision depends on.
+type stockState struct {
+	amount           int
+	claimed          int
+	reserved         int
+	maxClaimsPerUser int
+	version          int64
+	notYetActive     bool
+	expired          bool
+}
+
+func readStockState(
+	ctx context.Context,
+	tx pgx.Tx,
+	log *slog.Logger,
+	couponName string,
+	forUpdate bool,
+) (stockState, error) {
+	query := `
+		SELECT
+			amount,
+			claimed_count,
+			reserved_count,
+			max_claims_per_user,
+			version,
+			COALESCE(starts_at > now(), false),
+			COALESCE(ends_at <= now(), false)
+		FROM coupons
+		WHERE tenant_id = $1 AND name = $2`
+	if forUpdate {
+		query += `
+		FOR UPDATE`
+	}
+
+	var state stockState
+	err := tx.QueryRow(ctx, query, TenantFromContext(ctx), couponName).Scan(
+		&state.amount,
+		&state.claimed,
+		&state.reserved,
+		&state.maxClaimsPerUser,
+		&state.version,
+		&state.notYetActive,
+		&state.expired,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Warn("coupon not found", "coupon_name", couponName)
+			return state, ErrCouponNotFound
+		}
+		log.Error("failed to check coupon", "coupon_name", couponName, "error", err)
+		return state, err
+	}
+
+	return state, nil
+}
+
+// rejection returns why a claim cannot take stock from this state, or nil.
+func (s stockState) rejection(log *slog.Logger, couponName string) error {
+	switch {
+	case s.notYetActive:
+		log.Warn("coupon not yet active", "coupon_name", couponName)
+		return ErrCouponNotYetActive
+	case s.expired:
+		log.Warn("coupon expired", "coupon_name", couponName)
+		return ErrCouponExpired
+	case s.claimed+s.reserved >= s.amount:
+		log.Warn("coupon out of stock", "coupon_name", couponName, "amount", s.amount, "claimed", s.claimed, "reserved", s.reserved)
+		return ErrCouponOutOfStock
+	default:
+		return nil
+	}
+}
+
+// claimable reports whether a claim could take stock from this state.
+func (s stockState) claimable() bool {
+	return !s.notYetActive && !s.expired && s.claimed+s.reserved < s.amount
+}
+
+// claimRejection explains why a conditional stock update matched no row.
+func claimRejection(
+	ctx context.Context,
+	tx pgx.Tx,
+	log *slog.Logger,
+	couponName string,
+) error {
+	state, err := readStockState(ctx, tx, log, couponName, false)
+	if err != nil {
+		return err
+	}
+	if err := state.rejection(log, couponName); err != nil {
+		return err
+	}
+
+	// The row changed back between the update and this read; report the
+	// outcome the update saw.
+	return ErrCouponOutOfStock
+}
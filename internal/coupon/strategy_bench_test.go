@@ -0,0 +1,104 @@
+package coupon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BenchmarkClaimStrategies runs a TestFlashSaleAttack-style workload against
+// every claim strategy: each iteration creates a coupon and fires
+// flashSaleRequests concurrent claims from distinct users at it.
+//
+//	go test ./internal/coupon/... -run '^$' -bench BenchmarkClaimStrategies
+func BenchmarkClaimStrategies(b *testing.B) {
+	const (
+		flashSaleStock    = 100
+		flashSaleRequests = 500
+	)
+
+	db := setupTestDB(b)
+	defer db.Close()
+	defer cleanupTestDB(b, db)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	ctx := context.Background()
+
+	for _, name := range ClaimStrategies {
+		b.Run(name, func(b *testing.B) {
+			strategy, err := NewClaimStrategy(name, logger)
+			if err != nil {
+				b.Fatalf("Failed to build strategy: %v", err)
+			}
+			repo := NewRepositoryWithStrategy(db, logger, strategy)
+			service := NewService(repo, logger)
+
+			var mu sync.Mutex
+			latencies := make([]time.Duration, 0, b.N*flashSaleRequests)
+			claimed := 0
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				couponName := fmt.Sprintf("BENCH_%s_%d", name, i)
+				err := service.CreateCoupon(ctx, CreateCouponRequest{
+					Name:   couponName,
+					Amount: flashSaleStock,
+				})
+				if err != nil {
+					b.Fatalf("Failed to create coupon: %v", err)
+				}
+				b.StartTimer()
+
+				var wg sync.WaitGroup
+				successCount := 0
+				for u := 0; u < flashSaleRequests; u++ {
+					wg.Add(1)
+					go func(userID int) {
+						defer wg.Done()
+
+						start := time.Now()
+						err := service.ClaimCoupon(ctx, ClaimCouponRequest{
+							UserId:     fmt.Sprintf("user_%d", userID),
+							CouponName: couponName,
+						})
+						elapsed := time.Since(start)
+
+						mu.Lock()
+						latencies = append(latencies, elapsed)
+						if err == nil {
+							successCount++
+						}
+						mu.Unlock()
+					}(u)
+				}
+				wg.Wait()
+
+				if successCount != flashSaleStock {
+					b.Fatalf("Expected exactly %d successful claims, got %d", flashSaleStock, successCount)
+				}
+				claimed += successCount
+			}
+			b.StopTimer()
+
+			seconds := b.Elapsed().Seconds()
+			b.ReportMetric(float64(len(latencies))/seconds, "requests/s")
+			b.ReportMetric(float64(claimed)/seconds, "claims/s")
+			b.ReportMetric(percentile(latencies, 0.99).Seconds()*1000, "p99-ms")
+		})
+	}
+}
+
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
@@ -0,0 +1,89 @@
+package coupon
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	DefaultSweepInterval  = 10 * time.Second
+	DefaultEventRetention = 7 * 24 * time.Hour
+	sweepMaxCoupons       = 100
+)
+
+// Sweeper periodically returns expired reservations to stock, deletes
+// idempotency keys whose TTL has passed and purges delivered outbox events
+// older than the event retention.
+type Sweeper struct {
+	repo           Repository
+	log            *slog.Logger
+	interval       time.Duration
+	eventRetention time.Duration
+}
+
+func NewSweeper(repo Repository,
+	log *slog.Logger,
+	interval time.Duration,
+) *Sweeper {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	return &Sweeper{
+		repo:           repo,
+		log:            log,
+		interval:       interval,
+		eventRetention: DefaultEventRetention,
+	}
+}
+
+// WithEventRetention keeps delivered events for retention instead of
+// DefaultEventRetention and returns s.
+func (s *Sweeper) WithEventRetention(retention time.Duration) *Sweeper {
+	if retention > 0 {
+		s.eventRetention = retention
+	}
+	return s
+}
+
+// Run sweeps every interval until ctx is cancelled.
+func (s *Sweeper) Run(ctx context.Context) {
+	s.log.Info("sweeper started", "interval", s.interval)
+	defer s.log.Info("sweeper stopped")
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep(ctx)
+		}
+	}
+}
+
+// Sweep runs one pass. Failures are logged and retried on the next pass.
+func (s *Sweeper) Sweep(ctx context.Context) {
+	expired, err := s.repo.ExpireReservations(ctx, sweepMaxCoupons)
+	if err != nil {
+		s.log.Error("failed to expire reservations", "error", err)
+	} else if expired > 0 {
+		s.log.Info("expired reservations returned to stock", "count", expired)
+	}
+
+	purged, err := s.repo.PurgeExpiredIdempotencyKeys(ctx)
+	if err != nil {
+		s.log.Error("failed to purge idempotency keys", "error", err)
+	} else if purged > 0 {
+		s.log.Info("expired idempotency keys purged", "count", purged)
+	}
+
+	purged, err = s.repo.PurgeDeliveredEvents(ctx, s.eventRetention)
+	if err != nil {
+		s.log.Error("failed to purge delivered events", "error", err)
+	} else if purged > 0 {
+		s.log.Info("delivered events purged", "count", purged)
+	}
+}
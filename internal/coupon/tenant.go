@@ -0,0 +1,63 @@
+package coupon
+
+import (
+	"context"
+	"net/http"
+
+	"scalable-coupon-system/pkg/couponapi"
+)
+
+const (
+	TenantHeader      = couponapi.TenantHeader
+	DefaultTenant     = "default"
+	maxTenantIDLength = 64
+)
+
+type tenantKey struct{}
+
+// WithTenant scopes every repository call made with the returned context to
+// tenantID.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant the context is scoped to, or
+// DefaultTenant for contexts that were never scoped.
+func TenantFromContext(ctx context.Context) string {
+	if tenantID, ok := ctx.Value(tenantKey{}).(string); ok && tenantID != "" {
+		return tenantID
+	}
+	return DefaultTenant
+}
+
+// validTenantID accepts 1 to 64 ASCII letters, digits, '-' and '_'.
+func validTenantID(tenantID string) bool {
+	if tenantID == "" || len(tenantID) > maxTenantIDLength {
+		return false
+	}
+	for i := 0; i < len(tenantID); i++ {
+		c := tenantID[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// withTenant scopes each request to the tenant named in the X-Tenant-ID
+// header. Requests without the header belong to DefaultTenant.
+func (h *Handler) withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID := r.Header.Get(TenantHeader)
+		if tenantID == "" {
+			tenantID = DefaultTenant
+		}
+		if !validTenantID(tenantID) {
+			h.log.Warn("invalid tenant id in request", "tenant_id", tenantID)
+			h.writeError(w, r, ErrInvalidTenant)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenantID)))
+	})
+}
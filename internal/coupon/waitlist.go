@@ -0,0 +1,276 @@
+package coupon
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// JoinWaitlist queues the user for a sold-out coupon. Joining is only allowed
+// while the coupon is active and has no free stock; otherwise the user should
+// claim directly.
+func (r *PostgresRepository) JoinWaitlist(
+	ctx context.Context,
+	couponName string,
+	userID string,
+) (*WaitlistEntry, error) {
+	r.log.Info("starting waitlist join", "coupon_name", couponName, "user_id", userID)
+	defer r.log.Info("finished waitlist join", "coupon_name", couponName, "user_id", userID)
+
+	tx, err := r.beginTx(ctx, "join_waitlist")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "coupon_name", couponName, "user_id", userID, "error", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Hold the coupon lock so stock cannot be freed between the sold-out check
+	// and the insert; a promotion running after this commits will see the entry.
+	state, err := readStockState(ctx, tx, r.log, couponName, true)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case state.notYetActive:
+		r.log.Warn("coupon not yet active", "coupon_name", couponName)
+		return nil, ErrCouponNotYetActive
+	case state.expired:
+		r.log.Warn("coupon expired", "coupon_name", couponName)
+		return nil, ErrCouponExpired
+	case state.claimable():
+		r.log.Warn("coupon in stock", "coupon_name", couponName)
+		return nil, ErrCouponInStock
+	}
+
+	if err := r.checkUserClaimLimit(ctx, tx, couponName, userID, state.maxClaimsPerUser); err != nil {
+		return nil, err
+	}
+
+	entry := WaitlistEntry{
+		CouponName: couponName,
+		UserID:     userID,
+		Status:     WaitlistStatusWaiting,
+	}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO waitlist (tenant_id, coupon_name, user_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (tenant_id, coupon_name, user_id) WHERE status = 'waiting' DO NOTHING
+		RETURNING id, created_at, updated_at
+	`, TenantFromContext(ctx), couponName, userID).Scan(&entry.ID, &entry.CreatedAt, &entry.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.log.Warn("user already on waitlist", "coupon_name", couponName, "user_id", userID)
+			return nil, ErrAlreadyOnWaitlist
+		}
+		r.log.Error("failed to join waitlist", "coupon_name", couponName, "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM waitlist
+		WHERE tenant_id = $1 AND coupon_name = $2 AND status = 'waiting' AND id <= $3
+	`, TenantFromContext(ctx), couponName, entry.ID).Scan(&entry.Position)
+	if err != nil {
+		r.log.Error("failed to get waitlist position", "coupon_name", couponName, "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.log.Error("failed to commit transaction", "coupon_name", couponName, "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	r.log.Info("user joined waitlist", "coupon_name", couponName, "user_id", userID, "position", entry.Position)
+	return &entry, nil
+}
+
+// GetWaitlistEntry returns the user's most recent waitlist entry for the
+// coupon, with its current position if it is still waiting.
+func (r *PostgresRepository) GetWaitlistEntry(
+	ctx context.Context,
+	couponName string,
+	userID string,
+) (*WaitlistEntry, error) {
+	var entry WaitlistEntry
+	err := r.db.QueryRow(ctx, `
+		SELECT
+			w.id,
+			w.coupon_name,
+			w.user_id,
+			w.status,
+			CASE WHEN w.status = 'waiting' THEN (
+				SELECT COUNT(*)
+				FROM waitlist q
+				WHERE q.tenant_id = w.tenant_id AND q.coupon_name = w.coupon_name
+					AND q.status = 'waiting' AND q.id <= w.id
+			) ELSE 0 END,
+			w.claim_id,
+			w.created_at,
+			w.updated_at
+		FROM waitlist w
+		WHERE w.tenant_id = $1 AND w.coupon_name = $2 AND w.user_id = $3
+		ORDER BY w.id DESC
+		LIMIT 1
+	`, TenantFromContext(ctx), couponName, userID).Scan(
+		&entry.ID,
+		&entry.CouponName,
+		&entry.UserID,
+		&entry.Status,
+		&entry.Position,
+		&entry.ClaimID,
+		&entry.CreatedAt,
+		&entry.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.log.Warn("waitlist entry not found", "coupon_name", couponName, "user_id", userID)
+			return nil, ErrWaitlistEntryNotFound
+		}
+		r.log.Error("failed to get waitlist entry", "coupon_name", couponName, "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// LeaveWaitlist removes the user from the queue. It takes the coupon lock so
+// it never races a promotion that is picking the same entry.
+func (r *PostgresRepository) LeaveWaitlist(
+	ctx context.Context,
+	couponName string,
+	userID string,
+) error {
+	r.log.Info("starting waitlist leave", "coupon_name", couponName, "user_id", userID)
+	defer r.log.Info("finished waitlist leave", "coupon_name", couponName, "user_id", userID)
+
+	tx, err := r.beginTx(ctx, "leave_waitlist")
+	if err != nil {
+		r.log.Error("failed to begin transaction", "coupon_name", couponName, "user_id", userID, "error", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := r.lockCoupon(ctx, tx, couponName); err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE waitlist
+		SET status = 'left', updated_at = now()
+		WHERE tenant_id = $1 AND coupon_name = $2 AND user_id = $3 AND status = 'waiting'
+	`, TenantFromContext(ctx), couponName, userID)
+	if err != nil {
+		r.log.Error("failed to leave waitlist", "coupon_name", couponName, "user_id", userID, "error", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		r.log.Warn("waitlist entry not found", "coupon_name", couponName, "user_id", userID)
+		return ErrWaitlistEntryNotFound
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.log.Error("failed to commit transaction", "coupon_name", couponName, "user_id", userID, "error", err)
+		return err
+	}
+
+	r.log.Info("user left waitlist", "coupon_name", couponName, "user_id", userID)
+	return nil
+}
+
+// promoteWaitlist hands free stock to waiting users in join order, claiming on
+// their behalf. It must run with the coupon row locked, in the same
+// transaction that freed the stock, so a concurrent ClaimCoupon never sees the
+// freed units while users are queued for them. Users who have since reached
+// their claim limit are skipped.
+func (r *PostgresRepository) promoteWaitlist(
+	ctx context.Context,
+	tx pgx.Tx,
+	couponName string,
+) (int, error) {
+	promoted := 0
+	for {
+		state, err := readStockState(ctx, tx, r.log, couponName, false)
+		if err != nil {
+			return promoted, err
+		}
+		if !state.claimable() {
+			break
+		}
+
+		var entryID int64
+		var userID string
+		err = tx.QueryRow(ctx, `
+			SELECT id, user_id
+			FROM waitlist
+			WHERE tenant_id = $1 AND coupon_name = $2 AND status = 'waiting'
+			ORDER BY id
+			LIMIT 1
+			FOR UPDATE
+		`, TenantFromContext(ctx), couponName).Scan(&entryID, &userID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				break
+			}
+			r.log.Error("failed to read waitlist", "coupon_name", couponName, "error", err)
+			return promoted, err
+		}
+
+		err = r.checkUserClaimLimit(ctx, tx, couponName, userID, state.maxClaimsPerUser)
+		if errors.Is(err, ErrClaimLimitReached) {
+			if err := r.setWaitlistStatus(ctx, tx, entryID, WaitlistStatusSkipped, nil); err != nil {
+				return promoted, err
+			}
+			continue
+		}
+		if err != nil {
+			return promoted, err
+		}
+
+		var stock CouponStock
+		err = tx.QueryRow(ctx, `
+			UPDATE coupons
+			SET claimed_count = claimed_count + 1
+			WHERE tenant_id = $1 AND name = $2
+			RETURNING amount, claimed_count, reserved_count, max_claims_per_user
+		`, TenantFromContext(ctx), couponName).Scan(&stock.Amount, &stock.Claimed, &stock.Reserved, &stock.MaxClaimsPerUser)
+		if err != nil {
+			r.log.Error("failed to take stock for waitlist", "coupon_name", couponName, "error", err)
+			return promoted, err
+		}
+
+		claimID, err := r.insertClaim(ctx, tx, couponName, userID, stock)
+		if err != nil {
+			return promoted, err
+		}
+
+		if err := r.setWaitlistStatus(ctx, tx, entryID, WaitlistStatusPromoted, &claimID); err != nil {
+			return promoted, err
+		}
+
+		r.log.Info("waitlist entry promoted", "coupon_name", couponName, "user_id", userID, "claim_id", claimID)
+		promoted++
+	}
+
+	return promoted, nil
+}
+
+func (r *PostgresRepository) setWaitlistStatus(
+	ctx context.Context,
+	tx pgx.Tx,
+	entryID int64,
+	status WaitlistStatus,
+	claimID *int64,
+) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE waitlist
+		SET status = $2, claim_id = $3, updated_at = now()
+		WHERE id = $1
+	`, entryID, status, claimID)
+	if err != nil {
+		r.log.Error("failed to update waitlist entry", "entry_id", entryID, "status", status, "error", err)
+		return err
+	}
+	return nil
+}
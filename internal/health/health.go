@@ -0,0 +1,159 @@
+// Package health serves the liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	DefaultCheckTimeout = 2 * time.Second
+
+	checkOK = "ok"
+
+	// undefinedTable is PostgreSQL's SQLSTATE for a missing relation.
+	undefinedTable = "42P01"
+)
+
+// Checker answers /healthz and /readyz. Readiness requires a reachable
+// database with every migration the code relies on applied, and fails from
+// the moment Drain is called so load balancers stop routing before the server
+// shuts down. A Checker without a database, as with in-memory storage, skips
+// those checks.
+type Checker struct {
+	db             *pgxpool.Pool
+	log            *slog.Logger
+	schemaVersions []int
+	timeout        time.Duration
+	draining       atomic.Bool
+}
+
+// NewChecker returns a Checker that requires each of schemaVersions to be
+// recorded in schema_migrations.
+func NewChecker(
+	db *pgxpool.Pool,
+	log *slog.Logger,
+	schemaVersions []int,
+) *Checker {
+	return &Checker{
+		db:             db,
+		log:            log,
+		schemaVersions: schemaVersions,
+		timeout:        DefaultCheckTimeout,
+	}
+}
+
+// Drain makes every later readiness check fail. It cannot be undone.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+type Response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Healthz reports that the process is serving requests. It deliberately
+// ignores the database, so a database outage does not get healthy instances
+// restarted.
+func (c *Checker) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, http.StatusOK, Response{Status: checkOK})
+}
+
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	if c.draining.Load() {
+		writeResponse(w, http.StatusServiceUnavailable, Response{
+			Status: "draining",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), c.timeout)
+	defer cancel()
+
+	resp := Response{Status: "ready", Checks: map[string]string{}}
+	status := http.StatusOK
+
+	if c.db == nil {
+		resp.Checks["database"] = "disabled"
+	} else if err := c.db.Ping(ctx); err != nil {
+		c.log.Warn("readiness database check failed", "error", err)
+		resp.Checks["database"] = "unreachable"
+		resp.Checks["schema"] = "unknown"
+		status = http.StatusServiceUnavailable
+	} else {
+		resp.Checks["database"] = checkOK
+		if err := c.checkSchema(ctx); err != nil {
+			c.log.Warn("readiness schema check failed", "error", err)
+			resp.Checks["schema"] = err.Error()
+			status = http.StatusServiceUnavailable
+		} else {
+			resp.Checks["schema"] = checkOK
+		}
+	}
+
+	if status != http.StatusOK {
+		resp.Status = "not_ready"
+	}
+	writeResponse(w, status, resp)
+}
+
+// checkSchema requires every expected version, not just the highest, since a
+// lower migration can be missing and applied later. Versions past the
+// expected ones are ignored, so instances of the previous release stay ready
+// while a newer one migrates.
+func (c *Checker) checkSchema(ctx context.Context) error {
+	rows, err := c.db.Query(ctx, `
+		SELECT expected.version
+		FROM unnest($1::int[]) AS expected(version)
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM schema_migrations
+			WHERE schema_migrations.version = expected.version
+		)
+		ORDER BY expected.version
+	`, c.schemaVersions)
+	var missing []int
+	if err == nil {
+		missing, err = pgx.CollectRows(rows, pgx.RowTo[int])
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == undefinedTable {
+			return errors.New("schema_migrations table missing")
+		}
+		c.log.Error("failed to read schema versions", "error", err)
+		return errors.New("schema version unreadable")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("schema missing migrations %s", joinVersions(missing))
+	}
+	return nil
+}
+
+func joinVersions(versions []int) string {
+	parts := make([]string, len(versions))
+	for i, version := range versions {
+		parts[i] = strconv.Itoa(version)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
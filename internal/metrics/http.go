@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTPMetrics counts and times requests per route and status.
+type HTTPMetrics struct {
+	requests *CounterVec
+	duration *HistogramVec
+}
+
+func NewHTTPMetrics(reg *Registry) *HTTPMetrics {
+	return &HTTPMetrics{
+		requests: reg.NewCounter(
+			"http_requests_total",
+			"HTTP requests by route pattern, method and status code.",
+			"route", "method", "status",
+		),
+		duration: reg.NewHistogram(
+			"http_request_duration_seconds",
+			"HTTP request latency by route pattern and status code.",
+			DefaultBuckets,
+			"route", "status",
+		),
+	}
+}
+
+// unmatchedRoute labels requests no pattern matched, so arbitrary paths can
+// never create new series.
+const unmatchedRoute = "unmatched"
+
+type routeKey struct{}
+
+type routeHolder struct {
+	route string
+}
+
+// Middleware records every request. The route label is the ServeMux pattern
+// reported by RecordRoute further down the chain.
+func (m *HTTPMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		holder := &routeHolder{}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), routeKey{}, holder)))
+
+		route := holder.route
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(rec.status)
+		m.requests.Inc(route, r.Method, status)
+		m.duration.Observe(time.Since(start).Seconds(), route, status)
+	})
+}
+
+// RecordRoute wraps a ServeMux and reports the pattern it matched to
+// Middleware. Middleware between the two may replace the request, which hides
+// the pattern from Middleware itself. With nested muxes the innermost, most
+// specific pattern wins.
+func RecordRoute(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+		if holder, ok := r.Context().Value(routeKey{}).(*routeHolder); ok && holder.route == "" {
+			holder.route = r.Pattern
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
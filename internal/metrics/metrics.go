@@ -0,0 +1,304 @@
+// Package metrics is a minimal Prometheus-compatible metrics registry. It
+// supports labelled counters and histograms plus callback gauges and renders
+// them in the Prometheus text exposition format (version 0.0.4).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets suit request and query latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds every metric exposed on one /metrics endpoint.
+type Registry struct {
+	mu      sync.Mutex
+	names   map[string]bool
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+// register panics on duplicate names: that is a programming error, and
+// silently merging two metrics would produce an invalid exposition.
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Write renders all metrics in registration order.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_ = r.Write(w)
+	})
+}
+
+// vec is the label bookkeeping shared by counters and histograms.
+type vec[T any] struct {
+	name   string
+	help   string
+	labels []string
+	newFn  func() *T
+
+	mu     sync.Mutex
+	series map[string]*labelled[T]
+}
+
+type labelled[T any] struct {
+	values []string
+	value  *T
+}
+
+func (v *vec[T]) get(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &labelled[T]{values: append([]string(nil), values...), value: v.newFn()}
+		v.series[key] = s
+	}
+	return s.value
+}
+
+// sorted returns a snapshot of the series in label order, so scrapes are
+// stable.
+func (v *vec[T]) sorted() []*labelled[T] {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]*labelled[T], 0, len(keys))
+	for _, key := range keys {
+		out = append(out, v.series[key])
+	}
+	return out
+}
+
+type counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// CounterVec is a monotonically increasing value per label combination.
+type CounterVec struct {
+	vec[counter]
+}
+
+func (r *Registry) NewCounter(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec[counter]{
+		name:   name,
+		help:   help,
+		labels: labels,
+		newFn:  func() *counter { return &counter{} },
+		series: map[string]*labelled[counter]{},
+	}}
+	r.register(name, c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: %s cannot decrease", c.name))
+	}
+	s := c.get(labelValues)
+	s.mu.Lock()
+	s.value += delta
+	s.mu.Unlock()
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	for _, s := range c.sorted() {
+		s.value.mu.Lock()
+		value := s.value.value
+		s.value.mu.Unlock()
+		writeSample(w, c.name, c.labels, s.values, "", "", value)
+	}
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec counts observations into cumulative buckets per label
+// combination.
+type HistogramVec struct {
+	vec[histogram]
+	buckets []float64
+}
+
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{
+		vec: vec[histogram]{
+			name:   name,
+			help:   help,
+			labels: labels,
+			newFn:  func() *histogram { return &histogram{counts: make([]uint64, len(buckets))} },
+			series: map[string]*labelled[histogram]{},
+		},
+		buckets: buckets,
+	}
+	r.register(name, h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	s := h.get(labelValues)
+	i := sort.SearchFloat64s(h.buckets, value)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, s := range h.sorted() {
+		s.value.mu.Lock()
+		counts := append([]uint64(nil), s.value.counts...)
+		count, sum := s.value.count, s.value.sum
+		s.value.mu.Unlock()
+
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, s.values, "le", formatFloat(upper), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, s.values, "le", "+Inf", float64(count))
+		writeSample(w, h.name+"_sum", h.labels, s.values, "", "", sum)
+		writeSample(w, h.name+"_count", h.labels, s.values, "", "", float64(count))
+	}
+}
+
+// funcMetric reads its value when scraped, for state that is owned elsewhere
+// such as connection pool statistics.
+type funcMetric struct {
+	name  string
+	help  string
+	kind  string
+	value func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name string, help string, value func() float64) {
+	r.register(name, &funcMetric{name: name, help: help, kind: "gauge", value: value})
+}
+
+// NewCounterFunc exposes a counter maintained elsewhere; value must never
+// decrease.
+func (r *Registry) NewCounterFunc(name string, help string, value func() float64) {
+	r.register(name, &funcMetric{name: name, help: help, kind: "counter", value: value})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.kind)
+	writeSample(w, f.name, nil, nil, "", "", f.value())
+}
+
+func writeHeader(w *bufio.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func writeSample(
+	w *bufio.Writer,
+	name string,
+	labels []string,
+	values []string,
+	extraLabel string,
+	extraValue string,
+	value float64,
+) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
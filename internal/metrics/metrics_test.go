@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, reg *Registry) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func expectLines(t *testing.T, body string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected line %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestRegistryExposition(t *testing.T) {
+	reg := NewRegistry()
+
+	claims := reg.NewCounter("claims_total", "Claims by outcome.", "outcome")
+	claims.Inc("success")
+	claims.Inc("success")
+	claims.Add(3, `out "of" stock`)
+
+	latency := reg.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+	latency.Observe(0.05, "claim")
+	latency.Observe(0.5, "claim")
+	latency.Observe(5, "claim")
+
+	reg.NewGaugeFunc("pool_idle", "Idle connections.", func() float64 { return 7 })
+
+	expectLines(t, scrape(t, reg),
+		"# HELP claims_total Claims by outcome.",
+		"# TYPE claims_total counter",
+		`claims_total{outcome="out \"of\" stock"} 3`,
+		`claims_total{outcome="success"} 2`,
+		"# TYPE latency_seconds histogram",
+		`latency_seconds_bucket{op="claim",le="0.1"} 1`,
+		`latency_seconds_bucket{op="claim",le="1"} 2`,
+		`latency_seconds_bucket{op="claim",le="+Inf"} 3`,
+		`latency_seconds_sum{op="claim"} 5.55`,
+		`latency_seconds_count{op="claim"} 3`,
+		"# TYPE pool_idle gauge",
+		"pool_idle 7",
+	)
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected registering a duplicate name to panic")
+		}
+	}()
+	reg.NewCounter("claims_total", "Again.")
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	reg := NewRegistry()
+	httpMetrics := NewHTTPMetrics(reg)
+
+	inner := http.NewServeMux()
+	inner.HandleFunc("POST /api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	root := http.NewServeMux()
+	// The inner mux sees a replaced request, as it does behind the tenant and
+	// request id middleware.
+	root.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RecordRoute(inner).ServeHTTP(w, r.WithContext(r.Context()))
+	}))
+	root.Handle("GET /metrics", reg.Handler())
+	handler := httpMetrics.Middleware(RecordRoute(root))
+
+	for _, target := range []string{"/api/items/1", "/api/items/2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, target, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/items/1", nil))
+
+	expectLines(t, scrape(t, reg),
+		`http_requests_total{route="POST /api/items/{id}",method="POST",status="201"} 2`,
+		`http_request_duration_seconds_count{route="POST /api/items/{id}",status="201"} 2`,
+		// A wrong method reaches no pattern in the inner mux; the outer catch-all
+		// is the most specific match.
+		`http_requests_total{route="/",method="GET",status="405"} 1`,
+	)
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import "github.com/jackc/pgx/v5/pgxpool"
+
+// RegisterPoolStats exposes the connection pool's statistics, read from
+// pgxpool.Pool.Stat on every scrape.
+func RegisterPoolStats(reg *Registry, pool *pgxpool.Pool) {
+	reg.NewGaugeFunc("pgxpool_acquired_conns", "Connections currently checked out of the pool.",
+		func() float64 { return float64(pool.Stat().AcquiredConns()) })
+	reg.NewGaugeFunc("pgxpool_idle_conns", "Idle connections in the pool.",
+		func() float64 { return float64(pool.Stat().IdleConns()) })
+	reg.NewGaugeFunc("pgxpool_constructing_conns", "Connections being established.",
+		func() float64 { return float64(pool.Stat().ConstructingConns()) })
+	reg.NewGaugeFunc("pgxpool_total_conns", "Total connections in the pool.",
+		func() float64 { return float64(pool.Stat().TotalConns()) })
+	reg.NewGaugeFunc("pgxpool_max_conns", "Maximum size of the pool.",
+		func() float64 { return float64(pool.Stat().MaxConns()) })
+	reg.NewCounterFunc("pgxpool_acquire_count_total", "Successful connection acquisitions.",
+		func() float64 { return float64(pool.Stat().AcquireCount()) })
+	reg.NewCounterFunc("pgxpool_acquire_duration_seconds_total", "Total time spent acquiring connections.",
+		func() float64 { return pool.Stat().AcquireDuration().Seconds() })
+	reg.NewCounterFunc("pgxpool_empty_acquire_count_total", "Acquisitions that had to wait for a connection.",
+		func() float64 { return float64(pool.Stat().EmptyAcquireCount()) })
+	reg.NewCounterFunc("pgxpool_canceled_acquire_count_total", "Acquisitions canceled by their context.",
+		func() float64 { return float64(pool.Stat().CanceledAcquireCount()) })
+	reg.NewCounterFunc("pgxpool_new_conns_count_total", "Connections opened since the pool was created.",
+		func() float64 { return float64(pool.Stat().NewConnsCount()) })
+}
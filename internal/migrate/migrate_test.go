@@ -0,0 +1,71 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"scalable-coupon-system/internal/coupon"
+	"scalable-coupon-system/migration"
+)
+
+func TestLoad(t *testing.T) {
+	file := func(body string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(body)}
+	}
+
+	migrations, err := Load(fstest.MapFS{
+		"010_later.up.sql":   file("CREATE TABLE b ();"),
+		"010_later.down.sql": file("DROP TABLE b;"),
+		"001_first.up.sql":   file("CREATE TABLE a ();"),
+		"001_first.down.sql": file("DROP TABLE a;"),
+		"002_setup.sh":       file("#!/bin/sh"),
+		"README.md":          file("not a migration"),
+		"sub/003_x.up.sql":   file("ignored"),
+		"sub/003_x.down.sql": file("ignored"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Version != 10 {
+		t.Fatalf("Expected versions 1 and 10 in order, got %+v", migrations)
+	}
+	if migrations[1].Name != "later" || migrations[1].Up != "CREATE TABLE b ();" || migrations[1].Down != "DROP TABLE b;" {
+		t.Errorf("Unexpected migration %+v", migrations[1])
+	}
+	migrator := &Migrator{migrations: migrations}
+	if versions := migrator.Versions(9); len(versions) != 1 || versions[0] != 1 {
+		t.Errorf("Expected only version 1 up to 9, got %v", versions)
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"missing down": {
+			"001_first.up.sql": file("CREATE TABLE a ();"),
+		},
+		"duplicate version": {
+			"001_first.up.sql":    file("CREATE TABLE a ();"),
+			"001_first.down.sql":  file("DROP TABLE a;"),
+			"001_second.up.sql":   file("CREATE TABLE b ();"),
+			"001_second.down.sql": file("DROP TABLE b;"),
+		},
+		"version zero": {
+			"000_zero.up.sql":   file("SELECT 1;"),
+			"000_zero.down.sql": file("SELECT 1;"),
+		},
+	}
+	for name, fsys := range invalid {
+		if _, err := Load(fsys); !errors.Is(err, ErrInvalidMigration) {
+			t.Errorf("%s: expected ErrInvalidMigration, got %v", name, err)
+		}
+	}
+
+	// The embedded migrations must load and end at the version the readiness
+	// check expects.
+	embedded, err := Load(migration.FS)
+	if err != nil {
+		t.Fatalf("Failed to load embedded migrations: %v", err)
+	}
+	if latest := embedded[len(embedded)-1].Version; latest != coupon.SchemaVersion {
+		t.Errorf("Latest embedded migration is %d but coupon.SchemaVersion is %d", latest, coupon.SchemaVersion)
+	}
+}
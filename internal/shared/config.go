@@ -4,9 +4,18 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage backends selectable with STORAGE.
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
 )
 
 type Config struct {
+	Storage    string
 	DBUsername string
 	DBPassword string
 	DBHost     string
@@ -15,10 +24,27 @@ type Config struct {
 	Production bool
 	LogPath    string
 	AppPort    string
+
+	ClaimStrategy    string
+	IdempotencyTTL   time.Duration
+	IdempotencyLease time.Duration
+	ReservationTTL   time.Duration
+	SweepInterval    time.Duration
+	DrainPeriod      time.Duration
+	AutoMigrate      bool
+
+	OutboxFile       string
+	OutboxWebhookURL string
+	DispatchInterval time.Duration
+	EventRetention   time.Duration
+
+	JWTKeys      string
+	AdminAPIKeys []string
 }
 
 func NewConfig() *Config {
 	cfg := Config{}
+	cfg.Storage = cfg.getEnvString("STORAGE", StoragePostgres)
 	cfg.DBUsername = os.Getenv("DB_USERNAME")
 	cfg.DBPassword = os.Getenv("DB_PASSWORD")
 	cfg.DBHost = os.Getenv("DB_HOST")
@@ -26,9 +52,29 @@ func NewConfig() *Config {
 	cfg.DBName = os.Getenv("DB_NAME")
 	cfg.LogPath = os.Getenv("LOG_PATH")
 	cfg.AppPort = os.Getenv("APP_PORT")
+	cfg.ClaimStrategy = os.Getenv("CLAIM_STRATEGY")
+	cfg.IdempotencyTTL = cfg.getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+	cfg.IdempotencyLease = cfg.getEnvDuration("IDEMPOTENCY_LEASE", time.Minute)
+	cfg.ReservationTTL = cfg.getEnvDuration("RESERVATION_TTL", 10*time.Minute)
+	cfg.SweepInterval = cfg.getEnvDuration("SWEEP_INTERVAL", 10*time.Second)
+	cfg.DrainPeriod = cfg.getEnvDuration("SHUTDOWN_DRAIN_PERIOD", 5*time.Second)
+	cfg.AutoMigrate = cfg.getEnvBool("AUTO_MIGRATE", false)
+	cfg.OutboxFile = os.Getenv("OUTBOX_FILE")
+	cfg.OutboxWebhookURL = os.Getenv("OUTBOX_WEBHOOK_URL")
+	cfg.DispatchInterval = cfg.getEnvDuration("DISPATCH_INTERVAL", time.Second)
+	cfg.EventRetention = cfg.getEnvDuration("OUTBOX_RETENTION", 7*24*time.Hour)
+	cfg.JWTKeys = os.Getenv("JWT_KEYS")
+	cfg.AdminAPIKeys = cfg.getEnvList("ADMIN_API_KEYS")
 	return &cfg
 }
 
+func (cfg *Config) getEnvString(key string, def string) string {
+	if env := os.Getenv(key); env != "" {
+		return env
+	}
+	return def
+}
+
 func (cfg *Config) getEnvInt(key string, def int) int {
 	env, err := strconv.Atoi(os.Getenv(key))
 	if err != nil {
@@ -37,3 +83,35 @@ func (cfg *Config) getEnvInt(key string, def int) int {
 	}
 	return env
 }
+
+func (cfg *Config) getEnvDuration(key string, def time.Duration) time.Duration {
+	env, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		log.Printf("Invalid %s environment variable, %s set to %s\n", key, key, def)
+		env = def
+	}
+	return env
+}
+
+func (cfg *Config) getEnvBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	env, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid %s environment variable, %s set to %t\n", key, key, def)
+		env = def
+	}
+	return env
+}
+
+func (cfg *Config) getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
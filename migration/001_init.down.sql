@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS claim_history;
+DROP TABLE IF EXISTS coupons;
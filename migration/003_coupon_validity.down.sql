@@ -0,0 +1,2 @@
+ALTER TABLE coupons DROP COLUMN IF EXISTS ends_at;
+ALTER TABLE coupons DROP COLUMN IF EXISTS starts_at;
@@ -0,0 +1,7 @@
+-- Fails if any user holds more than one claim on a coupon
+DROP INDEX IF EXISTS idx_claim_history_coupon_user;
+
+ALTER TABLE claim_history DROP CONSTRAINT IF EXISTS claim_history_unique;
+ALTER TABLE claim_history ADD CONSTRAINT claim_history_unique UNIQUE (user_id, coupon_name);
+
+ALTER TABLE coupons DROP COLUMN IF EXISTS max_claims_per_user;
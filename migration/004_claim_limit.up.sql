@@ -0,0 +1,6 @@
+-- Replace the one-claim-per-user constraint with a configurable limit
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS max_claims_per_user INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE claim_history DROP CONSTRAINT IF EXISTS claim_history_unique;
+
+CREATE INDEX IF NOT EXISTS idx_claim_history_coupon_user ON claim_history(coupon_name, user_id);
@@ -0,0 +1,7 @@
+ALTER TABLE claim_history DROP CONSTRAINT IF EXISTS claim_history_status_check;
+
+ALTER TABLE claim_history DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE claim_history DROP COLUMN IF EXISTS claimed_at;
+ALTER TABLE claim_history DROP COLUMN IF EXISTS order_ref;
+ALTER TABLE claim_history DROP COLUMN IF EXISTS status;
+ALTER TABLE claim_history DROP COLUMN IF EXISTS id;
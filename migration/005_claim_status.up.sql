@@ -0,0 +1,10 @@
+-- Track the lifecycle of each claim: claimed -> redeemed -> voided, or expired
+ALTER TABLE claim_history ADD COLUMN IF NOT EXISTS id BIGSERIAL PRIMARY KEY;
+ALTER TABLE claim_history ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'claimed';
+ALTER TABLE claim_history ADD COLUMN IF NOT EXISTS order_ref VARCHAR(255);
+ALTER TABLE claim_history ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE claim_history ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+ALTER TABLE claim_history DROP CONSTRAINT IF EXISTS claim_history_status_check;
+ALTER TABLE claim_history ADD CONSTRAINT claim_history_status_check
+    CHECK (status IN ('claimed', 'redeemed', 'voided', 'expired'));
@@ -0,0 +1,2 @@
+-- Optimistic concurrency token for coupon updates
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
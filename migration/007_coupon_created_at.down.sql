@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_coupons_created_at_name;
+
+ALTER TABLE coupons DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,4 @@
+-- Creation time for listing and keyset pagination
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS idx_coupons_created_at_name ON coupons(created_at, name);
@@ -0,0 +1,2 @@
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_claimed_count_check;
+ALTER TABLE coupons DROP COLUMN IF EXISTS claimed_count;
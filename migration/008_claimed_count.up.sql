@@ -0,0 +1,13 @@
+-- Store the number of claims on each coupon instead of counting on every claim
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS claimed_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE coupons c
+SET claimed_count = (
+    SELECT COUNT(*)
+    FROM claim_history ch
+    WHERE ch.coupon_name = c.name
+);
+
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_claimed_count_check;
+ALTER TABLE coupons ADD CONSTRAINT coupons_claimed_count_check
+    CHECK (claimed_count >= 0 AND claimed_count <= amount);
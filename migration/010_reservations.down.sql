@@ -0,0 +1,9 @@
+-- Active reservations are dropped and their held stock released
+DROP TABLE IF EXISTS reservations;
+
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_stock_check;
+ALTER TABLE coupons DROP COLUMN IF EXISTS reserved_count;
+
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_claimed_count_check;
+ALTER TABLE coupons ADD CONSTRAINT coupons_claimed_count_check
+    CHECK (claimed_count >= 0 AND claimed_count <= amount);
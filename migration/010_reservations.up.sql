@@ -0,0 +1,24 @@
+-- Hold stock for a limited time before it is confirmed as a claim
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS reserved_count INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_claimed_count_check;
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_stock_check;
+ALTER TABLE coupons ADD CONSTRAINT coupons_stock_check
+    CHECK (claimed_count >= 0 AND reserved_count >= 0 AND claimed_count + reserved_count <= amount);
+
+CREATE TABLE IF NOT EXISTS reservations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    coupon_name VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'confirmed', 'cancelled', 'expired')),
+    claim_id BIGINT,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_reservations_active_expires_at
+    ON reservations(expires_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_reservations_coupon_user
+    ON reservations(coupon_name, user_id);
@@ -0,0 +1,18 @@
+-- FIFO waitlist for sold-out coupons
+CREATE TABLE IF NOT EXISTS waitlist (
+    id BIGSERIAL PRIMARY KEY,
+    coupon_name VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'waiting'
+        CHECK (status IN ('waiting', 'promoted', 'left', 'skipped')),
+    claim_id BIGINT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_waitlist_waiting_user
+    ON waitlist(coupon_name, user_id) WHERE status = 'waiting';
+CREATE INDEX IF NOT EXISTS idx_waitlist_waiting_queue
+    ON waitlist(coupon_name, id) WHERE status = 'waiting';
+CREATE INDEX IF NOT EXISTS idx_waitlist_coupon_user
+    ON waitlist(coupon_name, user_id, id);
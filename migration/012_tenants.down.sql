@@ -0,0 +1,34 @@
+-- Fails if two tenants share a coupon name or an idempotency key
+DROP INDEX IF EXISTS idx_waitlist_tenant_waiting_user;
+DROP INDEX IF EXISTS idx_waitlist_tenant_waiting_queue;
+DROP INDEX IF EXISTS idx_waitlist_tenant_coupon_user;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_waitlist_waiting_user
+    ON waitlist(coupon_name, user_id) WHERE status = 'waiting';
+CREATE INDEX IF NOT EXISTS idx_waitlist_waiting_queue
+    ON waitlist(coupon_name, id) WHERE status = 'waiting';
+CREATE INDEX IF NOT EXISTS idx_waitlist_coupon_user
+    ON waitlist(coupon_name, user_id, id);
+
+DROP INDEX IF EXISTS idx_reservations_tenant_coupon_user;
+CREATE INDEX IF NOT EXISTS idx_reservations_coupon_user ON reservations(coupon_name, user_id);
+
+DROP INDEX IF EXISTS idx_claim_history_tenant_coupon_user;
+DROP INDEX IF EXISTS idx_claim_history_tenant_user;
+CREATE INDEX IF NOT EXISTS idx_claim_history_coupon_name ON claim_history(coupon_name);
+CREATE INDEX IF NOT EXISTS idx_claim_history_user_id ON claim_history(user_id);
+CREATE INDEX IF NOT EXISTS idx_claim_history_coupon_user ON claim_history(coupon_name, user_id);
+
+DROP INDEX IF EXISTS idx_coupons_tenant_created_at_name;
+CREATE INDEX IF NOT EXISTS idx_coupons_created_at_name ON coupons(created_at, name);
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD CONSTRAINT idempotency_keys_pkey PRIMARY KEY (scope, key);
+
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_pkey;
+ALTER TABLE coupons ADD CONSTRAINT coupons_pkey PRIMARY KEY (name);
+
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE waitlist DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE reservations DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE claim_history DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE coupons DROP COLUMN IF EXISTS tenant_id;
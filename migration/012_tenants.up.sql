@@ -0,0 +1,36 @@
+-- Scope every coupon, claim, reservation, waitlist entry and idempotency key
+-- to a tenant. Existing rows belong to the 'default' tenant.
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE claim_history ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE reservations ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE waitlist ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+
+-- Coupon names are unique per tenant rather than globally
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_pkey;
+ALTER TABLE coupons ADD CONSTRAINT coupons_pkey PRIMARY KEY (tenant_id, name);
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD CONSTRAINT idempotency_keys_pkey PRIMARY KEY (tenant_id, scope, key);
+
+DROP INDEX IF EXISTS idx_coupons_created_at_name;
+CREATE INDEX IF NOT EXISTS idx_coupons_tenant_created_at_name ON coupons(tenant_id, created_at, name);
+
+DROP INDEX IF EXISTS idx_claim_history_coupon_name;
+DROP INDEX IF EXISTS idx_claim_history_user_id;
+DROP INDEX IF EXISTS idx_claim_history_coupon_user;
+CREATE INDEX IF NOT EXISTS idx_claim_history_tenant_coupon_user ON claim_history(tenant_id, coupon_name, user_id);
+CREATE INDEX IF NOT EXISTS idx_claim_history_tenant_user ON claim_history(tenant_id, user_id);
+
+DROP INDEX IF EXISTS idx_reservations_coupon_user;
+CREATE INDEX IF NOT EXISTS idx_reservations_tenant_coupon_user ON reservations(tenant_id, coupon_name, user_id);
+
+DROP INDEX IF EXISTS idx_waitlist_waiting_user;
+DROP INDEX IF EXISTS idx_waitlist_waiting_queue;
+DROP INDEX IF EXISTS idx_waitlist_coupon_user;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_waitlist_tenant_waiting_user
+    ON waitlist(tenant_id, coupon_name, user_id) WHERE status = 'waiting';
+CREATE INDEX IF NOT EXISTS idx_waitlist_tenant_waiting_queue
+    ON waitlist(tenant_id, coupon_name, id) WHERE status = 'waiting';
+CREATE INDEX IF NOT EXISTS idx_waitlist_tenant_coupon_user
+    ON waitlist(tenant_id, coupon_name, user_id, id);
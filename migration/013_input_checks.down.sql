@@ -0,0 +1,6 @@
+ALTER TABLE waitlist DROP CONSTRAINT IF EXISTS waitlist_user_id_check;
+ALTER TABLE reservations DROP CONSTRAINT IF EXISTS reservations_user_id_check;
+ALTER TABLE claim_history DROP CONSTRAINT IF EXISTS claim_history_user_id_check;
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_max_claims_per_user_check;
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_amount_check;
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_name_check;
@@ -0,0 +1,27 @@
+-- Enforce the API's input rules (see internal/coupon/validation.go) in the
+-- database as well, so rows written by other paths obey them too.
+-- NOT VALID skips rows that already exist; run VALIDATE CONSTRAINT once they
+-- have been cleaned up.
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_name_check;
+ALTER TABLE coupons ADD CONSTRAINT coupons_name_check
+    CHECK (name ~ '^[A-Za-z0-9_-]{1,64}$') NOT VALID;
+
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_amount_check;
+ALTER TABLE coupons ADD CONSTRAINT coupons_amount_check
+    CHECK (amount BETWEEN 1 AND 1000000) NOT VALID;
+
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_max_claims_per_user_check;
+ALTER TABLE coupons ADD CONSTRAINT coupons_max_claims_per_user_check
+    CHECK (max_claims_per_user BETWEEN 1 AND 1000000) NOT VALID;
+
+ALTER TABLE claim_history DROP CONSTRAINT IF EXISTS claim_history_user_id_check;
+ALTER TABLE claim_history ADD CONSTRAINT claim_history_user_id_check
+    CHECK (user_id ~ '^[A-Za-z0-9_.@:-]{1,128}$') NOT VALID;
+
+ALTER TABLE reservations DROP CONSTRAINT IF EXISTS reservations_user_id_check;
+ALTER TABLE reservations ADD CONSTRAINT reservations_user_id_check
+    CHECK (user_id ~ '^[A-Za-z0-9_.@:-]{1,128}$') NOT VALID;
+
+ALTER TABLE waitlist DROP CONSTRAINT IF EXISTS waitlist_user_id_check;
+ALTER TABLE waitlist ADD CONSTRAINT waitlist_user_id_check
+    CHECK (user_id ~ '^[A-Za-z0-9_.@:-]{1,128}$') NOT VALID;
@@ -0,0 +1,2 @@
+-- schema_migrations belongs to the migration runner (internal/migrate) and is
+-- kept; the runner removes this version's row itself.
@@ -0,0 +1,13 @@
+-- Record which migrations have been applied, so readiness checks can tell
+-- whether the schema matches the running code. The versions up to this one are
+-- inserted here for databases set up before the migration runner existed; the
+-- runner records every later version itself. There is no version 2: the test
+-- database script that once held that number is not a migration.
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO schema_migrations (version)
+VALUES (1), (3), (4), (5), (6), (7), (8), (9), (10), (11), (12), (13), (14)
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_outbox_delivered_at;
+ALTER TABLE outbox DROP COLUMN IF EXISTS delivered_sinks;
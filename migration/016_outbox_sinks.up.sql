@@ -0,0 +1,7 @@
+-- Remember which sinks have accepted each event, so a retry after one sink
+-- failed only goes to the sinks that still need it.
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS delivered_sinks TEXT[] NOT NULL DEFAULT '{}';
+
+-- Lets the sweeper find delivered events past their retention.
+CREATE INDEX IF NOT EXISTS idx_outbox_delivered_at
+    ON outbox(delivered_at) WHERE status = 'delivered';
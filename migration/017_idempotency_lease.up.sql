@@ -0,0 +1,4 @@
+-- In-progress idempotency keys are held for a short lease, after which a
+-- retry may reclaim a key whose request never finished. Existing in-progress
+-- keys are reclaimable straight away.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_waitlist_claim_id;
+DROP INDEX IF EXISTS idx_reservations_claim_id;
+ALTER TABLE waitlist DROP CONSTRAINT IF EXISTS waitlist_claim_id_fkey;
+ALTER TABLE reservations DROP CONSTRAINT IF EXISTS reservations_claim_id_fkey;
@@ -0,0 +1,22 @@
+-- Released claims are deleted, which left reservations and waitlist entries
+-- pointing at claims that no longer exist. Clear those references and let the
+-- database clear future ones.
+UPDATE reservations r SET claim_id = NULL
+WHERE claim_id IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM claim_history c WHERE c.id = r.claim_id);
+UPDATE waitlist w SET claim_id = NULL
+WHERE claim_id IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM claim_history c WHERE c.id = w.claim_id);
+
+ALTER TABLE reservations DROP CONSTRAINT IF EXISTS reservations_claim_id_fkey;
+ALTER TABLE reservations ADD CONSTRAINT reservations_claim_id_fkey
+    FOREIGN KEY (claim_id) REFERENCES claim_history(id) ON DELETE SET NULL;
+ALTER TABLE waitlist DROP CONSTRAINT IF EXISTS waitlist_claim_id_fkey;
+ALTER TABLE waitlist ADD CONSTRAINT waitlist_claim_id_fkey
+    FOREIGN KEY (claim_id) REFERENCES claim_history(id) ON DELETE SET NULL;
+
+-- Let deleting a claim find the rows that reference it without a scan.
+CREATE INDEX IF NOT EXISTS idx_reservations_claim_id
+    ON reservations(claim_id) WHERE claim_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_waitlist_claim_id
+    ON waitlist(claim_id) WHERE claim_id IS NOT NULL;
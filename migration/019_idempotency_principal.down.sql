@@ -0,0 +1,6 @@
+-- Fails if two principals of a tenant share an idempotency key
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD CONSTRAINT idempotency_keys_pkey PRIMARY KEY (tenant_id, scope, key);
+
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS lease_token;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS principal;
@@ -0,0 +1,9 @@
+-- Scope idempotency keys to the principal that sent them, so two users who
+-- pick the same key do not collide, and fence each in-progress key with a
+-- lease token that changes on every takeover. Existing keys keep an empty
+-- principal, match no new request and expire with their TTL.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS principal TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS lease_token UUID NOT NULL DEFAULT gen_random_uuid();
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD CONSTRAINT idempotency_keys_pkey PRIMARY KEY (tenant_id, scope, principal, key);
@@ -0,0 +1 @@
+-- Nothing to restore; version 2 never existed.
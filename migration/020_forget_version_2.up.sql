@@ -0,0 +1,3 @@
+-- Databases that applied 014 before it was corrected record a version 2 that
+-- no migration has. Drop it so schema_migrations matches the embedded set.
+DELETE FROM schema_migrations WHERE version = 2;
@@ -0,0 +1,10 @@
+// Package migration embeds the versioned SQL migrations, so the server binary
+// can apply them without the files on disk. See internal/migrate.
+package migration
+
+import "embed"
+
+// FS holds every NNN_name.up.sql and NNN_name.down.sql file.
+//
+//go:embed *.sql
+var FS embed.FS
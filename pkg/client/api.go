@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// One method per route in coupon.Handler.Routes, in the same order.
+
+func (c *Client) CreateCoupon(ctx context.Context, req CreateCouponRequest) error {
+	return c.do(ctx, &call{method: http.MethodPost, path: "/api/coupons", body: req})
+}
+
+func (c *Client) ListCoupons(ctx context.Context, req ListCouponsRequest) (ListCouponsResponse, error) {
+	query := url.Values{}
+	setQuery(query, "name_prefix", req.NamePrefix)
+	setQuery(query, "status", req.Status)
+	setQuery(query, "created_after", formatTime(req.CreatedAfter))
+	setQuery(query, "created_before", formatTime(req.CreatedBefore))
+	setQuery(query, "cursor", req.Cursor)
+	setLimit(query, req.Limit)
+
+	var resp ListCouponsResponse
+	err := c.do(ctx, &call{method: http.MethodGet, path: "/api/coupons", query: query, out: &resp})
+	return resp, err
+}
+
+// ClaimCoupon claims req.CouponName for req.UserId. A claim is only retried
+// when made with WithIdempotencyKey.
+func (c *Client) ClaimCoupon(ctx context.Context, req ClaimCouponRequest, opts ...CallOption) error {
+	return c.do(ctx, &call{method: http.MethodPost, path: "/api/coupons/claim", body: req}, opts...)
+}
+
+func (c *Client) RedeemCoupon(ctx context.Context, req RedeemCouponRequest) (ClaimResponse, error) {
+	var resp ClaimResponse
+	err := c.do(ctx, &call{method: http.MethodPost, path: "/api/coupons/redeem", body: req, out: &resp})
+	return resp, err
+}
+
+func (c *Client) VoidCoupon(ctx context.Context, req VoidCouponRequest) (ClaimResponse, error) {
+	var resp ClaimResponse
+	err := c.do(ctx, &call{method: http.MethodPost, path: "/api/coupons/void", body: req, out: &resp})
+	return resp, err
+}
+
+// GetCoupon returns a coupon's details; includeClaimedBy needs an admin.
+func (c *Client) GetCoupon(ctx context.Context, name string, includeClaimedBy bool) (GetCouponDetailsResponse, error) {
+	query := url.Values{}
+	if includeClaimedBy {
+		query.Set("include_claimed_by", "true")
+	}
+	var resp GetCouponDetailsResponse
+	err := c.do(ctx, &call{method: http.MethodGet, path: couponPath(name), query: query, out: &resp})
+	return resp, err
+}
+
+// UpdateCoupon applies req only if the coupon is still at version, and
+// returns ErrVersionMismatch otherwise.
+func (c *Client) UpdateCoupon(
+	ctx context.Context,
+	name string,
+	version int64,
+	req UpdateCouponRequest,
+) (GetCouponDetailsResponse, error) {
+	header := http.Header{"If-Match": {strconv.Quote(strconv.FormatInt(version, 10))}}
+	var resp GetCouponDetailsResponse
+	err := c.do(ctx, &call{method: http.MethodPatch, path: couponPath(name), header: header, body: req, out: &resp})
+	return resp, err
+}
+
+func (c *Client) ListCouponClaims(ctx context.Context, req ListCouponClaimsRequest) (ListCouponClaimsResponse, error) {
+	query := url.Values{}
+	setQuery(query, "cursor", req.Cursor)
+	setLimit(query, req.Limit)
+
+	var resp ListCouponClaimsResponse
+	err := c.do(ctx, &call{method: http.MethodGet, path: couponPath(req.CouponName) + "/claims", query: query, out: &resp})
+	return resp, err
+}
+
+// ReleaseClaim releases the user's newest unredeemed claim; it needs an admin.
+// Each call releases another one, so it is sent exactly once: after a network
+// error or a 5xx the release may or may not have happened.
+func (c *Client) ReleaseClaim(ctx context.Context, name string, userID string) error {
+	return c.do(ctx, &call{method: http.MethodDelete, path: couponPath(name) + "/claims/" + url.PathEscape(userID), once: true})
+}
+
+func (c *Client) CreateReservation(ctx context.Context, name string, req CreateReservationRequest) (ReservationResponse, error) {
+	var resp ReservationResponse
+	err := c.do(ctx, &call{method: http.MethodPost, path: couponPath(name) + "/reservations", body: req, out: &resp})
+	return resp, err
+}
+
+func (c *Client) JoinWaitlist(ctx context.Context, name string, req JoinWaitlistRequest) (WaitlistEntryResponse, error) {
+	var resp WaitlistEntryResponse
+	err := c.do(ctx, &call{method: http.MethodPost, path: couponPath(name) + "/waitlist", body: req, out: &resp})
+	return resp, err
+}
+
+func (c *Client) GetWaitlistEntry(ctx context.Context, name string, userID string) (WaitlistEntryResponse, error) {
+	var resp WaitlistEntryResponse
+	err := c.do(ctx, &call{method: http.MethodGet, path: couponPath(name) + "/waitlist/" + url.PathEscape(userID), out: &resp})
+	return resp, err
+}
+
+func (c *Client) LeaveWaitlist(ctx context.Context, name string, userID string) error {
+	return c.do(ctx, &call{method: http.MethodDelete, path: couponPath(name) + "/waitlist/" + url.PathEscape(userID)})
+}
+
+func (c *Client) GetReservation(ctx context.Context, id string) (ReservationResponse, error) {
+	var resp ReservationResponse
+	err := c.do(ctx, &call{method: http.MethodGet, path: reservationPath(id), out: &resp})
+	return resp, err
+}
+
+func (c *Client) ConfirmReservation(ctx context.Context, id string) (ReservationResponse, error) {
+	var resp ReservationResponse
+	err := c.do(ctx, &call{method: http.MethodPost, path: reservationPath(id) + "/confirm", out: &resp})
+	return resp, err
+}
+
+func (c *Client) CancelReservation(ctx context.Context, id string) (ReservationResponse, error) {
+	var resp ReservationResponse
+	err := c.do(ctx, &call{method: http.MethodPost, path: reservationPath(id) + "/cancel", out: &resp})
+	return resp, err
+}
+
+func (c *Client) ListUserClaims(ctx context.Context, req ListUserClaimsRequest) (ListUserClaimsResponse, error) {
+	query := url.Values{}
+	setQuery(query, "status", req.Status)
+	setQuery(query, "sort", req.Sort)
+	setQuery(query, "order", req.Order)
+	setQuery(query, "cursor", req.Cursor)
+	setLimit(query, req.Limit)
+
+	var resp ListUserClaimsResponse
+	err := c.do(ctx, &call{method: http.MethodGet, path: "/api/users/" + url.PathEscape(req.UserID) + "/claims", query: query, out: &resp})
+	return resp, err
+}
+
+func couponPath(name string) string {
+	return "/api/coupons/" + url.PathEscape(name)
+}
+
+func reservationPath(id string) string {
+	return "/api/reservations/" + url.PathEscape(id)
+}
+
+func setQuery(query url.Values, key string, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+func setLimit(query url.Values, limit int) {
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,286 @@
+// Package client is a Go client for the coupon API. It has a typed method for
+// every route, turns error responses back into the sentinel errors of the
+// server (so errors.Is(err, client.ErrCouponOutOfStock) works), and retries
+// idempotent calls with exponential backoff.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"scalable-coupon-system/pkg/couponapi"
+)
+
+// RetryPolicy controls how failed idempotent calls are retried. Only network
+// errors and 429, 502, 503 and 504 responses are retried; a Retry-After header
+// from the server takes precedence over the computed backoff.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt; 1 disables retries.
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  100 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+}
+
+// NoRetry sends every call exactly once.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	apiKey     string
+	token      string
+	tenant     string
+	retry      RetryPolicy
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces http.DefaultClient, e.g. to set a timeout or
+// transport.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithAPIKey authenticates with an admin API key.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithToken authenticates with a bearer JWT. An API key, when also set, wins.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithTenant sends X-Tenant-ID on every call.
+func WithTenant(tenant string) Option {
+	return func(c *Client) { c.tenant = tenant }
+}
+
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) { c.retry = policy }
+}
+
+// New returns a client for the API at baseURL, e.g. "http://localhost:8080".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("client: invalid base URL %q", baseURL)
+	}
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		retry:      DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.retry.MaxAttempts < 1 {
+		c.retry.MaxAttempts = 1
+	}
+	return c, nil
+}
+
+// Error is an error response from the API. It unwraps to the matching
+// sentinel error, when the code has one.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    map[string]any
+	RequestID  string
+	// RetryAfter is the server's Retry-After hint, zero when absent.
+	RetryAfter time.Duration
+
+	sentinel error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s: %s (HTTP %d", e.Code, e.Message, e.StatusCode)
+	if e.RequestID != "" {
+		msg += ", request " + e.RequestID
+	}
+	return msg + ")"
+}
+
+func (e *Error) Unwrap() error { return e.sentinel }
+
+// CallOption adjusts a single call.
+type CallOption func(*call)
+
+// WithIdempotencyKey sends an Idempotency-Key, so the server answers a
+// repeated call with the first outcome. It also makes the call safe to retry.
+func WithIdempotencyKey(key string) CallOption {
+	return func(c *call) { c.header.Set(couponapi.IdempotencyKeyHeader, key) }
+}
+
+type call struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   any
+	// out receives the decoded body of a successful response, when not nil.
+	out any
+	// once marks a call that changes state again on every delivery, so it is
+	// never retried whatever its method.
+	once bool
+}
+
+func (c *call) idempotent() bool {
+	if c.once {
+		return false
+	}
+	switch c.method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return c.header.Get(couponapi.IdempotencyKeyHeader) != ""
+}
+
+func (c *Client) do(ctx context.Context, cl *call, opts ...CallOption) error {
+	if cl.header == nil {
+		cl.header = http.Header{}
+	}
+	for _, opt := range opts {
+		opt(cl)
+	}
+
+	var payload []byte
+	if cl.body != nil {
+		var err error
+		if payload, err = json.Marshal(cl.body); err != nil {
+			return fmt.Errorf("client: encode request: %w", err)
+		}
+	}
+
+	attempts := 1
+	if cl.idempotent() {
+		attempts = c.retry.MaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		wait, err := c.send(ctx, cl, payload)
+		if wait < 0 || attempt >= attempts {
+			return err
+		}
+		if wait == 0 {
+			wait = c.backoff(attempt)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// send makes one attempt. wait is negative when the outcome is final, and
+// otherwise the server's requested delay before a retry (zero if none).
+func (c *Client) send(ctx context.Context, cl *call, payload []byte) (wait time.Duration, err error) {
+	target := c.baseURL + cl.path
+	if len(cl.query) > 0 {
+		target += "?" + cl.query.Encode()
+	}
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, cl.method, target, body)
+	if err != nil {
+		return -1, fmt.Errorf("client: %w", err)
+	}
+	for key, values := range cl.header {
+		req.Header[key] = values
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case c.apiKey != "":
+		req.Header.Set(couponapi.APIKeyHeader, c.apiKey)
+	case c.token != "":
+		req.Header.Set(couponapi.AuthorizationHeader, "Bearer "+c.token)
+	}
+	if c.tenant != "" {
+		req.Header.Set(couponapi.TenantHeader, c.tenant)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := decodeError(resp)
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return apiErr.RetryAfter, apiErr
+		}
+		return -1, apiErr
+	}
+
+	if cl.out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(cl.out); err != nil {
+			return -1, fmt.Errorf("client: decode response: %w", err)
+		}
+	}
+	return -1, nil
+}
+
+func decodeError(resp *http.Response) *Error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	var body couponapi.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Code == "" {
+		// Not from the API itself, e.g. a proxy error page.
+		apiErr.Code = "unexpected_response"
+		apiErr.Message = resp.Status
+	} else {
+		apiErr.Code = body.Code
+		apiErr.Message = body.Message
+		apiErr.Details = body.Details
+		apiErr.RequestID = body.RequestID
+		apiErr.sentinel = couponapi.ErrorForCode(body.Code)
+	}
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get(couponapi.RequestIDHeader)
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// backoff doubles from MinBackoff up to MaxBackoff, with jitter so that
+// clients rejected together do not retry together.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.retry.MinBackoff << (attempt - 1)
+	if d <= 0 || (c.retry.MaxBackoff > 0 && d > c.retry.MaxBackoff) {
+		d = c.retry.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
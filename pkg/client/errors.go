@@ -0,0 +1,46 @@
+package client
+
+import "scalable-coupon-system/pkg/couponapi"
+
+// The server's sentinel errors. An *Error from a call unwraps to the one
+// matching its code, so callers can use errors.Is.
+var (
+	ErrCouponAlreadyExists      = couponapi.ErrCouponAlreadyExists
+	ErrCouponNotFound           = couponapi.ErrCouponNotFound
+	ErrClaimLimitReached        = couponapi.ErrClaimLimitReached
+	ErrCouponOutOfStock         = couponapi.ErrCouponOutOfStock
+	ErrCouponNotYetActive       = couponapi.ErrCouponNotYetActive
+	ErrCouponExpired            = couponapi.ErrCouponExpired
+	ErrClaimNotFound            = couponapi.ErrClaimNotFound
+	ErrClaimNotRedeemable       = couponapi.ErrClaimNotRedeemable
+	ErrClaimNotVoidable         = couponapi.ErrClaimNotVoidable
+	ErrClaimNotReleasable       = couponapi.ErrClaimNotReleasable
+	ErrVersionMismatch          = couponapi.ErrVersionMismatch
+	ErrAmountBelowClaimed       = couponapi.ErrAmountBelowClaimed
+	ErrInvalidCursor            = couponapi.ErrInvalidCursor
+	ErrInvalidStatusFilter      = couponapi.ErrInvalidStatusFilter
+	ErrInvalidSort              = couponapi.ErrInvalidSort
+	ErrClaimContention          = couponapi.ErrClaimContention
+	ErrReservationNotFound      = couponapi.ErrReservationNotFound
+	ErrReservationNotActive     = couponapi.ErrReservationNotActive
+	ErrReservationExpired       = couponapi.ErrReservationExpired
+	ErrInvalidReservationTTL    = couponapi.ErrInvalidReservationTTL
+	ErrCouponInStock            = couponapi.ErrCouponInStock
+	ErrAlreadyOnWaitlist        = couponapi.ErrAlreadyOnWaitlist
+	ErrWaitlistEntryNotFound    = couponapi.ErrWaitlistEntryNotFound
+	ErrIdempotencyKeyReused     = couponapi.ErrIdempotencyKeyReused
+	ErrIdempotencyKeyInProgress = couponapi.ErrIdempotencyKeyInProgress
+	ErrInvalidValidityWindow    = couponapi.ErrInvalidValidityWindow
+	ErrInvalidTenant            = couponapi.ErrInvalidTenant
+	ErrValidationFailed         = couponapi.ErrValidationFailed
+	ErrAdminRequired            = couponapi.ErrAdminRequired
+	ErrUserMismatch             = couponapi.ErrUserMismatch
+	ErrUserIDRequired           = couponapi.ErrUserIDRequired
+	ErrTenantMismatch           = couponapi.ErrTenantMismatch
+
+	ErrMissingCredentials = couponapi.ErrMissingCredentials
+	ErrInvalidAPIKey      = couponapi.ErrInvalidAPIKey
+	ErrTokenExpired       = couponapi.ErrTokenExpired
+	ErrTokenNotYetValid   = couponapi.ErrTokenNotYetValid
+	ErrInvalidToken       = couponapi.ErrInvalidToken
+)
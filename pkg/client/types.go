@@ -0,0 +1,32 @@
+package client
+
+import "scalable-coupon-system/pkg/couponapi"
+
+// The request and response types are the server's own, from couponapi, so the
+// client cannot drift from the API. They are aliased so callers need only
+// import this package.
+type (
+	CreateCouponRequest      = couponapi.CreateCouponRequest
+	UpdateCouponRequest      = couponapi.UpdateCouponRequest
+	ListCouponsRequest       = couponapi.ListCouponsRequest
+	ListCouponClaimsRequest  = couponapi.ListCouponClaimsRequest
+	ListUserClaimsRequest    = couponapi.ListUserClaimsRequest
+	ClaimCouponRequest       = couponapi.ClaimCouponRequest
+	RedeemCouponRequest      = couponapi.RedeemCouponRequest
+	VoidCouponRequest        = couponapi.VoidCouponRequest
+	CreateReservationRequest = couponapi.CreateReservationRequest
+	JoinWaitlistRequest      = couponapi.JoinWaitlistRequest
+
+	GetCouponDetailsResponse = couponapi.GetCouponDetailsResponse
+	CouponSummaryResponse    = couponapi.CouponSummaryResponse
+	ListCouponsResponse      = couponapi.ListCouponsResponse
+	ClaimResponse            = couponapi.ClaimResponse
+	UserClaimResponse        = couponapi.UserClaimResponse
+	ListUserClaimsResponse   = couponapi.ListUserClaimsResponse
+	ListCouponClaimsResponse = couponapi.ListCouponClaimsResponse
+	ReservationResponse      = couponapi.ReservationResponse
+	WaitlistEntryResponse    = couponapi.WaitlistEntryResponse
+)
+
+// MaxPageSize is the largest page the list endpoints return.
+const MaxPageSize = couponapi.MaxPageSize
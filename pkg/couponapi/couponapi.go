@@ -0,0 +1,22 @@
+// Package couponapi is the wire contract of the coupon API: request and
+// response bodies, headers, error codes and the sentinel errors they stand
+// for. It depends only on the standard library, so the server and its clients
+// share it without clients pulling in the server's storage.
+package couponapi
+
+const (
+	AuthorizationHeader      = "Authorization"
+	APIKeyHeader             = "X-API-Key"
+	TenantHeader             = "X-Tenant-ID"
+	RequestIDHeader          = "X-Request-ID"
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// MaxClaimedByDetails caps the claimed_by list on the details endpoint; the
+// complete list is paginated through the coupon claims endpoint.
+const MaxClaimedByDetails = 100
+
+// MaxPageSize is the largest page the list endpoints return; larger limits
+// are capped to it.
+const MaxPageSize = 100
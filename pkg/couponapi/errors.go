@@ -0,0 +1,145 @@
+package couponapi
+
+import "errors"
+
+// Error codes are part of the API contract: clients branch on them, so they
+// must never change once released. Messages may be reworded freely.
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodePreconditionRequired = "precondition_required"
+	CodeInternal             = "internal_error"
+
+	// Codes of the sentinel errors below.
+	CodeCouponAlreadyExists      = "coupon_already_exists"
+	CodeCouponNotFound           = "coupon_not_found"
+	CodeClaimLimitReached        = "claim_limit_reached"
+	CodeCouponOutOfStock         = "coupon_out_of_stock"
+	CodeCouponNotYetActive       = "coupon_not_yet_active"
+	CodeCouponExpired            = "coupon_expired"
+	CodeClaimNotFound            = "claim_not_found"
+	CodeClaimNotRedeemable       = "claim_not_redeemable"
+	CodeClaimNotVoidable         = "claim_not_voidable"
+	CodeClaimNotReleasable       = "claim_not_releasable"
+	CodeVersionMismatch          = "version_mismatch"
+	CodeAmountBelowClaimed       = "amount_below_claimed"
+	CodeInvalidCursor            = "invalid_cursor"
+	CodeInvalidStatusFilter      = "invalid_status_filter"
+	CodeInvalidSort              = "invalid_sort"
+	CodeClaimContention          = "claim_contention"
+	CodeReservationNotFound      = "reservation_not_found"
+	CodeReservationNotActive     = "reservation_not_active"
+	CodeReservationExpired       = "reservation_expired"
+	CodeInvalidReservationTTL    = "invalid_reservation_ttl"
+	CodeCouponInStock            = "coupon_in_stock"
+	CodeAlreadyOnWaitlist        = "already_on_waitlist"
+	CodeWaitlistEntryNotFound    = "waitlist_entry_not_found"
+	CodeIdempotencyKeyReused     = "idempotency_key_reused"
+	CodeIdempotencyKeyInProgress = "idempotency_key_in_progress"
+	CodeInvalidValidityWindow    = "invalid_validity_window"
+	CodeInvalidTenant            = "invalid_tenant"
+	CodeValidationFailed         = "validation_failed"
+	CodeAdminRequired            = "admin_required"
+	CodeUserMismatch             = "user_mismatch"
+	CodeUserIDRequired           = "user_id_required"
+	CodeTenantMismatch           = "tenant_mismatch"
+	CodeMissingCredentials       = "missing_credentials"
+	CodeInvalidAPIKey            = "invalid_api_key"
+	CodeTokenExpired             = "token_expired"
+	CodeTokenNotYetValid         = "token_not_yet_valid"
+	CodeInvalidToken             = "invalid_token"
+)
+
+// The sentinel errors behind the error codes. The server answers each with
+// its code, and ErrorForCode maps the code back, so a client can match an
+// error response with errors.Is.
+var (
+	ErrCouponAlreadyExists = errors.New("coupon already exists")
+	ErrCouponNotFound      = errors.New("coupon not found")
+	ErrClaimLimitReached   = errors.New("per-user claim limit reached")
+	ErrCouponOutOfStock    = errors.New("coupon out of stock")
+	ErrCouponNotYetActive  = errors.New("coupon not yet active")
+	ErrCouponExpired       = errors.New("coupon expired")
+	ErrClaimNotFound       = errors.New("claim not found")
+	ErrClaimNotRedeemable  = errors.New("no redeemable claim")
+	ErrClaimNotVoidable    = errors.New("claim cannot be voided")
+	ErrClaimNotReleasable  = errors.New("no releasable claim")
+	ErrVersionMismatch     = errors.New("coupon was modified by another request")
+	ErrAmountBelowClaimed  = errors.New("amount cannot be lower than claimed and reserved stock")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrInvalidStatusFilter = errors.New("invalid status filter")
+	ErrInvalidSort         = errors.New("invalid sort")
+	ErrClaimContention     = errors.New("too many concurrent claims, retry")
+
+	ErrReservationNotFound   = errors.New("reservation not found")
+	ErrReservationNotActive  = errors.New("reservation is no longer active")
+	ErrReservationExpired    = errors.New("reservation expired")
+	ErrInvalidReservationTTL = errors.New("invalid reservation ttl")
+
+	ErrCouponInStock         = errors.New("coupon is in stock, claim it instead")
+	ErrAlreadyOnWaitlist     = errors.New("user is already on the waitlist")
+	ErrWaitlistEntryNotFound = errors.New("user is not on the waitlist")
+
+	ErrIdempotencyKeyReused     = errors.New("idempotency key already used with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("request with this idempotency key is still in progress")
+
+	ErrInvalidValidityWindow = errors.New("ends_at must be after starts_at")
+	ErrInvalidTenant         = errors.New("invalid tenant id")
+	ErrValidationFailed      = errors.New("validation failed")
+
+	ErrAdminRequired  = errors.New("admin role required")
+	ErrUserMismatch   = errors.New("user_id does not match the authenticated user")
+	ErrUserIDRequired = errors.New("user_id required")
+	ErrTenantMismatch = errors.New("tenant does not match the authenticated tenant")
+
+	ErrMissingCredentials = errors.New("missing credentials")
+	ErrInvalidAPIKey      = errors.New("invalid api key")
+	ErrTokenExpired       = errors.New("token expired")
+	ErrTokenNotYetValid   = errors.New("token not yet valid")
+	ErrInvalidToken       = errors.New("invalid token")
+)
+
+var codeErrors = map[string]error{
+	CodeCouponAlreadyExists:      ErrCouponAlreadyExists,
+	CodeCouponNotFound:           ErrCouponNotFound,
+	CodeClaimLimitReached:        ErrClaimLimitReached,
+	CodeCouponOutOfStock:         ErrCouponOutOfStock,
+	CodeCouponNotYetActive:       ErrCouponNotYetActive,
+	CodeCouponExpired:            ErrCouponExpired,
+	CodeClaimNotFound:            ErrClaimNotFound,
+	CodeClaimNotRedeemable:       ErrClaimNotRedeemable,
+	CodeClaimNotVoidable:         ErrClaimNotVoidable,
+	CodeClaimNotReleasable:       ErrClaimNotReleasable,
+	CodeVersionMismatch:          ErrVersionMismatch,
+	CodeAmountBelowClaimed:       ErrAmountBelowClaimed,
+	CodeInvalidCursor:            ErrInvalidCursor,
+	CodeInvalidStatusFilter:      ErrInvalidStatusFilter,
+	CodeInvalidSort:              ErrInvalidSort,
+	CodeClaimContention:          ErrClaimContention,
+	CodeReservationNotFound:      ErrReservationNotFound,
+	CodeReservationNotActive:     ErrReservationNotActive,
+	CodeReservationExpired:       ErrReservationExpired,
+	CodeInvalidReservationTTL:    ErrInvalidReservationTTL,
+	CodeCouponInStock:            ErrCouponInStock,
+	CodeAlreadyOnWaitlist:        ErrAlreadyOnWaitlist,
+	CodeWaitlistEntryNotFound:    ErrWaitlistEntryNotFound,
+	CodeIdempotencyKeyReused:     ErrIdempotencyKeyReused,
+	CodeIdempotencyKeyInProgress: ErrIdempotencyKeyInProgress,
+	CodeInvalidValidityWindow:    ErrInvalidValidityWindow,
+	CodeInvalidTenant:            ErrInvalidTenant,
+	CodeValidationFailed:         ErrValidationFailed,
+	CodeAdminRequired:            ErrAdminRequired,
+	CodeUserMismatch:             ErrUserMismatch,
+	CodeUserIDRequired:           ErrUserIDRequired,
+	CodeTenantMismatch:           ErrTenantMismatch,
+	CodeMissingCredentials:       ErrMissingCredentials,
+	CodeInvalidAPIKey:            ErrInvalidAPIKey,
+	CodeTokenExpired:             ErrTokenExpired,
+	CodeTokenNotYetValid:         ErrTokenNotYetValid,
+	CodeInvalidToken:             ErrInvalidToken,
+}
+
+// ErrorForCode returns the sentinel error answered with code, or nil when the
+// code has none.
+func ErrorForCode(code string) error {
+	return codeErrors[code]
+}
@@ -0,0 +1,71 @@
+package couponapi
+
+import "time"
+
+type CreateCouponRequest struct {
+	Name             string     `json:"name"`
+	Amount           int        `json:"amount"`
+	MaxClaimsPerUser int        `json:"max_claims_per_user,omitempty"`
+	StartsAt         *time.Time `json:"starts_at,omitempty"`
+	EndsAt           *time.Time `json:"ends_at,omitempty"`
+}
+
+// UpdateCouponRequest is a partial update: nil fields keep their current value.
+type UpdateCouponRequest struct {
+	Amount           *int       `json:"amount,omitempty"`
+	MaxClaimsPerUser *int       `json:"max_claims_per_user,omitempty"`
+	StartsAt         *time.Time `json:"starts_at,omitempty"`
+	EndsAt           *time.Time `json:"ends_at,omitempty"`
+}
+
+type ListCouponsRequest struct {
+	NamePrefix    string
+	Status        string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Cursor        string
+	Limit         int
+}
+
+type ListCouponClaimsRequest struct {
+	CouponName string
+	Cursor     string
+	Limit      int
+}
+
+type ListUserClaimsRequest struct {
+	UserID string
+	Status string
+	Sort   string
+	Order  string
+	Cursor string
+	Limit  int
+}
+
+type ClaimCouponRequest struct {
+	UserId     string `json:"user_id"`
+	CouponName string `json:"coupon_name"`
+}
+
+// CreateReservationRequest holds stock for TTLSeconds, or for the deployment's
+// default reservation TTL when it is zero.
+type CreateReservationRequest struct {
+	UserId     string `json:"user_id"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+}
+
+type JoinWaitlistRequest struct {
+	UserId string `json:"user_id"`
+}
+
+type RedeemCouponRequest struct {
+	UserId     string `json:"user_id"`
+	CouponName string `json:"coupon_name"`
+	OrderRef   string `json:"order_ref"`
+}
+
+type VoidCouponRequest struct {
+	UserId     string `json:"user_id"`
+	CouponName string `json:"coupon_name"`
+	OrderRef   string `json:"order_ref"`
+}
@@ -0,0 +1,88 @@
+package couponapi
+
+import "time"
+
+type GetCouponDetailsResponse struct {
+	Name             string     `json:"name"`
+	Amount           int        `json:"amount"`
+	RemainingAmount  int        `json:"remaining_amount"`
+	ClaimedCount     int        `json:"claimed_count"`
+	ReservedCount    int        `json:"reserved_count"`
+	MaxClaimsPerUser int        `json:"max_claims_per_user"`
+	StartsAt         *time.Time `json:"starts_at,omitempty"`
+	EndsAt           *time.Time `json:"ends_at,omitempty"`
+	Version          int64      `json:"version"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ClaimedBy        []string   `json:"claimed_by,omitempty"`
+}
+
+type CouponSummaryResponse struct {
+	Name             string     `json:"name"`
+	Amount           int        `json:"amount"`
+	RemainingAmount  int        `json:"remaining_amount"`
+	MaxClaimsPerUser int        `json:"max_claims_per_user"`
+	StartsAt         *time.Time `json:"starts_at,omitempty"`
+	EndsAt           *time.Time `json:"ends_at,omitempty"`
+	Version          int64      `json:"version"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+type ListCouponsResponse struct {
+	Coupons    []CouponSummaryResponse `json:"coupons"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+}
+
+type ClaimResponse struct {
+	ID         int64     `json:"id"`
+	UserID     string    `json:"user_id"`
+	CouponName string    `json:"coupon_name"`
+	Status     string    `json:"status"`
+	OrderRef   *string   `json:"order_ref,omitempty"`
+	ClaimedAt  time.Time `json:"claimed_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type ReservationResponse struct {
+	ID         string    `json:"id"`
+	CouponName string    `json:"coupon_name"`
+	UserID     string    `json:"user_id"`
+	Status     string    `json:"status"`
+	ClaimID    *int64    `json:"claim_id,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type WaitlistEntryResponse struct {
+	CouponName string    `json:"coupon_name"`
+	UserID     string    `json:"user_id"`
+	Status     string    `json:"status"`
+	Position   int       `json:"position,omitempty"`
+	ClaimID    *int64    `json:"claim_id,omitempty"`
+	JoinedAt   time.Time `json:"joined_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type UserClaimResponse struct {
+	ClaimResponse
+	Coupon CouponSummaryResponse `json:"coupon"`
+}
+
+type ListUserClaimsResponse struct {
+	Claims     []UserClaimResponse `json:"claims"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+type ListCouponClaimsResponse struct {
+	Claims     []ClaimResponse `json:"claims"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// ErrorResponse is the body of every error response. Code is stable and meant
+// for programs; Message is for people.
+type ErrorResponse struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
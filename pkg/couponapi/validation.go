@@ -0,0 +1,164 @@
+package couponapi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Input limits. The migration enforces the same rules with CHECK constraints,
+// so they must change together.
+const (
+	MaxCouponNameLength = 64
+	MaxCouponAmount     = 1_000_000
+	MaxUserIDLength     = 128
+	MaxOrderRefLength   = 255
+)
+
+// FieldError describes one invalid field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError reports every invalid field of a request at once. It
+// matches ErrValidationFailed.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		parts = append(parts, f.Field+": "+f.Message)
+	}
+	return fmt.Sprintf("%s: %s", ErrValidationFailed.Error(), strings.Join(parts, "; "))
+}
+
+func (e *ValidationError) Unwrap() error { return ErrValidationFailed }
+
+type validator struct {
+	fields []FieldError
+}
+
+func (v *validator) add(field string, format string, args ...any) {
+	v.fields = append(v.fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+// couponName accepts 1 to 64 ASCII letters, digits, '-' and '_'.
+func (v *validator) couponName(field string, name string) {
+	switch {
+	case name == "":
+		v.add(field, "required")
+	case len(name) > MaxCouponNameLength:
+		v.add(field, "must be at most %d characters", MaxCouponNameLength)
+	case !onlyChars(name, "-_"):
+		v.add(field, "may only contain letters, digits, '-' and '_'")
+	}
+}
+
+// userID accepts 1 to 128 ASCII letters, digits and "-_.@:", which covers
+// UUIDs, numeric ids and e-mail style ids.
+func (v *validator) userID(field string, userID string) {
+	switch {
+	case userID == "":
+		v.add(field, "required")
+	case len(userID) > MaxUserIDLength:
+		v.add(field, "must be at most %d characters", MaxUserIDLength)
+	case !onlyChars(userID, "-_.@:"):
+		v.add(field, "may only contain letters, digits and '-', '_', '.', '@', ':'")
+	}
+}
+
+func (v *validator) amount(field string, amount int) {
+	if amount < 1 || amount > MaxCouponAmount {
+		v.add(field, "must be between 1 and %d", MaxCouponAmount)
+	}
+}
+
+func (v *validator) orderRef(field string, orderRef string) {
+	switch {
+	case orderRef == "":
+		v.add(field, "required")
+	case len(orderRef) > MaxOrderRefLength:
+		v.add(field, "must be at most %d characters", MaxOrderRefLength)
+	case strings.IndexFunc(orderRef, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0:
+		v.add(field, "must not contain control characters")
+	}
+}
+
+func onlyChars(value string, extra string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte(extra, c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func (req CreateCouponRequest) Validate() error {
+	var v validator
+	v.couponName("name", req.Name)
+	v.amount("amount", req.Amount)
+	// Zero selects the server's default of one claim per user.
+	if req.MaxClaimsPerUser < 0 || req.MaxClaimsPerUser > MaxCouponAmount {
+		v.add("max_claims_per_user", "must be between 1 and %d", MaxCouponAmount)
+	}
+	return v.err()
+}
+
+func (req UpdateCouponRequest) Validate() error {
+	var v validator
+	if req.Amount != nil {
+		v.amount("amount", *req.Amount)
+	}
+	if req.MaxClaimsPerUser != nil && (*req.MaxClaimsPerUser < 1 || *req.MaxClaimsPerUser > MaxCouponAmount) {
+		v.add("max_claims_per_user", "must be between 1 and %d", MaxCouponAmount)
+	}
+	return v.err()
+}
+
+func (req ClaimCouponRequest) Validate() error {
+	var v validator
+	v.userID("user_id", req.UserId)
+	v.couponName("coupon_name", req.CouponName)
+	return v.err()
+}
+
+func (req CreateReservationRequest) Validate() error {
+	var v validator
+	v.userID("user_id", req.UserId)
+	if req.TTLSeconds < 0 {
+		v.add("ttl_seconds", "must not be negative")
+	}
+	return v.err()
+}
+
+func (req JoinWaitlistRequest) Validate() error {
+	var v validator
+	v.userID("user_id", req.UserId)
+	return v.err()
+}
+
+func (req RedeemCouponRequest) Validate() error {
+	var v validator
+	v.userID("user_id", req.UserId)
+	v.couponName("coupon_name", req.CouponName)
+	v.orderRef("order_ref", req.OrderRef)
+	return v.err()
+}
+
+func (req VoidCouponRequest) Validate() error {
+	var v validator
+	v.userID("user_id", req.UserId)
+	v.couponName("coupon_name", req.CouponName)
+	v.orderRef("order_ref", req.OrderRef)
+	return v.err()
+}